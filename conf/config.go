@@ -240,6 +240,47 @@ func FlushConfigurer(data []byte) (c Config, success bool) {
 	return ReloadConfigurer(""), true
 }
 
+// Redacted dump config as plain values, values of keys matched by sensitive are replaced with "******".
+func Redacted(c Config, sensitive func(key string) bool) any {
+	var root *ho.HoconValue
+	switch v := c.(type) {
+	case config:
+		root = v.Root()
+	case *config:
+		root = v.Root()
+	default:
+		panic("invalid config instance")
+	}
+	return redact(root, sensitive)
+}
+
+func redact(v *ho.HoconValue, sensitive func(key string) bool) any {
+	switch {
+	case v == nil:
+		return nil
+	case v.IsObject():
+		o := v.GetObject()
+		m := make(map[string]any, len(o.GetKeys()))
+		for _, k := range o.GetKeys() {
+			if sensitive != nil && sensitive(k) {
+				m[k] = "******"
+			} else {
+				m[k] = redact(o.GetKey(k), sensitive)
+			}
+		}
+		return m
+	case v.IsArray():
+		a := v.GetArray()
+		r := make([]any, len(a))
+		for i, x := range a {
+			r[i] = redact(x, sensitive)
+		}
+		return r
+	default:
+		return v.GetString()
+	}
+}
+
 func Empty() Config {
 	return config{Config: hocon.NewConfigFromRoot(ho.NewHoconRoot(ho.NewHoconValue()))}
 }
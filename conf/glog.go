@@ -4,6 +4,7 @@ package conf
 
 import (
 	"context"
+	"flag"
 	"github.com/golang/glog"
 )
 
@@ -61,3 +62,16 @@ func (w adaptor) ErrorContextf(ctx context.Context, format string, v ...any) {
 func checkLogger() {
 	i = adaptor{}
 }
+
+// LogLevel current verbosity of glog, in form of v=N
+func LogLevel() string {
+	if f := flag.Lookup("v"); f != nil {
+		return "v=" + f.Value.String()
+	}
+	return ""
+}
+
+// SetLogLevel change verbosity of glog, level is the value of flag v
+func SetLogLevel(level string) error {
+	return flag.Set("v", level)
+}
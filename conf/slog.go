@@ -18,6 +18,7 @@ import (
 
 var (
 	handler *RotateFileHandler
+	level   = new(slog.LevelVar)
 )
 
 type RotateFileHandler struct {
@@ -56,12 +57,10 @@ func checkLogger() {
 	opt := new(slog.HandlerOptions)
 	{
 		opt.AddSource = conf.GetBoolean("log.source", true)
-		lever := new(slog.Level)
-		if err := lever.UnmarshalText([]byte(conf.GetString("log.level", "info"))); err == nil {
-			opt.Level = lever
-		} else {
-			opt.Level = slog.LevelInfo
+		if err := SetLogLevel(conf.GetString("log.level", "info")); err != nil {
+			level.Set(slog.LevelInfo)
 		}
+		opt.Level = level
 	}
 	if logFile == "" {
 		log := slog.New(slog.NewJSONHandler(os.Stdout, opt))
@@ -82,6 +81,16 @@ func checkLogger() {
 	i = adaptor{slog.Default()}
 }
 
+// LogLevel current level of slog
+func LogLevel() string {
+	return level.Level().String()
+}
+
+// SetLogLevel change level of slog, such as debug, info, warn or error
+func SetLogLevel(v string) error {
+	return level.UnmarshalText([]byte(v))
+}
+
 type adaptor struct {
 	l *slog.Logger
 }
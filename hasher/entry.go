@@ -2,8 +2,14 @@ package hasher
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
 )
 
 func ObscureInt(i ...int) string {
@@ -36,3 +42,151 @@ func ClarifyInt(v string) (o []int, e error) {
 	}
 	return
 }
+
+type (
+	// ObscureKey a keyed secret for [Obscurer], the ID is written as the first byte of every obscured value.
+	ObscureKey struct {
+		ID     byte
+		Secret []byte //at least 16 bytes
+	}
+	// Obscurer produce compact url safe strings of integers, which are encrypted and authenticated.
+	//
+	// The output is deterministic: same key, namespace and values always produce the same string.
+	// Layout before base64: keyID(1) | tag(8) | ciphertext of varints.
+	// The tag is HMAC-SHA256 over keyID, namespace and plain varints, it's also used as the AES-CTR IV.
+	Obscurer struct {
+		current *obscureKey
+		keys    map[byte]*obscureKey
+	}
+	// ObscureNamespace an [Obscurer] bound to a namespace, values obscured in one namespace can't be clarified in another.
+	ObscureNamespace struct {
+		o  *Obscurer
+		ns string
+	}
+	obscureKey struct {
+		id    byte
+		block cipher.Block
+		mac   []byte
+	}
+)
+
+const obscureTagSize = 8
+
+var (
+	// ErrObscureTampered the obscured value is malformed or modified
+	ErrObscureTampered = errors.New("obscured value tampered")
+	// ErrObscureUnknownKey the key id of obscured value not registered
+	ErrObscureUnknownKey = errors.New("obscured value with unknown key")
+	// ErrObscureWeakKey the secret of key is too short
+	ErrObscureWeakKey = errors.New("obscure key secret requires at least 16 bytes")
+	// ErrObscureNoKey no key is given to create [Obscurer]
+	ErrObscureNoKey = errors.New("obscure key required")
+)
+
+func deriveKey(secret []byte, usage string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(usage))
+	return h.Sum(nil)
+}
+
+// NewObscurer create [Obscurer] with keys, the first key is used to obscure,
+// all keys are used to clarify, so older keys can be kept for rotation.
+func NewObscurer(keys ...ObscureKey) (o *Obscurer, err error) {
+	if len(keys) == 0 {
+		return nil, ErrObscureNoKey
+	}
+	o = &Obscurer{keys: make(map[byte]*obscureKey, len(keys))}
+	for i, k := range keys {
+		if len(k.Secret) < 16 {
+			return nil, ErrObscureWeakKey
+		}
+		x := &obscureKey{id: k.ID, mac: deriveKey(k.Secret, "gofra.obscure.mac")}
+		if x.block, err = aes.NewCipher(deriveKey(k.Secret, "gofra.obscure.enc")); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			o.current = x
+		}
+		if _, ok := o.keys[k.ID]; !ok {
+			o.keys[k.ID] = x
+		}
+	}
+	return
+}
+
+func (k *obscureKey) tag(ns string, plain []byte) []byte {
+	h := hmac.New(sha256.New, k.mac)
+	h.Write([]byte{k.id})
+	h.Write(binary.AppendUvarint(nil, uint64(len(ns))))
+	h.Write([]byte(ns))
+	h.Write(plain)
+	return h.Sum(nil)[:obscureTagSize]
+}
+func (k *obscureKey) xor(tag, dst, src []byte) {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, tag)
+	cipher.NewCTR(k.block, iv).XORKeyStream(dst, src)
+}
+
+// ObscureInt obscure values in namespace ns, empty ns is a valid namespace.
+func (o *Obscurer) ObscureInt(ns string, i ...int) string {
+	plain := binary.AppendUvarint(nil, uint64(len(i)))
+	for _, i2 := range i {
+		plain = binary.AppendVarint(plain, int64(i2))
+	}
+	k := o.current
+	buf := make([]byte, 1+obscureTagSize+len(plain))
+	buf[0] = k.id
+	tag := k.tag(ns, plain)
+	copy(buf[1:], tag)
+	k.xor(tag, buf[1+obscureTagSize:], plain)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// ClarifyInt restore values obscured in namespace ns, returns [ErrObscureTampered] when the value is modified
+// or obscured in another namespace.
+func (o *Obscurer) ClarifyInt(ns string, v string) (out []int, err error) {
+	var buf []byte
+	if buf, err = base64.RawURLEncoding.DecodeString(v); err != nil || len(buf) < 2+obscureTagSize {
+		return nil, ErrObscureTampered
+	}
+	k, ok := o.keys[buf[0]]
+	if !ok {
+		return nil, ErrObscureUnknownKey
+	}
+	tag := buf[1 : 1+obscureTagSize]
+	plain := make([]byte, len(buf)-1-obscureTagSize)
+	k.xor(tag, plain, buf[1+obscureTagSize:])
+	if subtle.ConstantTimeCompare(tag, k.tag(ns, plain)) != 1 {
+		return nil, ErrObscureTampered
+	}
+	r := bytes.NewReader(plain)
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(len(plain)) {
+		return nil, ErrObscureTampered
+	}
+	out = make([]int, 0, n)
+	for i := uint64(0); i < n; i++ {
+		x, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, ErrObscureTampered
+		}
+		out = append(out, int(x))
+	}
+	return
+}
+
+// Namespace bind the [Obscurer] to namespace ns
+func (o *Obscurer) Namespace(ns string) ObscureNamespace {
+	return ObscureNamespace{o: o, ns: ns}
+}
+
+// ObscureInt see [Obscurer.ObscureInt]
+func (n ObscureNamespace) ObscureInt(i ...int) string {
+	return n.o.ObscureInt(n.ns, i...)
+}
+
+// ClarifyInt see [Obscurer.ClarifyInt]
+func (n ObscureNamespace) ClarifyInt(v string) ([]int, error) {
+	return n.o.ClarifyInt(n.ns, v)
+}
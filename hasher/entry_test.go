@@ -0,0 +1,98 @@
+package hasher
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var (
+	obscureKey1 = ObscureKey{ID: 1, Secret: []byte("0123456789abcdef-one")}
+	obscureKey2 = ObscureKey{ID: 2, Secret: []byte("0123456789abcdef-two")}
+)
+
+func TestObscurerRoundTrip(t *testing.T) {
+	o, err := NewObscurer(obscureKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range [][]int{{}, {0}, {1, -1}, {1 << 40, -(1 << 40), 7}} {
+		s := o.ObscureInt("user", v...)
+		if s != o.ObscureInt("user", v...) {
+			t.Fatalf("%v: not deterministic", v)
+		}
+		got, err := o.ClarifyInt("user", s)
+		if err != nil || len(got) != len(v) || len(v) > 0 && !reflect.DeepEqual(got, v) {
+			t.Fatalf("%v: got %v %v", v, got, err)
+		}
+	}
+	if _, err = NewObscurer(); !errors.Is(err, ErrObscureNoKey) {
+		t.Fatal(err)
+	}
+	if _, err = NewObscurer(ObscureKey{ID: 1, Secret: []byte("short")}); !errors.Is(err, ErrObscureWeakKey) {
+		t.Fatal(err)
+	}
+}
+
+func TestObscurerTamper(t *testing.T) {
+	o, _ := NewObscurer(obscureKey1)
+	buf, _ := base64.RawURLEncoding.DecodeString(o.ObscureInt("", 42, 43))
+	for i := 1; i < len(buf); i++ {
+		x := append([]byte(nil), buf...)
+		x[i] ^= 0x01
+		if _, err := o.ClarifyInt("", base64.RawURLEncoding.EncodeToString(x)); !errors.Is(err, ErrObscureTampered) {
+			t.Fatalf("flip byte %d: %v", i, err)
+		}
+	}
+	for _, v := range []string{"", "!!", base64.RawURLEncoding.EncodeToString(buf[:5]), base64.RawURLEncoding.EncodeToString(buf[:len(buf)-1])} {
+		if _, err := o.ClarifyInt("", v); !errors.Is(err, ErrObscureTampered) {
+			t.Fatalf("%q: %v", v, err)
+		}
+	}
+}
+
+func TestObscurerRotation(t *testing.T) {
+	old, _ := NewObscurer(obscureKey1)
+	s := old.ObscureInt("", 42)
+	rotated, err := NewObscurer(obscureKey2, obscureKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := rotated.ClarifyInt("", s); err != nil || got[0] != 42 {
+		t.Fatalf("old value: %v %v", got, err)
+	}
+	fresh := rotated.ObscureInt("", 42)
+	if fresh == s {
+		t.Fatal("obscure with old key after rotation")
+	}
+	if _, err = old.ClarifyInt("", fresh); !errors.Is(err, ErrObscureUnknownKey) {
+		t.Fatal(err)
+	}
+	retired, _ := NewObscurer(obscureKey2)
+	if _, err = retired.ClarifyInt("", s); !errors.Is(err, ErrObscureUnknownKey) {
+		t.Fatal(err)
+	}
+	forged, _ := NewObscurer(ObscureKey{ID: 1, Secret: []byte("another secret of key one")})
+	if _, err = forged.ClarifyInt("", s); !errors.Is(err, ErrObscureTampered) {
+		t.Fatal(err)
+	}
+}
+
+func TestObscurerNamespace(t *testing.T) {
+	o, _ := NewObscurer(obscureKey1)
+	users, orders := o.Namespace("user"), o.Namespace("order")
+	u := users.ObscureInt(42)
+	if u == orders.ObscureInt(42) {
+		t.Fatal("same output in different namespaces")
+	}
+	if got, err := users.ClarifyInt(u); err != nil || got[0] != 42 {
+		t.Fatal(got, err)
+	}
+	if _, err := orders.ClarifyInt(u); !errors.Is(err, ErrObscureTampered) {
+		t.Fatal(err)
+	}
+	if _, err := o.ClarifyInt("", u); !errors.Is(err, ErrObscureTampered) {
+		t.Fatal(err)
+	}
+}
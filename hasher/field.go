@@ -0,0 +1,418 @@
+package hasher
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ZenLiuCN/gofra/conf"
+)
+
+type (
+	// KeyWrapper holds master keys which wrap data keys, it can be backed by a KMS.
+	KeyWrapper interface {
+		Current() string                                         //current master key id
+		Wrap(dek []byte) (kid string, wrapped []byte, err error) //wrap data key with current master key
+		Unwrap(kid string, wrapped []byte) ([]byte, error)       //unwrap data key with master key of kid
+		Deterministic(kid string) ([]byte, error)                //the fixed data key of kid for deterministic encryption
+	}
+	// FieldCipher encrypt field values with AES-GCM in an envelope scheme.
+	//
+	// Random mode: each value has its own data key, wrapped by the current master key and stored along with the value.
+	// Deterministic mode: values use the fixed data key of the master key, with a synthetic nonce computed from the plain text,
+	// so equal plain texts produce equal cipher texts which remain searchable by equality.
+	// The cipher text depends on the master key: after the current key changed, rows written under older keys
+	// must be re-encrypted by [FieldCipher.Rotate], until then query with all values from [FieldCipher.Lookup].
+	//
+	// Layout before base64: mode(1) | len(kid)(1) | kid | [len(wrapped)(uvarint) | wrapped] | nonce(12) | sealed.
+	FieldCipher struct {
+		Keys KeyWrapper
+	}
+	// LocalKeyring a [KeyWrapper] with master keys in memory
+	LocalKeyring struct {
+		current string
+		keys    map[string]cipher.AEAD
+		det     map[string][]byte
+	}
+	// Encrypted value stored encrypted by [DefaultFieldCipher] in random mode, marshal to json as plain value.
+	// !Important T type must not a pointer.
+	Encrypted[T any] struct {
+		V     T    //the real value of T
+		Valid bool // dose this value is nil
+	}
+	// Deterministic value stored encrypted by [DefaultFieldCipher] in deterministic mode, marshal to json as plain value.
+	// Use [Deterministic.Value] as query argument to search by equality, which only matches rows of the current master key,
+	// see [FieldCipher] for key rotation.
+	// !Important T type must not a pointer.
+	Deterministic[T any] struct {
+		V     T    //the real value of T
+		Valid bool // dose this value is nil
+	}
+)
+
+const (
+	fieldModeRandom        byte = 'r'
+	fieldModeDeterministic byte = 'd'
+	fieldNonceSize              = 12
+)
+
+var (
+	// ErrFieldMalformed the cipher text is malformed
+	ErrFieldMalformed = errors.New("malformed encrypted field")
+	// ErrFieldUnknownKey master key of the cipher text not exists
+	ErrFieldUnknownKey = errors.New("unknown master key")
+	// ErrFieldCipherMissing [DefaultFieldCipher] not configured
+	ErrFieldCipherMissing = errors.New("field cipher not configured")
+	// DefaultFieldCipher used by [Encrypted] and [Deterministic], see [ConfigFieldCipher]
+	DefaultFieldCipher *FieldCipher
+)
+
+/*
+ConfigFieldCipher config [DefaultFieldCipher] with master keys from config.
+
+HOCON sample:
+
+	crypto{
+	 current: k2 # id of master key to encrypt
+	 keys: {
+	   k1: "base64 of 16/24/32 bytes key"
+	   k2: "base64 of 16/24/32 bytes key"
+	 }
+	}
+*/
+func ConfigFieldCipher(c conf.Config) (err error) {
+	keys := make(map[string][]byte)
+	for id, s := range c.GetTextMap("crypto.keys") {
+		if keys[id], err = base64.StdEncoding.DecodeString(s); err != nil {
+			return fmt.Errorf("decode master key %s: %w", id, err)
+		}
+	}
+	var r *LocalKeyring
+	if r, err = NewLocalKeyring(c.RequiredString("crypto.current"), keys); err != nil {
+		return
+	}
+	DefaultFieldCipher = &FieldCipher{Keys: r}
+	return
+}
+
+// NewLocalKeyring create [LocalKeyring], current must exist in keys.
+func NewLocalKeyring(current string, keys map[string][]byte) (r *LocalKeyring, err error) {
+	if _, ok := keys[current]; !ok {
+		return nil, ErrFieldUnknownKey
+	}
+	r = &LocalKeyring{current: current, keys: make(map[string]cipher.AEAD, len(keys)), det: make(map[string][]byte, len(keys))}
+	for id, k := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("invalid master key id %q", id)
+		}
+		var b cipher.Block
+		if b, err = aes.NewCipher(k); err != nil {
+			return nil, fmt.Errorf("master key %s: %w", id, err)
+		}
+		if r.keys[id], err = cipher.NewGCM(b); err != nil {
+			return nil, err
+		}
+		r.det[id] = deriveKey(k, "gofra.field.deterministic")
+	}
+	return
+}
+
+func (r *LocalKeyring) Current() string {
+	return r.current
+}
+
+func (r *LocalKeyring) Wrap(dek []byte) (kid string, wrapped []byte, err error) {
+	a := r.keys[r.current]
+	nonce := make([]byte, a.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	return r.current, a.Seal(nonce, nonce, dek, []byte(r.current)), nil
+}
+
+func (r *LocalKeyring) Unwrap(kid string, wrapped []byte) ([]byte, error) {
+	a, ok := r.keys[kid]
+	if !ok {
+		return nil, ErrFieldUnknownKey
+	}
+	if len(wrapped) < a.NonceSize() {
+		return nil, ErrFieldMalformed
+	}
+	return a.Open(nil, wrapped[:a.NonceSize()], wrapped[a.NonceSize():], []byte(kid))
+}
+
+func (r *LocalKeyring) Deterministic(kid string) ([]byte, error) {
+	k, ok := r.det[kid]
+	if !ok {
+		return nil, ErrFieldUnknownKey
+	}
+	return k, nil
+}
+
+func gcm(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// Encrypt plain text to base64 string, deterministic for equality searchable values.
+func (f *FieldCipher) Encrypt(plain []byte, deterministic bool) (string, error) {
+	if deterministic {
+		return f.deterministic(plain, f.Keys.Current())
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	kid, wrapped, err := f.Keys.Wrap(dek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, fieldNonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return seal(fieldModeRandom, kid, wrapped, dek, nonce, plain)
+}
+
+// deterministic encrypt plain with the fixed data key of kid
+func (f *FieldCipher) deterministic(plain []byte, kid string) (string, error) {
+	dek, err := f.Keys.Deterministic(kid)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, fieldNonceSize)
+	h := hmac.New(sha256.New, deriveKey(dek, "gofra.field.nonce"))
+	h.Write(plain)
+	copy(nonce, h.Sum(nil))
+	return seal(fieldModeDeterministic, kid, nil, dek, nonce, plain)
+}
+
+func seal(mode byte, kid string, wrapped, dek, nonce, plain []byte) (string, error) {
+	a, err := gcm(dek)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 0, 2+len(kid)+binary.MaxVarintLen16+len(wrapped)+fieldNonceSize+len(plain)+a.Overhead())
+	buf = append(buf, mode, byte(len(kid)))
+	buf = append(buf, kid...)
+	if mode == fieldModeRandom {
+		buf = binary.AppendUvarint(buf, uint64(len(wrapped)))
+		buf = append(buf, wrapped...)
+	}
+	buf = append(buf, nonce...)
+	buf = a.Seal(buf, nonce, plain, buf[:2+len(kid)])
+	return base64.RawStdEncoding.EncodeToString(buf), nil
+}
+
+// Lookup returns deterministic cipher texts of plain under current master key and older kids,
+// used as arguments of IN query while rows are not yet re-encrypted after key rotation.
+func (f *FieldCipher) Lookup(plain []byte, kids ...string) (values []string, err error) {
+	current := f.Keys.Current()
+	var v string
+	if v, err = f.deterministic(plain, current); err != nil {
+		return nil, err
+	}
+	values = append(values, v)
+	for _, kid := range kids {
+		if kid == current {
+			continue
+		}
+		if v, err = f.deterministic(plain, kid); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return
+}
+
+type fieldHeader struct {
+	mode    byte
+	kid     string
+	wrapped []byte
+	header  []byte
+	nonce   []byte
+	sealed  []byte
+}
+
+func parseField(v string) (h fieldHeader, err error) {
+	var buf []byte
+	if buf, err = base64.RawStdEncoding.DecodeString(v); err != nil || len(buf) < 2 {
+		return h, ErrFieldMalformed
+	}
+	h.mode = buf[0]
+	n := int(buf[1])
+	if len(buf) < 2+n {
+		return h, ErrFieldMalformed
+	}
+	h.kid = string(buf[2 : 2+n])
+	h.header = buf[:2+n]
+	buf = buf[2+n:]
+	switch h.mode {
+	case fieldModeRandom:
+		w, x := binary.Uvarint(buf)
+		if x <= 0 || uint64(len(buf)-x) < w {
+			return h, ErrFieldMalformed
+		}
+		h.wrapped = buf[x : x+int(w)]
+		buf = buf[x+int(w):]
+	case fieldModeDeterministic:
+	default:
+		return h, ErrFieldMalformed
+	}
+	if len(buf) < fieldNonceSize {
+		return h, ErrFieldMalformed
+	}
+	h.nonce = buf[:fieldNonceSize]
+	h.sealed = buf[fieldNonceSize:]
+	return
+}
+
+// Decrypt the value from [FieldCipher.Encrypt]
+func (f *FieldCipher) Decrypt(v string) (plain []byte, err error) {
+	h, err := parseField(v)
+	if err != nil {
+		return nil, err
+	}
+	var dek []byte
+	if h.mode == fieldModeDeterministic {
+		dek, err = f.Keys.Deterministic(h.kid)
+	} else {
+		dek, err = f.Keys.Unwrap(h.kid, h.wrapped)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a, err := gcm(dek)
+	if err != nil {
+		return nil, err
+	}
+	return a.Open(nil, h.nonce, h.sealed, h.header)
+}
+
+// Outdated check if the value is not encrypted by current master key
+func (f *FieldCipher) Outdated(v string) bool {
+	h, err := parseField(v)
+	return err != nil || h.kid != f.Keys.Current()
+}
+
+// Rotate re-encrypt the value with current master key in same mode.
+// Deterministic values must be rotated for equality search to match them again, see [FieldCipher.Lookup].
+func (f *FieldCipher) Rotate(v string) (string, error) {
+	h, err := parseField(v)
+	if err != nil {
+		return "", err
+	}
+	if h.kid == f.Keys.Current() {
+		return v, nil
+	}
+	plain, err := f.Decrypt(v)
+	if err != nil {
+		return "", err
+	}
+	return f.Encrypt(plain, h.mode == fieldModeDeterministic)
+}
+
+var emptyAny = []byte("null")
+
+func encryptValue(v any, deterministic bool) (driver.Value, error) {
+	if DefaultFieldCipher == nil {
+		return nil, ErrFieldCipherMissing
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return DefaultFieldCipher.Encrypt(b, deterministic)
+}
+func decryptValue(src any, v any) (ok bool, err error) {
+	var raw string
+	switch src := src.(type) {
+	case string:
+		raw = src
+	case []byte:
+		raw = string(src)
+	case nil:
+		return false, nil
+	default:
+		return false, fmt.Errorf("type %T not supported by Scan", src)
+	}
+	if DefaultFieldCipher == nil {
+		return false, ErrFieldCipherMissing
+	}
+	b, err := DefaultFieldCipher.Decrypt(raw)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+func (j *Encrypted[T]) Set(v T) {
+	j.V = v
+	j.Valid = true
+}
+func (j Encrypted[T]) Value() (driver.Value, error) {
+	if !j.Valid {
+		return nil, nil
+	}
+	return encryptValue(j.V, false)
+}
+func (j *Encrypted[T]) Scan(src any) (err error) {
+	j.Valid, err = decryptValue(src, &j.V)
+	return
+}
+func (j Encrypted[T]) MarshalJSON() ([]byte, error) {
+	if !j.Valid {
+		return emptyAny, nil
+	}
+	return json.Marshal(j.V)
+}
+func (j *Encrypted[T]) UnmarshalJSON(bin []byte) error {
+	if len(bin) == 0 || bytes.Equal(bin, emptyAny) {
+		j.Valid = false
+		return nil
+	}
+	err := json.Unmarshal(bin, &j.V)
+	j.Valid = err == nil
+	return err
+}
+
+func (j *Deterministic[T]) Set(v T) {
+	j.V = v
+	j.Valid = true
+}
+func (j Deterministic[T]) Value() (driver.Value, error) {
+	if !j.Valid {
+		return nil, nil
+	}
+	return encryptValue(j.V, true)
+}
+func (j *Deterministic[T]) Scan(src any) (err error) {
+	j.Valid, err = decryptValue(src, &j.V)
+	return
+}
+func (j Deterministic[T]) MarshalJSON() ([]byte, error) {
+	if !j.Valid {
+		return emptyAny, nil
+	}
+	return json.Marshal(j.V)
+}
+func (j *Deterministic[T]) UnmarshalJSON(bin []byte) error {
+	if len(bin) == 0 || bytes.Equal(bin, emptyAny) {
+		j.Valid = false
+		return nil
+	}
+	err := json.Unmarshal(bin, &j.V)
+	j.Valid = err == nil
+	return err
+}
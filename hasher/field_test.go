@@ -0,0 +1,149 @@
+package hasher
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"testing"
+)
+
+var (
+	fieldKey1 = []byte("0123456789abcdef0123456789abcdef")
+	fieldKey2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func fieldCipher(t *testing.T, current string) *FieldCipher {
+	r, err := NewLocalKeyring(current, map[string][]byte{"k1": fieldKey1, "k2": fieldKey2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &FieldCipher{Keys: r}
+}
+
+func TestFieldCipherRoundTrip(t *testing.T) {
+	f := fieldCipher(t, "k1")
+	a, err := f.Encrypt([]byte("secret"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := f.Encrypt([]byte("secret"), false)
+	if a == b {
+		t.Fatal("random mode produces equal cipher texts")
+	}
+	c, _ := f.Encrypt([]byte("secret"), true)
+	d, _ := f.Encrypt([]byte("secret"), true)
+	e, _ := f.Encrypt([]byte("other"), true)
+	if c != d || c == e {
+		t.Fatal("deterministic mode not searchable by equality")
+	}
+	for _, v := range []string{a, b, c} {
+		if p, err := f.Decrypt(v); err != nil || string(p) != "secret" {
+			t.Fatalf("decrypt %s: %q %v", v, p, err)
+		}
+	}
+	for _, v := range []string{a, c} {
+		buf, _ := base64.RawStdEncoding.DecodeString(v)
+		buf[len(buf)-1] ^= 1
+		if _, err = f.Decrypt(base64.RawStdEncoding.EncodeToString(buf)); err == nil {
+			t.Fatal("tampered value decrypted")
+		}
+		buf[0] = 'x'
+		if _, err = f.Decrypt(base64.RawStdEncoding.EncodeToString(buf)); !errors.Is(err, ErrFieldMalformed) {
+			t.Fatal(err)
+		}
+	}
+	if _, err = f.Decrypt("!"); !errors.Is(err, ErrFieldMalformed) {
+		t.Fatal(err)
+	}
+	only2, _ := NewLocalKeyring("k2", map[string][]byte{"k2": fieldKey2})
+	if _, err = (&FieldCipher{Keys: only2}).Decrypt(a); !errors.Is(err, ErrFieldUnknownKey) {
+		t.Fatal(err)
+	}
+}
+
+func TestFieldCipherRotation(t *testing.T) {
+	old := fieldCipher(t, "k1")
+	random, _ := old.Encrypt([]byte("v"), false)
+	det, _ := old.Encrypt([]byte("v"), true)
+	f := fieldCipher(t, "k2")
+	for _, v := range []string{random, det} {
+		if !f.Outdated(v) {
+			t.Fatal("value of old key not outdated")
+		}
+		if p, err := f.Decrypt(v); err != nil || string(p) != "v" {
+			t.Fatal(p, err)
+		}
+		r, err := f.Rotate(v)
+		if err != nil || f.Outdated(r) {
+			t.Fatal(r, err)
+		}
+		if p, err := f.Decrypt(r); err != nil || string(p) != "v" {
+			t.Fatal(p, err)
+		}
+		if again, _ := f.Rotate(r); again != r {
+			t.Fatal("rotate current value changed it")
+		}
+	}
+	current, _ := f.Encrypt([]byte("v"), true)
+	if current == det {
+		t.Fatal("deterministic value not bound to master key")
+	}
+	if rotated, _ := f.Rotate(det); rotated != current {
+		t.Fatal("rotated deterministic value not searchable")
+	}
+	values, err := f.Lookup([]byte("v"), "k1", "k2")
+	if err != nil || len(values) != 2 || values[0] != current || values[1] != det {
+		t.Fatalf("lookup %v %v", values, err)
+	}
+	if _, err = f.Lookup([]byte("v"), "k3"); !errors.Is(err, ErrFieldUnknownKey) {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedColumn(t *testing.T) {
+	DefaultFieldCipher = nil
+	var e Encrypted[int]
+	e.Set(42)
+	if _, err := e.Value(); !errors.Is(err, ErrFieldCipherMissing) {
+		t.Fatal(err)
+	}
+	err := ConfigFieldCipher(conf.NewConfig(hocon.ParseString(`crypto{
+ current: k1
+ keys: {k1: "` + base64.StdEncoding.EncodeToString(fieldKey1) + `"}
+}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { DefaultFieldCipher = nil }()
+	v, err := e.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Encrypted[int]
+	if err = got.Scan([]byte(v.(string))); err != nil || !got.Valid || got.V != 42 {
+		t.Fatal(got, err)
+	}
+	if err = got.Scan(nil); err != nil || got.Valid {
+		t.Fatal(got, err)
+	}
+	if v, _ = (Encrypted[int]{}).Value(); v != nil {
+		t.Fatal("invalid value stored")
+	}
+	var d Deterministic[string]
+	d.Set("mail@example.com")
+	x, _ := d.Value()
+	y, _ := d.Value()
+	if x != y {
+		t.Fatal("deterministic column not searchable")
+	}
+	b, _ := json.Marshal(struct {
+		E Encrypted[int]
+		D Deterministic[string]
+		N Encrypted[int]
+	}{E: e, D: d})
+	if string(b) != `{"E":42,"D":"mail@example.com","N":null}` {
+		t.Fatal(string(b))
+	}
+}
@@ -0,0 +1,358 @@
+package hasher
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// OtpStepStore keeps the last accepted time step (TOTP) or counter (HOTP) of each subject,
+	// a code is only accepted when its step is greater than the stored one, so it can never be replayed.
+	OtpStepStore interface {
+		// Accept atomically checks that step is greater than the last accepted step of subject and stores it.
+		Accept(subject string, step uint64) (bool, error)
+		// Last returns the last accepted step of subject.
+		Last(subject string) (step uint64, ok bool, err error)
+	}
+	// OtpEnrollment is a pending OTP registration, the secret should only be persisted after [OtpEnrollment.Confirm] succeeded.
+	OtpEnrollment struct {
+		Key      *otp.Key
+		Recovery []string //plain recovery codes, only shown once to the user
+		Hashed   []string //hashed recovery codes to persist
+	}
+	// OtpVerifier validates OTP codes with replay protection.
+	OtpVerifier struct {
+		Store  OtpStepStore
+		Skew   uint   //TOTP acceptable steps before and after current step, default 1
+		Window uint64 //HOTP look-ahead window, default 10
+	}
+	memoryStepStore struct {
+		lock  sync.Mutex
+		cache units.Cache[string, uint64]
+	}
+	memoryCounterStore struct {
+		lock  sync.Mutex
+		steps map[string]uint64
+	}
+)
+
+var (
+	// ErrOtpReplay the code has been used already
+	ErrOtpReplay = errors.New("otp code already used")
+	// ErrOtpInvalid the code not match
+	ErrOtpInvalid = errors.New("otp code invalid")
+	// ErrOtpStoreRequired the verifier not have a store
+	ErrOtpStoreRequired = errors.New("otp step store required")
+	// ErrOtpStoreExpiring the store forgets steps, which can not protect HOTP counters from replay
+	ErrOtpStoreExpiring = errors.New("otp step store expires counters")
+	// RecoveryCodeAlphabet characters used to generate recovery codes, ambiguous characters are excluded.
+	RecoveryCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+// NewMemoryStepStore create an in memory [OtpStepStore], records expire after ttl without access.
+// The ttl should be greater than the validation window of codes.
+//
+// Expiring records are only for TOTP, an expired HOTP counter would accept used codes again,
+// so [OtpVerifier.ValidateHotp] rejects the store with [ErrOtpStoreExpiring].
+// ttl not positive create a store never expires, which is required for HOTP.
+func NewMemoryStepStore(ttl time.Duration) OtpStepStore {
+	if ttl <= 0 {
+		return &memoryCounterStore{steps: map[string]uint64{}}
+	}
+	c := units.NewCache[string, uint64](ttl, ttl, units.MILLS, units.WithExpiredAfterAccess(ttl))
+	c.StartKeeping()
+	return &memoryStepStore{cache: c}
+}
+
+func (m *memoryStepStore) Accept(subject string, step uint64) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if v, ok := m.cache.Get(subject); ok && v >= step {
+		return false, nil
+	}
+	m.cache.Put(subject, step)
+	return true, nil
+}
+
+func (m *memoryStepStore) Last(subject string) (step uint64, ok bool, err error) {
+	step, ok = m.cache.Get(subject)
+	return
+}
+
+func (m *memoryCounterStore) Accept(subject string, step uint64) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if v, ok := m.steps[subject]; ok && v >= step {
+		return false, nil
+	}
+	m.steps[subject] = step
+	return true, nil
+}
+
+func (m *memoryCounterStore) Last(subject string) (step uint64, ok bool, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	step, ok = m.steps[subject]
+	return
+}
+
+// TotpEnroll generate a new TOTP secret with n recovery codes hashed by crypto with arg.
+func TotpEnroll(opts totp.GenerateOpts, n int, crypto SecretCrypto, arg any) (e *OtpEnrollment, err error) {
+	e = new(OtpEnrollment)
+	if e.Key, err = totp.Generate(opts); err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		if e.Recovery, e.Hashed, err = RecoveryCodes(n, crypto, arg); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// HotpEnroll generate a new HOTP secret with n recovery codes hashed by crypto with arg.
+func HotpEnroll(opts hotp.GenerateOpts, n int, crypto SecretCrypto, arg any) (e *OtpEnrollment, err error) {
+	e = new(OtpEnrollment)
+	if e.Key, err = hotp.Generate(opts); err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		if e.Recovery, e.Hashed, err = RecoveryCodes(n, crypto, arg); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// URL the otpauth url to persist, which is accepted by [TotpValidate] and [HotpValidate]
+func (e *OtpEnrollment) URL() string {
+	return e.Key.URL()
+}
+
+// PNG render the otpauth url as QR code png image
+func (e *OtpEnrollment) PNG(width, height int) ([]byte, error) {
+	img, err := e.Key.Image(width, height)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Confirm check the first code from user's authenticator by v, the accepted step or counter is recorded
+// in the store of v, so the code can not be replayed as a login code. For HOTP the counter should be zero,
+// and the store of v must never expire, see [OtpVerifier.ValidateHotp].
+func (e *OtpEnrollment) Confirm(v OtpVerifier, subject, code string) error {
+	if e.Key.Type() == "hotp" {
+		return v.ValidateHotp(subject, code, e.Key.URL())
+	}
+	return v.ValidateTotp(subject, code, e.Key.URL(), time.Now())
+}
+
+// RecoveryCodes generate n random recovery codes in form of XXXXX-XXXXX, returns plain codes and hashes by crypto with arg.
+func RecoveryCodes(n int, crypto SecretCrypto, arg any) (plain, hashed []string, err error) {
+	plain = make([]string, n)
+	hashed = make([]string, n)
+	size := uint32(len(RecoveryCodeAlphabet))
+	for i := 0; i < n; i++ {
+		var b []byte
+		if b, err = generateRandomBytes(10); err != nil {
+			return nil, nil, err
+		}
+		var sb strings.Builder
+		for j, c := range b {
+			if j == 5 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(RecoveryCodeAlphabet[uint32(c)%size])
+		}
+		plain[i] = sb.String()
+		if hashed[i] = crypto.Hash(plain[i], arg); hashed[i] == "" {
+			return nil, nil, errors.New("hash recovery code failed")
+		}
+	}
+	return
+}
+
+// RecoveryValidate find the index of hashed recovery code that matches code, returns -1 when not found.
+// The crypto should be the one used by [RecoveryCodes], nil for [PasswordValidate].
+// The matched hash should be removed from storage by caller.
+func RecoveryValidate(code string, hashed []string, crypto SecretCrypto) int {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for i, h := range hashed {
+		if h == "" {
+			continue
+		}
+		if crypto == nil && PasswordValidate(code, h) || crypto != nil && crypto.Validate(code, h) {
+			return i
+		}
+	}
+	return -1
+}
+
+// HotpGenerate generate HOTP key url
+func HotpGenerate(opts hotp.GenerateOpts) (string, error) {
+	v, err := hotp.Generate(opts)
+	if err != nil {
+		return "", err
+	}
+	return v.URL(), err
+}
+
+// HotpValidate validate code at counter, def is the otpauth url or base32 secret
+func HotpValidate(code string, counter uint64, def string) bool {
+	if strings.HasPrefix(def, "otpauth://") {
+		if k, err := otp.NewKeyFromURL(def); err != nil {
+			return false
+		} else if v, err := hotp.ValidateCustom(code, counter, k.Secret(), hotp.ValidateOpts{
+			Digits:    k.Digits(),
+			Algorithm: k.Algorithm(),
+		}); err != nil {
+			return false
+		} else {
+			return v
+		}
+	}
+	return hotp.Validate(code, counter, def)
+}
+
+// HotpCode generate code at counter, def is the otpauth url or base32 secret
+func HotpCode(def string, counter uint64) (string, error) {
+	secret, opts, _, err := otpOptions(def)
+	if err != nil {
+		return "", err
+	}
+	return hotp.GenerateCodeCustom(secret, counter, opts)
+}
+
+func otpOptions(def string) (secret string, opts hotp.ValidateOpts, period uint64, err error) {
+	opts.Digits = otp.DigitsSix
+	opts.Algorithm = otp.AlgorithmSHA1
+	period = 30
+	if strings.HasPrefix(def, "otpauth://") {
+		var k *otp.Key
+		if k, err = otp.NewKeyFromURL(def); err != nil {
+			return
+		}
+		secret = k.Secret()
+		opts.Digits = k.Digits()
+		opts.Algorithm = k.Algorithm()
+		period = k.Period()
+	} else {
+		secret = def
+	}
+	secret = strings.ToUpper(strings.TrimSpace(secret))
+	if n := len(secret) % 8; n != 0 {
+		secret = secret + strings.Repeat("=", 8-n)
+	}
+	if _, err = base32.StdEncoding.DecodeString(secret); err != nil {
+		err = otp.ErrValidateSecretInvalidBase32
+	}
+	return
+}
+
+func otpMatch(code, secret string, counter uint64, opts hotp.ValidateOpts) bool {
+	v, err := hotp.GenerateCodeCustom(secret, counter, opts)
+	return err == nil && subtle.ConstantTimeCompare([]byte(v), []byte(code)) == 1
+}
+
+// ValidateTotp validate TOTP code of subject at now, def is the otpauth url or base32 secret.
+//
+// Returns [ErrOtpReplay] when the time step of code has been accepted before, or [ErrOtpInvalid] when not match.
+func (v OtpVerifier) ValidateTotp(subject, code, def string, now time.Time) error {
+	if v.Store == nil {
+		return ErrOtpStoreRequired
+	}
+	secret, opts, period, err := otpOptions(def)
+	if err != nil {
+		return err
+	}
+	skew := v.Skew
+	if skew == 0 {
+		skew = 1
+	}
+	current := uint64(now.Unix()) / period
+	for i := uint64(0); i <= uint64(skew)*2; i++ {
+		step := current - uint64(skew) + i
+		if !otpMatch(code, secret, step, opts) {
+			continue
+		}
+		if ok, err := v.Store.Accept(subject, step); err != nil {
+			return err
+		} else if !ok {
+			return ErrOtpReplay
+		}
+		return nil
+	}
+	return ErrOtpInvalid
+}
+
+// ValidateHotp validate HOTP code of subject, def is the otpauth url or base32 secret.
+// The counter is searched from last accepted counter within the look-ahead window.
+//
+// Returns [ErrOtpReplay] when the counter of code has been accepted before, or [ErrOtpInvalid] when not match.
+// The store must never forget counters, an expiring [NewMemoryStepStore] returns [ErrOtpStoreExpiring].
+func (v OtpVerifier) ValidateHotp(subject, code, def string) error {
+	if v.Store == nil {
+		return ErrOtpStoreRequired
+	}
+	if _, ok := v.Store.(*memoryStepStore); ok {
+		return ErrOtpStoreExpiring
+	}
+	secret, opts, _, err := otpOptions(def)
+	if err != nil {
+		return err
+	}
+	window := v.Window
+	if window == 0 {
+		window = 10
+	}
+	last, ok, err := v.Store.Last(subject)
+	if err != nil {
+		return err
+	}
+	begin := uint64(0)
+	if ok {
+		if otpMatch(code, secret, last, opts) {
+			return ErrOtpReplay
+		}
+		begin = last + 1
+	}
+	for c := begin; c < begin+window; c++ {
+		if !otpMatch(code, secret, c, opts) {
+			continue
+		}
+		if ok, err := v.Store.Accept(subject, c); err != nil {
+			return err
+		} else if !ok {
+			return ErrOtpReplay
+		}
+		return nil
+	}
+	return ErrOtpInvalid
+}
+
+// OtpSecret generate a random base32 secret of n bytes, which can be used as TOTP or HOTP secret directly.
+func OtpSecret(n uint32) (string, error) {
+	if n == 0 {
+		n = 20
+	}
+	b, err := generateRandomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
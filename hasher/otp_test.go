@@ -0,0 +1,150 @@
+package hasher
+
+import (
+	"errors"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func totpAt(t *testing.T, def string, at time.Time) string {
+	secret, opts, period, err := otpOptions(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := hotp.GenerateCodeCustom(secret, uint64(at.Unix())/period, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func hotpAt(t *testing.T, def string, counter uint64) string {
+	code, err := HotpCode(def, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestOtpVerifierTotp(t *testing.T) {
+	secret, err := OtpSecret(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = (OtpVerifier{}).ValidateTotp("u", "000000", secret, time.Now()); !errors.Is(err, ErrOtpStoreRequired) {
+		t.Fatal(err)
+	}
+	v := OtpVerifier{Store: NewMemoryStepStore(time.Minute)}
+	now := time.Now()
+	if err = v.ValidateTotp("u", totpAt(t, secret, now), secret, now); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.ValidateTotp("u", totpAt(t, secret, now), secret, now); !errors.Is(err, ErrOtpReplay) {
+		t.Fatalf("replay: %v", err)
+	}
+	if err = v.ValidateTotp("u", totpAt(t, secret, now.Add(-30*time.Second)), secret, now); !errors.Is(err, ErrOtpReplay) {
+		t.Fatalf("earlier step: %v", err)
+	}
+	if err = v.ValidateTotp("other", totpAt(t, secret, now), secret, now); err != nil {
+		t.Fatalf("subjects are separated: %v", err)
+	}
+	if err = v.ValidateTotp("u", totpAt(t, secret, now.Add(5*time.Minute)), secret, now); !errors.Is(err, ErrOtpInvalid) {
+		t.Fatalf("out of skew: %v", err)
+	}
+	if err = v.ValidateTotp("u", totpAt(t, secret, now.Add(30*time.Second)), secret, now); err != nil {
+		t.Fatalf("next step within skew: %v", err)
+	}
+}
+
+func TestOtpVerifierHotp(t *testing.T) {
+	secret, _ := OtpSecret(0)
+	if err := (OtpVerifier{Store: NewMemoryStepStore(time.Minute)}).ValidateHotp("u", hotpAt(t, secret, 0), secret); !errors.Is(err, ErrOtpStoreExpiring) {
+		t.Fatalf("expiring store: %v", err)
+	}
+	if _, err := HotpCode("not base32!", 0); err == nil {
+		t.Fatal("expect invalid secret")
+	}
+	v := OtpVerifier{Store: NewMemoryStepStore(0), Window: 5}
+	if err := v.ValidateHotp("u", hotpAt(t, secret, 0), secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.ValidateHotp("u", hotpAt(t, secret, 0), secret); !errors.Is(err, ErrOtpReplay) {
+		t.Fatalf("replay: %v", err)
+	}
+	if err := v.ValidateHotp("u", hotpAt(t, secret, 3), secret); err != nil {
+		t.Fatalf("look ahead: %v", err)
+	}
+	if err := v.ValidateHotp("u", hotpAt(t, secret, 2), secret); !errors.Is(err, ErrOtpInvalid) {
+		t.Fatalf("skipped counter: %v", err)
+	}
+	if err := v.ValidateHotp("u", hotpAt(t, secret, 10), secret); !errors.Is(err, ErrOtpInvalid) {
+		t.Fatalf("out of window: %v", err)
+	}
+	if last, ok, _ := v.Store.Last("u"); !ok || last != 3 {
+		t.Fatalf("last counter %d", last)
+	}
+}
+
+func TestOtpEnrollmentConfirm(t *testing.T) {
+	e, err := TotpEnroll(totp.GenerateOpts{Issuer: "gofra", AccountName: "u"}, 2, BCrypt, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := OtpVerifier{Store: NewMemoryStepStore(time.Minute)}
+	code := totpAt(t, e.URL(), time.Now())
+	if err = e.Confirm(v, "u", code); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.ValidateTotp("u", code, e.URL(), time.Now()); !errors.Is(err, ErrOtpReplay) {
+		t.Fatalf("enrollment code reused as login code: %v", err)
+	}
+	h, err := HotpEnroll(hotp.GenerateOpts{Issuer: "gofra", AccountName: "u"}, 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Store = NewMemoryStepStore(0)
+	if err = h.Confirm(v, "h", hotpAt(t, h.URL(), 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.ValidateHotp("h", hotpAt(t, h.URL(), 0), h.URL()); !errors.Is(err, ErrOtpReplay) {
+		t.Fatalf("enrollment code reused as login code: %v", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	argon := DefaultArgon2Argument
+	argon.Memory, argon.Iterations = 1024, 1
+	for name, c := range map[string]struct {
+		crypto SecretCrypto
+		arg    any
+	}{"argon2": {Argon2id, argon}, "bcrypt": {BCrypt, 4}} {
+		plain, hashed, err := RecoveryCodes(3, c.crypto, c.arg)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		for i, p := range plain {
+			if len(p) != 11 || p[5] != '-' || strings.Trim(p, RecoveryCodeAlphabet+"-") != "" {
+				t.Fatalf("%s: bad code %s", name, p)
+			}
+			if n := RecoveryValidate(" "+strings.ToLower(p)+" ", hashed, c.crypto); n != i {
+				t.Fatalf("%s: code %d matched %d", name, i, n)
+			}
+			if n := RecoveryValidate(p, hashed, nil); n != i {
+				t.Fatalf("%s: default validate %d matched %d", name, i, n)
+			}
+		}
+		if n := RecoveryValidate("AAAAA-AAAAA", hashed, c.crypto); n != -1 {
+			t.Fatalf("%s: wrong code matched %d", name, n)
+		}
+		hashed[1] = "" //used
+		if n := RecoveryValidate(plain[1], hashed, c.crypto); n != -1 {
+			t.Fatalf("%s: used code matched %d", name, n)
+		}
+	}
+	if _, _, err := RecoveryCodes(1, BCrypt, "bad arg"); err == nil {
+		t.Fatal("expect hash failure")
+	}
+}
@@ -0,0 +1,262 @@
+package htt
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Admin an opt-in debug handler, serves on a separated listener. see [NewAdmin]
+	//
+	// Requests must carry the token in header Authorization as Bearer token or X-Admin-Token,
+	// or come from loopback when no token configured.
+	Admin struct {
+		Router    *mux.Router
+		Token     string
+		Config    conf.Config           //the config to expose, secrets are redacted
+		Sensitive func(key string) bool //keys to redact, default is [SensitiveKey]
+		Health    *Health               //optional health to expose
+		server    conf.Config
+		lock      sync.RWMutex
+		rings     map[string]func() (map[string]string, map[string]string)
+		caches    map[string]AdminCache
+		started   time.Time
+	}
+	// AdminCache the cache to inspect, which satisfied by [units.Cache]
+	AdminCache interface {
+		Count() int
+		TimeToLive() time.Duration
+		HouseKeeping() bool
+	}
+)
+
+var (
+	// ErrAdminUnprotected admin without token must bind to loopback address
+	ErrAdminUnprotected = errors.New("admin requires token or loopback address")
+	sensitiveKeys       = []string{"password", "passwd", "secret", "token", "privatekey", "credential", "apikey"}
+)
+
+// SensitiveKey check if the config key likes a secret
+func SensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	if k == "key" || k == "keys" {
+		return true
+	}
+	for _, s := range sensitiveKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+NewAdmin create [Admin] from root config, returns nil when admin not configured.
+
+HOCON sample:
+
+	admin{
+	 address: "127.0.0.1:6060" # must be loopback without token, also accepts server options see NewServer
+	 token: "secret" # optional token
+	 pprof: true
+	 expvar: true
+	}
+*/
+func NewAdmin(cfg conf.Config) (a *Admin, err error) {
+	if cfg == nil || !cfg.IsObject("admin") {
+		return nil, nil
+	}
+	c := cfg.GetObject("admin")
+	a = &Admin{
+		Router:  mux.NewRouter(),
+		Token:   c.GetString("token"),
+		Config:  cfg,
+		server:  c,
+		rings:   map[string]func() (map[string]string, map[string]string){},
+		caches:  map[string]AdminCache{},
+		started: time.Now(),
+	}
+	if a.Token == "" && c.GetString("unix") == "" {
+		host, _, err := net.SplitHostPort(c.GetString("address", "127.0.0.1:6060"))
+		if ip := net.ParseIP(host); err != nil || (host != "localhost" && (ip == nil || !ip.IsLoopback())) {
+			return nil, ErrAdminUnprotected
+		}
+	}
+	r := a.Router
+	if c.GetBoolean("pprof", true) {
+		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	}
+	if c.GetBoolean("expvar", true) {
+		r.Handle("/debug/vars", expvar.Handler())
+	}
+	r.HandleFunc("/admin/build", a.build).Methods(http.MethodGet)
+	r.HandleFunc("/admin/runtime", a.runtime).Methods(http.MethodGet)
+	r.HandleFunc("/admin/config", a.config).Methods(http.MethodGet)
+	r.HandleFunc("/admin/rings", a.ringsInspect).Methods(http.MethodGet)
+	r.HandleFunc("/admin/caches", a.cachesInspect).Methods(http.MethodGet)
+	r.HandleFunc("/admin/log", a.logLevel).Methods(http.MethodGet, http.MethodPut)
+	r.HandleFunc("/admin/health", func(w http.ResponseWriter, r *http.Request) {
+		if a.Health == nil {
+			writeJsonError(w, http.StatusNotFound, "health not configured")
+			return
+		}
+		a.Health.Healthz()(w, r)
+	}).Methods(http.MethodGet)
+	return
+}
+
+// Server create the [Server] of admin, the default address is 127.0.0.1:6060
+func (a *Admin) Server() (*Server, error) {
+	s, err := NewServer("admin", a, a.server)
+	if err == nil && s.Transport.Network == "tcp" && !a.server.HasPath("address") {
+		s.Transport.Address = "127.0.0.1:6060"
+	}
+	return s, err
+}
+
+// AddRing add inspection of a [units.Ring], inspect should be the method value of Ring.Inspect
+func (a *Admin) AddRing(name string, inspect func() (registry map[string]string, wheel map[string]string)) *Admin {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.rings[name] = inspect
+	return a
+}
+
+// AddCache add inspection of a cache
+func (a *Admin) AddCache(name string, c AdminCache) *Admin {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.caches[name] = c
+	return a
+}
+
+func (a *Admin) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr == "" || r.RemoteAddr == "@" //unix socket
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	t := r.Header.Get("X-Admin-Token")
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		t = v
+	}
+	return subtle.ConstantTimeCompare([]byte(t), []byte(a.Token)) == 1
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		writeJsonError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	a.Router.ServeHTTP(w, r)
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	_ = e.Encode(v)
+}
+
+func (a *Admin) build(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		writeJsonError(w, http.StatusNotFound, "build info not available")
+		return
+	}
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	deps := map[string]string{}
+	for _, d := range info.Deps {
+		deps[d.Path] = d.Version
+	}
+	writeJson(w, map[string]any{
+		"go":       info.GoVersion,
+		"path":     info.Path,
+		"main":     info.Main.Version,
+		"settings": settings,
+		"deps":     deps,
+	})
+}
+
+func (a *Admin) runtime(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	writeJson(w, map[string]any{
+		"uptime":     time.Since(a.started).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"cpus":       runtime.NumCPU(),
+		"maxProcs":   runtime.GOMAXPROCS(0),
+		"heapAlloc":  m.HeapAlloc,
+		"heapSys":    m.HeapSys,
+		"numGC":      m.NumGC,
+		"pauseTotal": time.Duration(m.PauseTotalNs).String(),
+	})
+}
+
+func (a *Admin) config(w http.ResponseWriter, r *http.Request) {
+	if a.Config == nil {
+		writeJson(w, map[string]any{})
+		return
+	}
+	s := a.Sensitive
+	if s == nil {
+		s = SensitiveKey
+	}
+	writeJson(w, conf.Redacted(a.Config, s))
+}
+
+func (a *Admin) ringsInspect(w http.ResponseWriter, r *http.Request) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	m := make(map[string]any, len(a.rings))
+	for name, inspect := range a.rings {
+		reg, wheel := inspect()
+		m[name] = map[string]any{"registry": reg, "wheel": wheel}
+	}
+	writeJson(w, m)
+}
+
+func (a *Admin) cachesInspect(w http.ResponseWriter, r *http.Request) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	m := make(map[string]any, len(a.caches))
+	for name, c := range a.caches {
+		m[name] = map[string]any{"count": c.Count(), "ttl": c.TimeToLive().String(), "keeping": c.HouseKeeping()}
+	}
+	writeJson(w, m)
+}
+
+// logLevel GET the current level, PUT with query level to change it
+func (a *Admin) logLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		if err := conf.SetLogLevel(r.URL.Query().Get("level")); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		conf.Internal().Warnf("log level changed to %s by %s", conf.LogLevel(), r.RemoteAddr)
+	}
+	writeJson(w, map[string]string{"level": conf.LogLevel()})
+}
@@ -0,0 +1,154 @@
+package htt
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/units"
+	hocon "github.com/go-akka/configuration"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminOf(t *testing.T, src string) *Admin {
+	a, err := NewAdmin(conf.NewConfig(hocon.ParseString(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func adminGet(a *Admin, method, path, remote, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = remote
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewAdmin(t *testing.T) {
+	if a, err := NewAdmin(conf.NewConfig(hocon.ParseString(`server{}`))); a != nil || err != nil {
+		t.Fatal("admin without config", a, err)
+	}
+	for _, src := range []string{`admin{address: "0.0.0.0:6060"}`, `admin{address: "example.com:6060"}`, `admin{address: "bad"}`} {
+		if _, err := NewAdmin(conf.NewConfig(hocon.ParseString(src))); !errors.Is(err, ErrAdminUnprotected) {
+			t.Fatalf("%s: %v", src, err)
+		}
+	}
+	for _, src := range []string{`admin{}`, `admin{address: "localhost:6060"}`, `admin{address: "[::1]:6060"}`, `admin{address: "0.0.0.0:6060", token: x}`, `admin{unix: "/run/admin.sock"}`} {
+		adminOf(t, src)
+	}
+	s, err := adminOf(t, `admin{pprof: false}`).Server()
+	if err != nil || s.Name != "admin" || s.Transport.Address != "127.0.0.1:6060" {
+		t.Fatal(s, err)
+	}
+	if s, _ = adminOf(t, `admin{address: "127.0.0.1:7070"}`).Server(); s.Transport.Address != "127.0.0.1:7070" {
+		t.Fatal(s.Transport)
+	}
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	a := adminOf(t, `admin{}`)
+	for remote, code := range map[string]int{"127.0.0.1:1": http.StatusOK, "[::1]:1": http.StatusOK, "@": http.StatusOK, "10.0.0.1:1": http.StatusUnauthorized} {
+		if w := adminGet(a, http.MethodGet, "/admin/runtime", remote, ""); w.Code != code {
+			t.Fatalf("%s: %d", remote, w.Code)
+		}
+	}
+	a = adminOf(t, `admin{address: "0.0.0.0:6060", token: secret}`)
+	if w := adminGet(a, http.MethodGet, "/admin/runtime", "127.0.0.1:1", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("loopback without token: %d", w.Code)
+	}
+	if w := adminGet(a, http.MethodGet, "/admin/runtime", "10.0.0.1:1", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %d", w.Code)
+	}
+	w := adminGet(a, http.MethodGet, "/admin/runtime", "10.0.0.1:1", "secret")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" || !strings.Contains(w.Body.String(), `"goroutines"`) {
+		t.Fatalf("bearer token: %d %s", w.Code, w.Body)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+	w = httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "memstats") {
+		t.Fatalf("header token: %d", w.Code)
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	a := adminOf(t, `admin{expvar: false}
+db{url: "mysql://", password: "p@ss", nested{apiKey: k, keys: [a, b], list: [{token: t}]}}`)
+	get := func(path string) (int, map[string]any) {
+		w := adminGet(a, http.MethodGet, path, "127.0.0.1:1", "")
+		var v map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &v)
+		return w.Code, v
+	}
+	code, v := get("/admin/config")
+	db, _ := v["db"].(map[string]any)
+	nested, _ := db["nested"].(map[string]any)
+	list, _ := nested["list"].([]any)
+	if code != http.StatusOK || db["url"] != "mysql://" || db["password"] != "******" || nested["apiKey"] != "******" ||
+		nested["keys"] != "******" || len(list) != 1 || list[0].(map[string]any)["token"] != "******" {
+		t.Fatalf("config: %d %v", code, v)
+	}
+	a.Sensitive = func(key string) bool { return key == "url" }
+	if _, v = get("/admin/config"); v["db"].(map[string]any)["url"] != "******" || v["db"].(map[string]any)["password"] != "p@ss" {
+		t.Fatalf("custom sensitive: %v", v)
+	}
+	if code, v = get("/admin/build"); code != http.StatusOK || v["go"] == "" {
+		t.Fatalf("build: %d %v", code, v)
+	}
+	if code, _ = get("/debug/vars"); code != http.StatusNotFound {
+		t.Fatalf("expvar disabled: %d", code)
+	}
+	if code, _ = get("/debug/pprof/"); code != http.StatusOK {
+		t.Fatalf("pprof: %d", code)
+	}
+	if code, _ = get("/admin/health"); code != http.StatusNotFound {
+		t.Fatalf("health not configured: %d", code)
+	}
+	a.Health = NewHealth(nil).Register("db", failing("down"))
+	if code, v = get("/admin/health"); code != http.StatusServiceUnavailable || v["status"] != HealthDown {
+		t.Fatalf("health: %d %v", code, v)
+	}
+	c := units.NewCache[string, int](time.Minute, time.Hour, units.SECONDS)
+	c.Put("a", 1)
+	a.AddCache("users", c).AddRing("jobs", func() (map[string]string, map[string]string) {
+		return map[string]string{"a": "1"}, map[string]string{"0": "a"}
+	})
+	if _, v = get("/admin/caches"); v["users"].(map[string]any)["count"] != 1.0 || v["users"].(map[string]any)["ttl"] != "1h0m0s" {
+		t.Fatalf("caches: %v", v)
+	}
+	if _, v = get("/admin/rings"); v["jobs"].(map[string]any)["registry"].(map[string]any)["a"] != "1" {
+		t.Fatalf("rings: %v", v)
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	a := adminOf(t, `admin{}`)
+	before := conf.LogLevel()
+	defer func() { _ = conf.SetLogLevel(strings.TrimPrefix(before, "v=")) }()
+	level, want := "debug", "DEBUG" //slog
+	if strings.HasPrefix(before, "v=") {
+		level, want = "2", "v=2" //glog
+	}
+	w := adminGet(a, http.MethodPut, "/admin/log?level="+level, "127.0.0.1:1", "")
+	if w.Code != http.StatusOK || conf.LogLevel() != want || !strings.Contains(w.Body.String(), `"`+want+`"`) {
+		t.Fatalf("set level: %d %s %s", w.Code, w.Body, conf.LogLevel())
+	}
+	if w = adminGet(a, http.MethodPut, "/admin/log?level=high", "127.0.0.1:1", ""); w.Code != http.StatusBadRequest || conf.LogLevel() != want {
+		t.Fatalf("invalid level: %d %s", w.Code, conf.LogLevel())
+	}
+	if w = adminGet(a, http.MethodGet, "/admin/log", "127.0.0.1:1", ""); !strings.Contains(w.Body.String(), `"`+want+`"`) {
+		t.Fatalf("get level: %s", w.Body)
+	}
+	if w = adminGet(a, http.MethodPost, "/admin/log", "127.0.0.1:1", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("post: %d", w.Code)
+	}
+}
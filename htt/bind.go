@@ -0,0 +1,646 @@
+package htt
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Jeffail/gabs/v2"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/gorilla/mux"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidationError field errors of binding and validation
+type ValidationError []units.FieldError
+
+func (v ValidationError) Error() string {
+	s := make([]string, len(v))
+	for i, f := range v {
+		s[i] = f.Field + ": " + f.Message
+	}
+	return "validation failed: " + strings.Join(s, "; ")
+}
+
+// JsonError the 400 error response with details
+func (v ValidationError) JsonError() units.JsonError {
+	return units.JsonError{
+		Timestamp: time.Now().Unix(),
+		Code:      http.StatusBadRequest,
+		Message:   "validation failed",
+		Details:   v,
+	}
+}
+
+// MaxMultipartMemory memory limit of multipart form parsing, the rest is stored in temporary files.
+var MaxMultipartMemory int64 = 32 << 20
+
+var (
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	fileHeaderType  = reflect.TypeOf((*multipart.FileHeader)(nil))
+	timeType        = reflect.TypeOf(time.Time{})
+	durationType    = reflect.TypeOf(time.Duration(0))
+)
+
+/*
+Bind decode request into T, which must be a struct or pointer to struct, then validate it, see [Validate].
+
+Sources are applied in order:
+  - body: JSON by json tags, or url-encoded and multipart form by form tags (fallback to json name).
+    multipart files bind to fields of *multipart.FileHeader or []*multipart.FileHeader.
+    other media types are decoded by registered codecs, see [RegisterCodec], non-empty bodies of unsupported media types are 415 [Problem].
+  - query: fields with tag `query:"name"`.
+  - path: fields with tag `path:"name"`, from [mux.Vars].
+
+Errors of decoding and validation are [ValidationError].
+*/
+func Bind[T any](r *http.Request) (v T, err error) {
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() == reflect.Pointer && rv.Type().Elem().Kind() == reflect.Struct {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Errorf("bind target %T is not a struct", v))
+	}
+	if err = bindBody(r, rv); err != nil {
+		return
+	}
+	var errs ValidationError
+	if q := r.URL.Query(); len(q) > 0 {
+		errs = bindValues(rv, "query", q, nil, errs)
+	}
+	if vars := mux.Vars(r); len(vars) > 0 {
+		m := make(map[string][]string, len(vars))
+		for k, x := range vars {
+			m[k] = []string{x}
+		}
+		errs = bindValues(rv, "path", m, nil, errs)
+	}
+	if len(errs) > 0 {
+		return v, errs
+	}
+	err = Validate(rv.Addr().Interface())
+	return
+}
+
+// BindOrError bind the request, writes 400 error with details when failed, see [Bind].
+func BindOrError[T any](w http.ResponseWriter, r *http.Request) (v T, ok bool) {
+	var err error
+	if v, err = Bind[T](r); err != nil {
+		WriteBindError(w, err)
+		return v, false
+	}
+	return v, true
+}
+
+// WriteBindError write [ValidationError] as 400 json error with details, [*TagError] as 500 json error,
+// [*Problem] as json error of its status, other errors as 400 json error.
+func WriteBindError(w http.ResponseWriter, err error) {
+	var ve ValidationError
+	var te *TagError
+	var p *Problem
+	if errors.As(err, &te) {
+		writeJsonError(w, http.StatusInternalServerError, "invalid validate tag")
+		return
+	}
+	if errors.As(err, &p) {
+		writeJsonError(w, p.Status, p.Detail)
+		return
+	}
+	if !errors.As(err, &ve) {
+		writeJsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(ve.JsonError())
+}
+
+/*
+BindGabs read the JSON body as [units.Gabs] without panic, then validate values by rules, see [Validate].
+
+Keys of rules are gabs paths, values are validate tags. Absent paths fail only the required rule.
+Errors of decoding and validation are [ValidationError], malformed rules are [*TagError].
+*/
+func BindGabs(r *http.Request, rules map[string]string) (g units.Gabs, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return g, ValidationError{{Rule: "json", Message: "empty body"}}
+	}
+	c, err := gabs.ParseJSONBuffer(r.Body)
+	if err != nil {
+		return g, jsonFieldError(err)
+	}
+	g = units.Gabs{Container: c}
+	paths := make([]string, 0, len(rules))
+	for p := range rules {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var errs ValidationError
+	for _, p := range paths {
+		rs, err := parseRules(rules[p])
+		if err != nil {
+			return g, &TagError{Type: reflect.TypeOf(g), Field: p, Err: err}
+		}
+		data := c.Path(p).Data()
+		for _, x := range rs {
+			var msg string
+			switch {
+			case data != nil:
+				msg = x.check(reflect.ValueOf(data))
+			case x.name == "required":
+				msg = "is required"
+			}
+			if msg != "" {
+				errs = append(errs, units.FieldError{Field: p, Rule: x.name, Message: msg})
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return g, errs
+	}
+	return g, nil
+}
+
+func bindBody(r *http.Request, rv reflect.Value) error {
+	if r.Body == nil || r.Body == http.NoBody || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case ct == "application/json" || strings.HasSuffix(ct, "+json"):
+		if err := json.NewDecoder(r.Body).Decode(rv.Addr().Interface()); err != nil && !errors.Is(err, io.EOF) {
+			return jsonFieldError(err)
+		}
+	case ct == "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return ValidationError{{Rule: "form", Message: err.Error()}}
+		}
+		if errs := bindValues(rv, "form", r.PostForm, nil, nil); len(errs) > 0 {
+			return errs
+		}
+	case ct == "multipart/form-data":
+		if err := r.ParseMultipartForm(MaxMultipartMemory); err != nil {
+			return ValidationError{{Rule: "form", Message: err.Error()}}
+		}
+		if errs := bindValues(rv, "form", r.MultipartForm.Value, r.MultipartForm.File, nil); len(errs) > 0 {
+			return errs
+		}
+	default:
+		return decodeBody(r, ct, rv)
+	}
+	return nil
+}
+
+func jsonFieldError(err error) ValidationError {
+	var te *json.UnmarshalTypeError
+	var se *json.SyntaxError
+	switch {
+	case errors.As(err, &te):
+		return ValidationError{{Field: te.Field, Rule: "type", Message: "should be " + te.Type.String()}}
+	case errors.As(err, &se):
+		return ValidationError{{Rule: "json", Message: se.Error()}}
+	default:
+		return ValidationError{{Rule: "json", Message: err.Error()}}
+	}
+}
+
+// fieldName the name of field in tag, fallback to json name and go name
+func fieldName(f reflect.StructField, tag string) (string, bool) {
+	if v, ok := f.Tag.Lookup(tag); ok {
+		n, _, _ := strings.Cut(v, ",")
+		return n, n != "-"
+	}
+	if tag == "form" || tag == "" {
+		if v, ok := f.Tag.Lookup("json"); ok {
+			n, _, _ := strings.Cut(v, ",")
+			if n == "-" {
+				return "", false
+			}
+			if n != "" {
+				return n, true
+			}
+		}
+		return f.Name, tag == "form"
+	}
+	return "", false
+}
+
+func bindValues(rv reflect.Value, tag string, values map[string][]string, files map[string][]*multipart.FileHeader, errs ValidationError) ValidationError {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			errs = bindValues(fv, tag, values, files, errs)
+			continue
+		}
+		name, ok := fieldName(f, tag)
+		if !ok {
+			continue
+		}
+		if files != nil && (f.Type == fileHeaderType || f.Type == reflect.SliceOf(fileHeaderType)) {
+			if fs := files[name]; len(fs) > 0 {
+				if f.Type == fileHeaderType {
+					fv.Set(reflect.ValueOf(fs[0]))
+				} else {
+					fv.Set(reflect.ValueOf(fs))
+				}
+			}
+			continue
+		}
+		vs, ok := values[name]
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		if err := setValue(fv, vs); err != nil {
+			errs = append(errs, units.FieldError{Field: name, Rule: "type", Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+func setValue(v reflect.Value, vs []string) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 && !v.Addr().Type().Implements(textUnmarshaler) {
+		s := reflect.MakeSlice(v.Type(), len(vs), len(vs))
+		for i, x := range vs {
+			if err := setString(s.Index(i), x); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	return setString(v, vs[0])
+}
+
+func setString(v reflect.Value, s string) (err error) {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err = setString(p.Elem(), s); err == nil {
+			v.Set(p)
+		}
+		return
+	}
+	if v.Addr().Type().Implements(textUnmarshaler) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch {
+	case v.Type() == durationType:
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err == nil {
+			v.SetInt(int64(d))
+		}
+		return
+	case v.Type() == timeType:
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339, s); err == nil {
+			v.Set(reflect.ValueOf(t))
+		}
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(s, 10, v.Type().Bits()); err == nil {
+			v.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		if n, err = strconv.ParseFloat(s, v.Type().Bits()); err == nil {
+			v.SetFloat(n)
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	if err != nil {
+		err = fmt.Errorf("should be %s", v.Type())
+	}
+	return
+}
+
+//region Validate
+
+type (
+	rule struct {
+		name  string
+		arg   string
+		num   float64
+		regex *regexp.Regexp
+		enum  []string
+	}
+	fieldRules struct {
+		index []int
+		name  string
+		rules []rule
+		dive  bool //nested struct or slice of struct
+	}
+	// rulesCache the parsed rules or the tag error of a struct type
+	rulesCache struct {
+		fields []fieldRules
+		err    error
+	}
+)
+
+var validators sync.Map //reflect.Type => rulesCache
+
+// TagError a malformed validate tag, which is a programming error rather than a bad request.
+type TagError struct {
+	Type  reflect.Type
+	Field string
+	Err   error
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("validate tag of %s.%s: %s", e.Type, e.Field, e.Err)
+}
+func (e *TagError) Unwrap() error { return e.Err }
+
+// splitRules split tag by commas, an escaped comma `\,` is kept in the rule.
+func splitRules(tag string) (parts []string) {
+	var b strings.Builder
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			b.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(tag[i])
+		}
+	}
+	return append(parts, b.String())
+}
+
+func parseRules(tag string) (rs []rule, err error) {
+	for _, part := range splitRules(tag) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "required", "email":
+		case "min", "max":
+			if r.num, err = strconv.ParseFloat(arg, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s rule %q", name, arg)
+			}
+		case "regex":
+			if r.regex, err = regexp.Compile(arg); err != nil {
+				return nil, err
+			}
+		case "enum":
+			r.enum = strings.Split(arg, "|")
+		default:
+			return nil, fmt.Errorf("unknown validate rule %s", name)
+		}
+		rs = append(rs, r)
+	}
+	return
+}
+
+// rulesOf parse the validate tags of t once, the result or the error is cached.
+func rulesOf(t reflect.Type) ([]fieldRules, error) {
+	if v, ok := validators.Load(t); ok {
+		c := v.(rulesCache)
+		return c.fields, c.err
+	}
+	fs, err := parseFields(t)
+	validators.Store(t, rulesCache{fields: fs, err: err})
+	return fs, err
+}
+
+func parseFields(t reflect.Type) (fs []fieldRules, err error) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _ := fieldName(f, "")
+		if v, ok := f.Tag.Lookup("query"); ok {
+			name, _, _ = strings.Cut(v, ",")
+		} else if v, ok := f.Tag.Lookup("path"); ok {
+			name, _, _ = strings.Cut(v, ",")
+		}
+		rs, err := parseRules(f.Tag.Get("validate"))
+		if err != nil {
+			return nil, &TagError{Type: t, Field: f.Name, Err: err}
+		}
+		e := f.Type
+		for e.Kind() == reflect.Pointer || e.Kind() == reflect.Slice || e.Kind() == reflect.Array {
+			e = e.Elem()
+		}
+		dive := e.Kind() == reflect.Struct && e != timeType && !reflect.PointerTo(e).Implements(textUnmarshaler)
+		if len(rs) > 0 || dive {
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				name = ""
+			}
+			fs = append(fs, fieldRules{index: f.Index, name: name, rules: rs, dive: dive})
+		}
+	}
+	return
+}
+
+// Validate check struct fields by validate tag, returns [ValidationError] when invalid. v must be a struct or pointer to struct.
+// A malformed tag is returned as [*TagError].
+//
+// Rules are separated by comma:
+//   - required: not zero value, not nil and not empty.
+//   - min=N, max=N: bounds of numbers, or length of strings, slices and maps.
+//   - regex=PATTERN: strings match the pattern, commas in the pattern are escaped as `\,`,
+//     which is written as `\\,` in a struct tag literal.
+//   - enum=A|B|C: value is one of the options.
+//   - email: strings are valid email address.
+//
+// Nested structs and slices of structs are validated recursively.
+func Validate(v any) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("validate target %T is not a struct", v)
+	}
+	errs, err := validateStruct(rv, "", nil)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func join(prefix, name string) string {
+	switch {
+	case prefix == "":
+		return name
+	case name == "":
+		return prefix
+	default:
+		return prefix + "." + name
+	}
+}
+
+func validateStruct(rv reflect.Value, prefix string, errs ValidationError) (ValidationError, error) {
+	fs, err := rulesOf(rv.Type())
+	if err != nil {
+		return errs, err
+	}
+	for _, f := range fs {
+		fv := rv.FieldByIndex(f.index)
+		name := join(prefix, f.name)
+		ok := true
+		for _, r := range f.rules {
+			if msg := r.check(fv); msg != "" {
+				errs = append(errs, units.FieldError{Field: name, Rule: r.name, Message: msg})
+				ok = false
+				break
+			}
+		}
+		if ok && f.dive {
+			if errs, err = dive(fv, name, errs); err != nil {
+				return errs, err
+			}
+		}
+	}
+	return errs, nil
+}
+
+func dive(v reflect.Value, name string, errs ValidationError) (_ ValidationError, err error) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return dive(v.Elem(), name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len() && err == nil; i++ {
+			errs, err = dive(v.Index(i), name+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Struct:
+		return validateStruct(v, name, errs)
+	}
+	return errs, err
+}
+
+func (r rule) check(v reflect.Value) string {
+	if r.name == "required" {
+		if v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+			return "is required"
+		}
+		return ""
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "" //optional
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if r.name == "min" || r.name == "max" {
+			return r.bound(float64(v.Len()), "length")
+		}
+		if v.Kind() != reflect.Map && r.name != "required" {
+			for i := 0; i < v.Len(); i++ {
+				if msg := r.check(v.Index(i)); msg != "" {
+					return fmt.Sprintf("item %d %s", i, msg)
+				}
+			}
+		}
+		return ""
+	case reflect.String:
+		s := v.String()
+		if s == "" {
+			return "" //optional, use required for not empty
+		}
+		switch r.name {
+		case "min", "max":
+			return r.bound(float64(len([]rune(s))), "length")
+		case "regex":
+			if !r.regex.MatchString(s) {
+				return "should match " + r.arg
+			}
+		case "enum":
+			for _, e := range r.enum {
+				if e == s {
+					return ""
+				}
+			}
+			return "should be one of " + strings.Join(r.enum, ", ")
+		case "email":
+			if a, err := mail.ParseAddress(s); err != nil || a.Address != s {
+				return "should be an email address"
+			}
+		}
+		return ""
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return r.number(float64(v.Int()), strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return r.number(float64(v.Uint()), strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return r.number(v.Float(), strconv.FormatFloat(v.Float(), 'f', -1, 64))
+	}
+	return ""
+}
+
+func (r rule) number(n float64, s string) string {
+	switch r.name {
+	case "min", "max":
+		return r.bound(n, "value")
+	case "enum":
+		for _, e := range r.enum {
+			if e == s {
+				return ""
+			}
+		}
+		return "should be one of " + strings.Join(r.enum, ", ")
+	}
+	return ""
+}
+
+func (r rule) bound(n float64, what string) string {
+	if r.name == "min" && n < r.num {
+		return what + " should not less than " + r.arg
+	}
+	if r.name == "max" && n > r.num {
+		return what + " should not greater than " + r.arg
+	}
+	return ""
+}
+
+//endregion
@@ -0,0 +1,274 @@
+package htt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/gorilla/mux"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type (
+	bindAddress struct {
+		City string `json:"city" validate:"required"`
+		Zip  string `json:"zip" validate:"regex=^[0-9]{5}$"`
+	}
+	bindUser struct {
+		Name    string        `json:"name" validate:"required,min=2,max=8"`
+		Email   string        `json:"email" validate:"email"`
+		Age     int           `json:"age" validate:"min=18,max=150"`
+		Score   *float64      `json:"score" validate:"max=1"`
+		Role    string        `json:"role" validate:"enum=admin|user"`
+		Level   int           `json:"level" validate:"enum=1|2|3"`
+		Tags    []string      `json:"tags" validate:"max=2,enum=a|b|c"`
+		Code    string        `json:"code" validate:"regex=^x{1\\,3}$"`
+		Home    *bindAddress  `json:"home"`
+		Others  []bindAddress `json:"others"`
+		Created time.Time     `json:"created"`
+	}
+	bindBadTag struct {
+		Name string `validate:"length=3"`
+	}
+	bindBadRegex struct {
+		Name string `validate:"regex=(["`
+	}
+	bindNestedBad struct {
+		Inner bindBadTag
+	}
+	bindRequest struct {
+		ID    int      `path:"id" validate:"min=1"`
+		Page  int      `query:"page" validate:"max=10"`
+		Sort  []string `query:"sort"`
+		Name  string   `json:"name" validate:"required"`
+		Since time.Duration
+	}
+	bindForm struct {
+		Name  string                `form:"name" validate:"required"`
+		Count uint                  `form:"count"`
+		On    bool                  `json:"on"`
+		File  *multipart.FileHeader `form:"file"`
+	}
+)
+
+func fieldErrors(t *testing.T, err error) map[string]string {
+	t.Helper()
+	var ve ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("want validation error, got %v", err)
+	}
+	m := make(map[string]string, len(ve))
+	for _, f := range ve {
+		m[f.Field] = f.Rule
+	}
+	return m
+}
+
+func TestValidateRules(t *testing.T) {
+	one, two := 1.0, 2.0
+	valid := bindUser{Name: "alice", Email: "a@b.c", Age: 20, Score: &one, Role: "admin", Level: 2,
+		Tags: []string{"a", "c"}, Code: "xx", Home: &bindAddress{City: "x", Zip: "12345"}, Others: []bindAddress{{City: "y"}}}
+	if err := Validate(&valid); err != nil {
+		t.Fatalf("valid user: %v", err)
+	}
+	if err := Validate(valid); err != nil {
+		t.Fatalf("valid user by value: %v", err)
+	}
+	if err := Validate((*bindUser)(nil)); err != nil {
+		t.Fatalf("nil pointer: %v", err)
+	}
+	if err := Validate(1); err == nil {
+		t.Fatal("not a struct should fail")
+	}
+	for name, c := range map[string]struct {
+		edit  func(u *bindUser)
+		field string
+		rule  string
+	}{
+		"required":       {func(u *bindUser) { u.Name = "" }, "name", "required"},
+		"min length":     {func(u *bindUser) { u.Name = "a" }, "name", "min"},
+		"max length":     {func(u *bindUser) { u.Name = "alice-bob" }, "name", "max"},
+		"email":          {func(u *bindUser) { u.Email = "Alice <a@b.c>" }, "email", "email"},
+		"min value":      {func(u *bindUser) { u.Age = 17 }, "age", "min"},
+		"max value":      {func(u *bindUser) { u.Age = 151 }, "age", "max"},
+		"pointer":        {func(u *bindUser) { u.Score = &two }, "score", "max"},
+		"enum":           {func(u *bindUser) { u.Role = "root" }, "role", "enum"},
+		"enum number":    {func(u *bindUser) { u.Level = 4 }, "level", "enum"},
+		"slice length":   {func(u *bindUser) { u.Tags = []string{"a", "b", "c"} }, "tags", "max"},
+		"slice item":     {func(u *bindUser) { u.Tags = []string{"d"} }, "tags", "enum"},
+		"regex comma":    {func(u *bindUser) { u.Code = "xxxx" }, "code", "regex"},
+		"nested":         {func(u *bindUser) { u.Home.Zip = "1234" }, "home.zip", "regex"},
+		"nested require": {func(u *bindUser) { u.Home.City = "" }, "home.city", "required"},
+		"dive slice":     {func(u *bindUser) { u.Others = append(u.Others, bindAddress{}) }, "others[1].city", "required"},
+	} {
+		u := valid
+		h := *valid.Home
+		u.Home = &h
+		u.Others = append([]bindAddress(nil), valid.Others...)
+		c.edit(&u)
+		errs := fieldErrors(t, Validate(&u))
+		if len(errs) != 1 || errs[c.field] != c.rule {
+			t.Fatalf("%s: want %s of %s, got %v", name, c.rule, c.field, errs)
+		}
+	}
+	optional := bindUser{Name: "bob", Age: 18, Level: 1}
+	if err := Validate(&optional); err != nil {
+		t.Fatalf("empty strings, nil pointers and slices are optional: %v", err)
+	}
+}
+
+func TestValidateTag(t *testing.T) {
+	for _, v := range []any{bindBadTag{}, bindBadRegex{}, bindNestedBad{Inner: bindBadTag{Name: "x"}}} {
+		var err error
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("%T panics: %v", v, r)
+				}
+			}()
+			err = Validate(v)
+		}()
+		var te *TagError
+		if !errors.As(err, &te) || te.Field != "Name" {
+			t.Fatalf("%T want tag error, got %v", v, err)
+		}
+		if again := Validate(v); again == nil || again.Error() != err.Error() {
+			t.Fatalf("%T cached error %v, want %v", v, again, err)
+		}
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	_, err := Bind[bindBadTag](r)
+	w := httptest.NewRecorder()
+	WriteBindError(w, err)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("tag error status %d", w.Code)
+	}
+	if got := splitRules(`regex=a\,b,required,,enum=x|y`); strings.Join(got, "/") != "regex=a,b/required//enum=x|y" {
+		t.Fatalf("split %q", got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	bind := func(r *http.Request) (v bindRequest, err error) {
+		m := mux.NewRouter()
+		m.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) { v, err = Bind[bindRequest](r) })
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		return
+	}
+	r := httptest.NewRequest(http.MethodPost, "/items/7?page=2&sort=a&sort=b", strings.NewReader(`{"name":"x","Since":5}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	v, err := bind(r)
+	if err != nil || v.ID != 7 || v.Page != 2 || strings.Join(v.Sort, ",") != "a,b" || v.Name != "x" || v.Since != 5 {
+		t.Fatalf("bind %+v %v", v, err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/items/0?page=11", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	if _, err = bind(r); len(fieldErrors(t, err)) != 3 {
+		t.Fatalf("want id, page and name errors: %v", err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/items/x?page=y", strings.NewReader(`{"name":"x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if errs := fieldErrors(t, func() error { _, err := bind(r); return err }()); errs["id"] != "type" || errs["page"] != "type" {
+		t.Fatalf("type errors %v", errs)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/items/1", strings.NewReader(`{"name":1}`))
+	r.Header.Set("Content-Type", "application/json")
+	if errs := fieldErrors(t, func() error { _, err := bind(r); return err }()); errs["name"] != "type" {
+		t.Fatalf("json type errors %v", errs)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/items/1", strings.NewReader(`{"name":`))
+	r.Header.Set("Content-Type", "application/problem+json")
+	if errs := fieldErrors(t, func() error { _, err := bind(r); return err }()); errs[""] != "json" {
+		t.Fatalf("json syntax errors %v", errs)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(url.Values{"name": {"n"}, "count": {"3"}, "on": {"true"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	f, err := Bind[*bindForm](r)
+	if err != nil || f.Name != "n" || f.Count != 3 || !f.On {
+		t.Fatalf("form %+v %v", f, err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("count=-1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if errs := fieldErrors(t, func() error { _, err := Bind[bindForm](r); return err }()); errs["count"] != "type" {
+		t.Fatalf("form errors %v", errs)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("name", "m")
+	fw, _ := mw.CreateFormFile("file", "a.txt")
+	_, _ = fw.Write([]byte("content"))
+	_ = mw.Close()
+	r = httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	f, err = Bind[*bindForm](r)
+	if err != nil || f.Name != "m" || f.File == nil || f.File.Filename != "a.txt" || f.File.Size != 7 {
+		t.Fatalf("multipart %+v %v", f, err)
+	}
+}
+
+func TestBindError(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteBindError(w, ValidationError{{Field: "name", Rule: "required", Message: "is required"}})
+	var e units.JsonError
+	if err := json.NewDecoder(w.Body).Decode(&e); err != nil || w.Code != http.StatusBadRequest || e.Code != http.StatusBadRequest {
+		t.Fatalf("validation error %d %+v %v", w.Code, e, err)
+	}
+	w = httptest.NewRecorder()
+	WriteBindError(w, errors.New("boom"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("other error %d", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	if _, ok := BindOrError[bindForm](w, r); ok || w.Code != http.StatusBadRequest {
+		t.Fatalf("bind or error %v %d", ok, w.Code)
+	}
+}
+
+func TestBindGabs(t *testing.T) {
+	rules := map[string]string{
+		"name":      "required,min=2",
+		"age":       "min=18",
+		"role":      "enum=admin|user",
+		"tags":      "max=2,enum=a|b",
+		"home.zip":  `regex=^[0-9]{5}$`,
+		"home.city": "required",
+	}
+	read := func(body string, rules map[string]string) (units.Gabs, error) {
+		return BindGabs(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), rules)
+	}
+	g, err := read(`{"name":"alice","age":20,"role":"user","tags":["a"],"home":{"zip":"12345","city":"x"}}`, rules)
+	if name, _ := g.String("name"); err != nil || name != "alice" {
+		t.Fatalf("valid %v", err)
+	}
+	_, err = read(`{"name":"a","age":17,"role":"root","tags":["a","b","c"],"home":{"zip":"1"}}`, rules)
+	errs := fieldErrors(t, err)
+	for f, rule := range map[string]string{"name": "min", "age": "min", "role": "enum", "tags": "max", "home.zip": "regex", "home.city": "required"} {
+		if errs[f] != rule {
+			t.Fatalf("want %s of %s: %v", rule, f, errs)
+		}
+	}
+	if errs = fieldErrors(t, func() error { _, err := read(`{"tags":["c"]}`, rules); return err }()); errs["tags"] != "enum" || errs["name"] != "required" || len(errs) != 3 {
+		t.Fatalf("item and absent errors %v", errs)
+	}
+	if errs = fieldErrors(t, func() error { _, err := read(`{"name":`, nil); return err }()); errs[""] != "json" {
+		t.Fatalf("invalid json %v", errs)
+	}
+	if _, err = BindGabs(httptest.NewRequest(http.MethodPost, "/", http.NoBody), nil); err == nil {
+		t.Fatal("empty body should fail")
+	}
+	var te *TagError
+	if _, err = read(`{}`, map[string]string{"name": "size=1"}); !errors.As(err, &te) || te.Field != "name" {
+		t.Fatalf("malformed rule %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package htt
+
+import (
+	"github.com/ZenLiuCN/gofra/conf"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS a Cross-Origin Resource Sharing middleware.
+//
+// Origins can be exact values like `https://example.com`, wildcards like `https://*.example.com`,
+// regular expressions with prefix `~` like `~^https://(a|b)\.example\.com$` or `*` for any origin.
+// The matched request origin is echoed as the only value of Access-Control-Allow-Origin.
+type CORS struct {
+	Methods        []string      //allowed methods for preflight
+	Headers        []string      //allowed request headers, empty to echo Access-Control-Request-Headers
+	Expose         []string      //exposed response headers
+	Credentials    bool          //allow credentials
+	MaxAge         time.Duration //preflight cache duration, zero to omit
+	PrivateNetwork bool          //allow private network access requests
+	any            bool
+	exact          map[string]struct{}
+	wildcard       [][2]string
+	regex          []*regexp.Regexp
+}
+
+var (
+	DefaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+)
+
+/*
+NewCORS create [CORS] from config.
+
+HOCON sample:
+
+	cors{
+	 origin: ["https://example.com","https://*.example.com","~^https://.+\\.example\\.org$"] # default is *
+	 methods: [GET,POST] # default is GET,HEAD,POST,PUT,PATCH,DELETE
+	 headers: [Content-Type] # default echo requested headers
+	 authorization: true # append Authorization to headers
+	 expose: [X-Request-ID]
+	 credentials: true
+	 maxAge: 10m
+	 privateNetwork: false
+	}
+*/
+func NewCORS(cfg conf.Config) (c *CORS, err error) {
+	c = &CORS{exact: map[string]struct{}{}}
+	if err = c.AllowOrigins(cfg.GetStringList("cors.origin")...); err != nil {
+		return nil, err
+	}
+	if c.Methods = cfg.GetStringList("cors.methods"); len(c.Methods) == 0 {
+		c.Methods = slices.Clone(DefaultCORSMethods)
+	}
+	for i, m := range c.Methods {
+		c.Methods[i] = strings.ToUpper(m)
+	}
+	c.Headers = cfg.GetStringList("cors.headers")
+	if len(c.Headers) == 1 && c.Headers[0] == "*" {
+		c.Headers = nil
+	}
+	if len(c.Headers) > 0 && cfg.GetBoolean("cors.authorization", false) {
+		c.Headers = append(c.Headers, "Authorization")
+	}
+	c.Expose = cfg.GetStringList("cors.expose")
+	c.Credentials = cfg.GetBoolean("cors.credentials", false)
+	c.MaxAge = cfg.GetTimeDuration("cors.maxAge", 0)
+	c.PrivateNetwork = cfg.GetBoolean("cors.privateNetwork", false)
+	return
+}
+
+// AllowOrigins append allowed origins, empty means any origin.
+func (c *CORS) AllowOrigins(origins ...string) error {
+	if c.exact == nil {
+		c.exact = map[string]struct{}{}
+	}
+	if len(origins) == 0 && len(c.exact) == 0 && len(c.wildcard) == 0 && len(c.regex) == 0 {
+		c.any = true
+	}
+	for _, o := range origins {
+		switch {
+		case o == "*":
+			c.any = true
+		case strings.HasPrefix(o, "~"):
+			r, err := regexp.Compile(o[1:])
+			if err != nil {
+				return err
+			}
+			c.regex = append(c.regex, r)
+		case strings.Contains(o, "*"):
+			i := strings.Index(o, "*")
+			c.wildcard = append(c.wildcard, [2]string{strings.ToLower(o[:i]), strings.ToLower(o[i+1:])})
+		default:
+			c.exact[strings.ToLower(strings.TrimSuffix(o, "/"))] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// Allowed check if the origin is allowed
+func (c *CORS) Allowed(origin string) bool {
+	if c.any {
+		return true
+	}
+	o := strings.ToLower(origin)
+	if _, ok := c.exact[o]; ok {
+		return true
+	}
+	for _, w := range c.wildcard {
+		if len(o) > len(w[0])+len(w[1]) && strings.HasPrefix(o, w[0]) && strings.HasSuffix(o, w[1]) &&
+			!strings.ContainsAny(o[len(w[0]):len(o)-len(w[1])], "/:") {
+			return true
+		}
+	}
+	for _, r := range c.regex {
+		if r.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight check if the request is a CORS preflight request
+func Preflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (c *CORS) allowOrigin(h http.Header, origin string) {
+	if c.any && !c.Credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// Middleware handle preflight requests and set CORS headers for actual requests.
+// Preflight requests are answered directly, other OPTIONS requests are passed to next.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+		if !c.any || c.Credentials {
+			h.Add("Vary", "Origin")
+		}
+		if Preflight(r) {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			if !c.Allowed(origin) || !slices.Contains(c.Methods, strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			c.allowOrigin(h, origin)
+			h.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+			if len(c.Headers) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+			} else if v := r.Header.Get("Access-Control-Request-Headers"); v != "" {
+				h.Set("Access-Control-Allow-Headers", v)
+			}
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+			if c.PrivateNetwork && r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+				h.Set("Access-Control-Allow-Private-Network", "true")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if origin != "" && c.Allowed(origin) {
+			c.allowOrigin(h, origin)
+			if len(c.Expose) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(c.Expose, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
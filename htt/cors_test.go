@@ -0,0 +1,164 @@
+package htt
+
+import (
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func corsOf(t *testing.T, src string) *CORS {
+	c, err := NewCORS(conf.NewConfig(hocon.ParseString(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func preflight(origin, method string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/api", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	return r
+}
+
+func TestCORSAllowed(t *testing.T) {
+	c := corsOf(t, `cors{origin: ["https://example.com/", "https://*.example.com", "~^https://(a|b)\\.example\\.org$"]}`)
+	for origin, ok := range map[string]bool{
+		"https://example.com":           true,
+		"HTTPS://EXAMPLE.COM":           true,
+		"https://api.example.com":       true,
+		"https://.example.com":          false,
+		"https://evil.com/.example.com": false,
+		"https://a.b:1.example.com":     false,
+		"https://a.example.org":         true,
+		"https://c.example.org":         false,
+		"http://example.com":            false,
+		"https://example.com.evil.com":  false,
+	} {
+		if c.Allowed(origin) != ok {
+			t.Fatalf("%s: expect %v", origin, ok)
+		}
+	}
+	if !corsOf(t, `cors{}`).Allowed("https://any.where") {
+		t.Fatal("empty origins should allow any")
+	}
+	if _, err := NewCORS(conf.NewConfig(hocon.ParseString(`cors{origin: ["~("]}`))); err == nil {
+		t.Fatal("expect invalid regex error")
+	}
+}
+
+func TestCORSDefaultMethods(t *testing.T) {
+	before := slices.Clone(DefaultCORSMethods)
+	c := corsOf(t, `cors{}`)
+	c.Methods[0] = "TRACE"
+	if !slices.Equal(before, DefaultCORSMethods) {
+		t.Fatalf("default methods modified: %v", DefaultCORSMethods)
+	}
+	if c = corsOf(t, `cors{methods: [get, post]}`); !slices.Equal(c.Methods, []string{"GET", "POST"}) {
+		t.Fatalf("methods %v", c.Methods)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	c := corsOf(t, `cors{
+ origin: ["https://example.com"]
+ methods: [GET, POST]
+ headers: [Content-Type]
+ authorization: true
+ expose: [X-Request-ID]
+ credentials: true
+ maxAge: 10m
+ privateNetwork: true
+}`)
+	var called int
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+	serve := func(r *http.Request) http.Header {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		w.Header().Set(":status", http.StatusText(w.Code))
+		return w.Header()
+	}
+	r := preflight("https://example.com", "post")
+	r.Header.Set("Access-Control-Request-Private-Network", "true")
+	v := serve(r)
+	if v.Get(":status") != http.StatusText(http.StatusNoContent) || called != 0 ||
+		v.Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		v.Get("Access-Control-Allow-Credentials") != "true" ||
+		v.Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		v.Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" ||
+		v.Get("Access-Control-Max-Age") != "600" ||
+		v.Get("Access-Control-Allow-Private-Network") != "true" ||
+		!slices.Equal(v.Values("Vary"), []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}) {
+		t.Fatalf("preflight: %v", v)
+	}
+	for name, r := range map[string]*http.Request{
+		"origin": preflight("https://evil.com", "GET"),
+		"method": preflight("https://example.com", "DELETE"),
+	} {
+		if v = serve(r); v.Get(":status") != http.StatusText(http.StatusForbidden) || v.Get("Access-Control-Allow-Origin") != "" || called != 0 {
+			t.Fatalf("rejected %s: %v", name, v)
+		}
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api", nil)
+	r.Header.Set("Origin", "https://example.com")
+	if v = serve(r); called != 1 || v.Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		v.Get("Access-Control-Allow-Credentials") != "true" || v.Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+		t.Fatalf("actual request: %v", v)
+	}
+	r.Header.Set("Origin", "https://evil.com")
+	if v = serve(r); called != 2 || v.Get("Access-Control-Allow-Origin") != "" || v.Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("rejected actual request: %v", v)
+	}
+	r = httptest.NewRequest(http.MethodOptions, "/api", nil)
+	if serve(r); called != 3 {
+		t.Fatal("plain OPTIONS not passed to next")
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := corsOf(t, `cors{headers: ["*"]}`).Middleware(http.NotFoundHandler())
+	r := preflight("https://a.com", "PATCH")
+	r.Header.Set("Access-Control-Request-Headers", "X-A, X-B")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Headers") != "X-A, X-B" || w.Header().Get("Vary") == "Origin" {
+		t.Fatalf("any origin: %v", w.Header())
+	}
+	h = corsOf(t, `cors{credentials: true}`).Middleware(http.NotFoundHandler())
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, preflight("https://a.com", "GET"))
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://a.com" || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("any origin with credentials must echo origin: %v", w.Header())
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	r := RouterConfigurerOf(mux.NewRouter()).WithCORS(conf.NewConfig(hocon.ParseString(`cors{origin: ["https://example.com"]}`)))
+	r.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodPost)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, preflight("https://example.com", "POST"))
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Fatalf("preflight of route without OPTIONS: %d %v", w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, preflight("https://evil.com", "POST"))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("rejected preflight: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api", nil)
+	req.Header.Set("Origin", "https://example.com")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Fatalf("method not allowed: %d %v", w.Code, w.Header())
+	}
+	if r.Get() != RouterConfigurerOf(r.Router).WithCORS(nil).Get() {
+		t.Fatal("nil config should be ignored")
+	}
+}
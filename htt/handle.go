@@ -0,0 +1,254 @@
+package htt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Codec encodes and decodes bodies of media types
+	Codec interface {
+		// MediaTypes supported, the first is used as Content-Type
+		MediaTypes() []string
+		// Supports check if the value can be encoded or decoded, v is the value or pointer to the value
+		Supports(v any) bool
+		Marshal(v any) ([]byte, error)
+		Unmarshal(data []byte, v any) error
+	}
+	// StatusCoder response with status other than 200
+	StatusCoder interface {
+		StatusCode() int
+	}
+	jsonCodec     struct{}
+	msgpackCodec  struct{}
+	protobufCodec struct{}
+	requestKey    struct{}
+)
+
+var (
+	codecLock sync.RWMutex
+	codecs    = []Codec{jsonCodec{}, msgpackCodec{}, protobufCodec{}}
+)
+
+// RegisterCodec add a codec, which takes precedence over registered codecs of the same media type.
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs = append([]Codec{c}, codecs...)
+}
+
+// CodecOf find the codec of media type which supports v, returns nil when not found.
+func CodecOf(mediaType string, v any) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for _, c := range codecs {
+		for _, t := range c.MediaTypes() {
+			if t == mediaType && c.Supports(v) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// NegotiateCodec choose codec by Accept header, fallback to JSON.
+func NegotiateCodec(r *http.Request, v any) Codec {
+	type mediaRange struct {
+		t string
+		q float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{t, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, m := range ranges {
+		if m.t == "*/*" || m.t == "application/*" {
+			break
+		}
+		if c := CodecOf(m.t, v); c != nil {
+			return c
+		}
+	}
+	return jsonCodec{}
+}
+
+// RequestOf fetch the request from context of handlers created by [Handle]
+func RequestOf(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestKey{}).(*http.Request)
+	return r
+}
+
+/*
+Handle create handler of typed function.
+
+The request is decoded by [Bind], bodies of other registered codecs such as MessagePack and protobuf are also supported.
+Req may be a struct or pointer to struct, use struct{} for no input.
+Other types such as slices and maps are decoded from the body only, by JSON or registered codecs.
+
+The response is encoded by the codec negotiated with Accept header, see [Respond].
+
+Errors are written by [WriteProblem], which maps [units.ResponseError] codes, [ValidationError] and registered errors to statuses.
+The request is available via [RequestOf] in fn.
+*/
+func Handle[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.Handler {
+	bind := Bind[Req]
+	if t := reflect.TypeOf((*Req)(nil)).Elem(); t.Kind() != reflect.Struct && (t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct) {
+		bind = decodeRequest[Req]
+	}
+	return ProblemHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		req, err := bind(r)
+		if err != nil {
+			return err
+		}
+		resp, err := fn(context.WithValue(r.Context(), requestKey{}, r), req)
+		if err != nil {
+			return err
+		}
+		return Respond(w, r, http.StatusOK, resp)
+	})
+}
+
+// Respond write v with status by the codec negotiated, see [NegotiateCodec].
+// Nil v writes 204, v implements [StatusCoder] overrides the status.
+func Respond(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	if isNil(v) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if s, ok := v.(StatusCoder); ok {
+		status = s.StatusCode()
+	}
+	c := NegotiateCodec(r, v)
+	b, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	h.Set("Content-Type", c.MediaTypes()[0])
+	h.Add("Vary", "Accept")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, err = w.Write(b)
+	}
+	return err
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil() && rv.Kind() != reflect.Slice
+	}
+	return false
+}
+
+// decodeRequest decode the body into non-struct T by JSON or registered codecs, see [Handle].
+func decodeRequest[T any](r *http.Request) (v T, err error) {
+	if r.Body == nil || r.Body == http.NoBody || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "application/json" || strings.HasSuffix(ct, "+json") {
+		if e := json.NewDecoder(r.Body).Decode(&v); e != nil && !errors.Is(e, io.EOF) {
+			err = jsonFieldError(e)
+		}
+		return
+	}
+	err = decodeBody(r, ct, reflect.ValueOf(&v).Elem())
+	return
+}
+
+// decodeBody decode body of registered codecs except JSON.
+// Non-empty bodies of unsupported or missing media types are rejected as 415 [Problem].
+func decodeBody(r *http.Request, ct string, rv reflect.Value) error {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		return ValidationError{{Rule: "body", Message: err.Error()}}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	v := rv.Addr().Interface()
+	c := CodecOf(ct, v)
+	if c == nil {
+		r.Body = io.NopCloser(bytes.NewReader(buf.Bytes())) //keep the body for handlers
+		if ct == "" {
+			return NewProblem(http.StatusUnsupportedMediaType, "Content-Type is required")
+		}
+		return NewProblem(http.StatusUnsupportedMediaType, fmt.Sprintf("%s not supported", ct))
+	}
+	if err := c.Unmarshal(buf.Bytes(), v); err != nil {
+		return ValidationError{{Rule: "body", Message: err.Error()}}
+	}
+	return nil
+}
+
+//region Codecs
+
+func (jsonCodec) MediaTypes() []string          { return []string{"application/json"} }
+func (jsonCodec) Supports(any) bool             { return true }
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (msgpackCodec) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+}
+func (msgpackCodec) Supports(any) bool { return true }
+
+// Marshal use json tags as field names
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	e := msgpack.NewEncoder(&buf)
+	e.SetCustomStructTag("json")
+	err := e.Encode(v)
+	return buf.Bytes(), err
+}
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	d := msgpack.NewDecoder(bytes.NewReader(data))
+	d.SetCustomStructTag("json")
+	return d.Decode(v)
+}
+
+func (protobufCodec) MediaTypes() []string {
+	return []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf"}
+}
+func (protobufCodec) Supports(v any) bool {
+	_, ok := v.(proto.Message)
+	return ok
+}
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	return proto.Marshal(v.(proto.Message))
+}
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+//endregion
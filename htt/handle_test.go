@@ -0,0 +1,159 @@
+package htt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type (
+	handleReq struct {
+		Name string `json:"name" validate:"required"`
+	}
+	handleResp struct {
+		Greeting string `json:"greeting"`
+		Path     string `json:"path"`
+	}
+	createdResp struct {
+		ID int `json:"id"`
+	}
+)
+
+func (createdResp) StatusCode() int { return http.StatusCreated }
+
+func handleDo(h http.Handler, method, ct, accept string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/hello", bytes.NewReader(body))
+	if ct != "" {
+		r.Header.Set("Content-Type", ct)
+	}
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandle(t *testing.T) {
+	h := Handle(func(ctx context.Context, req handleReq) (handleResp, error) {
+		return handleResp{Greeting: "hello " + req.Name, Path: RequestOf(ctx).URL.Path}, nil
+	})
+	w := handleDo(h, http.MethodPost, "application/json", "", []byte(`{"name":"json"}`))
+	var resp handleResp
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK || resp.Greeting != "hello json" || resp.Path != "/hello" {
+		t.Fatalf("json %d %+v %v", w.Code, resp, err)
+	}
+
+	b, _ := msgpack.Marshal(map[string]string{"name": "msgpack"})
+	w = handleDo(h, http.MethodPost, "application/x-msgpack", "application/msgpack", b)
+	resp = handleResp{}
+	if err := (msgpackCodec{}).Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Header().Get("Content-Type") != "application/msgpack" || resp.Greeting != "hello msgpack" {
+		t.Fatalf("msgpack %d %+v %v", w.Code, resp, err)
+	}
+
+	w = handleDo(h, http.MethodPost, "application/json", "", []byte(`{}`))
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != ContentTypeProblem {
+		t.Fatalf("validation %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	for _, ct := range []string{"text/plain", "application/xml; charset=utf-8", "", "application/x-protobuf"} {
+		w = handleDo(h, http.MethodPost, ct, "", []byte(`name=x`))
+		var p map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil || w.Code != http.StatusUnsupportedMediaType || p["status"] != float64(http.StatusUnsupportedMediaType) {
+			t.Fatalf("unsupported %q: %d %v %v", ct, w.Code, p, err)
+		}
+	}
+	if w = handleDo(h, http.MethodPost, "text/plain", "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("empty body of unknown type should be ignored, got %d", w.Code)
+	}
+	if w = handleDo(h, http.MethodGet, "text/plain", "", []byte("ignored")); w.Code != http.StatusBadRequest {
+		t.Fatalf("body of GET should be ignored, got %d", w.Code)
+	}
+}
+
+func TestHandleProtobuf(t *testing.T) {
+	h := Handle(func(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		return wrapperspb.String("hello " + req.GetValue()), nil
+	})
+	b, _ := proto.Marshal(wrapperspb.String("proto"))
+	w := handleDo(h, http.MethodPost, "application/x-protobuf", "application/protobuf, application/json;q=0.5", b)
+	var resp wrapperspb.StringValue
+	if err := proto.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.GetValue() != "hello proto" {
+		t.Fatalf("protobuf %d %v %v", w.Code, resp.GetValue(), err)
+	}
+	if w = handleDo(h, http.MethodPost, "application/x-protobuf", "", []byte{0xff}); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid protobuf %d", w.Code)
+	}
+}
+
+func TestHandleNonStruct(t *testing.T) {
+	h := Handle(func(ctx context.Context, req []handleReq) (int, error) {
+		return len(req), nil
+	})
+	if w := handleDo(h, http.MethodPost, "application/json", "", []byte(`[{"name":"a"},{"name":"b"}]`)); w.Code != http.StatusOK || w.Body.String() != "2" {
+		t.Fatalf("slice %d %q", w.Code, w.Body.String())
+	}
+	b, _ := msgpack.Marshal([]handleReq{{Name: "a"}})
+	if w := handleDo(h, http.MethodPost, "application/msgpack", "application/json", b); w.Code != http.StatusOK || w.Body.String() != "1" {
+		t.Fatalf("msgpack slice %d %q", w.Code, w.Body.String())
+	}
+	if w := handleDo(h, http.MethodPost, "application/json", "", []byte(`{"name":"a"}`)); w.Code != http.StatusBadRequest {
+		t.Fatalf("object to slice %d", w.Code)
+	}
+	if w := handleDo(h, http.MethodPost, "application/x-www-form-urlencoded", "", []byte(`name=a`)); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("form to slice %d", w.Code)
+	}
+	m := Handle(func(ctx context.Context, req map[string]any) (map[string]any, error) {
+		return req, nil
+	})
+	if w := handleDo(m, http.MethodPost, "application/json", "", []byte(`{"a":1}`)); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"a":1}` {
+		t.Fatalf("map %d %q", w.Code, w.Body.String())
+	}
+	if w := handleDo(m, http.MethodGet, "", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("map without body %d", w.Code)
+	}
+}
+
+func TestRespond(t *testing.T) {
+	for name, c := range map[string]struct {
+		v      any
+		status int
+	}{
+		"nil":         {nil, http.StatusNoContent},
+		"nil pointer": {(*handleResp)(nil), http.StatusNoContent},
+		"status":      {createdResp{ID: 1}, http.StatusCreated},
+		"empty slice": {[]int{}, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		if err := Respond(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, c.v); err != nil || w.Code != c.status {
+			t.Fatalf("%s: %d %v", name, w.Code, err)
+		}
+	}
+	w := httptest.NewRecorder()
+	_ = Respond(w, httptest.NewRequest(http.MethodHead, "/", nil), http.StatusOK, handleResp{})
+	if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("head %q", w.Body.String())
+	}
+}
+
+func TestBindUnsupportedMediaType(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=x"))
+	r.Header.Set("Content-Type", "text/csv")
+	_, err := Bind[handleReq](r)
+	w := httptest.NewRecorder()
+	WriteBindError(w, err)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("bind error status %d", w.Code)
+	}
+	if b, _ := io.ReadAll(r.Body); string(b) != "name=x" {
+		t.Fatalf("body should be kept for handlers, got %q", b)
+	}
+}
@@ -0,0 +1,349 @@
+package htt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ZenLiuCN/gofra/breaker"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/modeler"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// HealthCheck returns nil when the component is healthy
+	HealthCheck func(ctx context.Context) error
+	// ProbeKind which probes a check participates in
+	ProbeKind int
+	// HealthProbe a registered check
+	HealthProbe struct {
+		Name     string
+		Check    HealthCheck
+		Timeout  time.Duration //default is timeout of [Health]
+		Critical bool          //failure of non-critical checks only degrades the status
+		Kind     ProbeKind
+	}
+	// Backlogged a queue reports its backlog, such as [units.Ring]
+	Backlogged interface {
+		Backlog() (queued, capacity int)
+	}
+	// HealthOption option of [Health.Register]
+	HealthOption func(*HealthProbe)
+	// CheckResult result of one check
+	CheckResult struct {
+		Status   string `json:"status"`
+		Critical bool   `json:"critical"`
+		Error    string `json:"error,omitempty"`
+		Duration string `json:"duration"`
+	}
+	// HealthReport result of a probe
+	HealthReport struct {
+		Status string                 `json:"status"` //up, degraded or down
+		Checks map[string]CheckResult `json:"checks,omitempty"`
+	}
+	// Health registry of health checks, which serves Kubernetes style probes:
+	//
+	//   - /livez: checks of [Liveness], which should only fail when the process must be restarted.
+	//   - /readyz: checks of [Readiness] and the readiness of attached servers, which fails during graceful shutdown.
+	//   - /healthz: all checks.
+	Health struct {
+		Timeout  time.Duration //default timeout of each check
+		lock     sync.RWMutex
+		probes   []HealthProbe
+		shutdown atomic.Bool
+	}
+)
+
+const (
+	Readiness ProbeKind = 1 << iota
+	Liveness
+)
+
+const (
+	HealthUp       = "up"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// WithCheckTimeout set timeout of the check
+func WithCheckTimeout(d time.Duration) HealthOption {
+	return func(p *HealthProbe) {
+		p.Timeout = d
+	}
+}
+
+// NonCritical mark the check not critical
+func NonCritical() HealthOption {
+	return func(p *HealthProbe) {
+		p.Critical = false
+	}
+}
+
+// WithProbes set the probes the check participates in, default is [Readiness]
+func WithProbes(kind ProbeKind) HealthOption {
+	return func(p *HealthProbe) {
+		p.Kind = kind
+	}
+}
+
+/*
+NewHealth create [Health] from config, cfg is optional.
+
+HOCON sample:
+
+	health{
+	 timeout: 3s # default timeout of each check
+	 disk{ path: logs, minFree: 100m } # disk space check, path default is the directory of log.file
+	}
+*/
+func NewHealth(cfg conf.Config) *Health {
+	h := &Health{Timeout: 3 * time.Second}
+	if cfg == nil {
+		return h
+	}
+	h.Timeout = cfg.GetTimeDuration("health.timeout", h.Timeout)
+	if cfg.IsObject("health.disk") {
+		path := cfg.GetString("health.disk.path")
+		if path == "" {
+			if f := cfg.GetString("log.file"); f != "" {
+				path = filepath.Dir(f)
+			} else {
+				path = "."
+			}
+		}
+		h.Register("disk", DiskCheck(path, cfg.GetByteSizeOr("health.disk.minFree", big.NewInt(100<<20)).Uint64()))
+	}
+	return h
+}
+
+// Register a check, which is critical and participates in [Readiness] by default.
+func (h *Health) Register(name string, check HealthCheck, opts ...HealthOption) *Health {
+	p := HealthProbe{Name: name, Check: check, Critical: true, Kind: Readiness}
+	for _, o := range opts {
+		o(&p)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, x := range h.probes {
+		if x.Name == name {
+			h.probes[i] = p
+			return h
+		}
+	}
+	h.probes = append(h.probes, p)
+	return h
+}
+
+// Unregister a check
+func (h *Health) Unregister(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, x := range h.probes {
+		if x.Name == name {
+			h.probes = append(h.probes[:i], h.probes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Attach the server, readiness follows the server and turns false when the server starts shutdown.
+func (h *Health) Attach(s *Server) *Health {
+	s.OnBeforeShutdown(func(ctx context.Context) {
+		h.shutdown.Store(true)
+	})
+	return h.Register("server:"+s.Name, func(ctx context.Context) error {
+		if !s.Ready() {
+			return fmt.Errorf("server %s not ready", s.Name)
+		}
+		return nil
+	})
+}
+
+// SetShutdown mark the application shutting down, which fails readiness
+func (h *Health) SetShutdown(v bool) {
+	h.shutdown.Store(v)
+}
+
+func (h *Health) run(ctx context.Context, p HealthProbe) (r CheckResult) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = h.Timeout
+	}
+	if timeout > 0 {
+		var cc context.CancelFunc
+		ctx, cc = context.WithTimeout(ctx, timeout)
+		defer cc()
+	}
+	begin := time.Now()
+	ch := make(chan error, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				ch <- fmt.Errorf("panic: %v", e)
+			}
+		}()
+		ch <- p.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	r.Critical = p.Critical
+	r.Duration = time.Since(begin).String()
+	if err != nil {
+		r.Status = HealthDown
+		r.Error = err.Error()
+	} else {
+		r.Status = HealthUp
+	}
+	return
+}
+
+// Check run checks of the probe kinds concurrently, zero kind means all checks.
+func (h *Health) Check(ctx context.Context, kind ProbeKind) (r HealthReport) {
+	h.lock.RLock()
+	probes := make([]HealthProbe, 0, len(h.probes))
+	for _, p := range h.probes {
+		if kind == 0 || p.Kind&kind != 0 {
+			probes = append(probes, p)
+		}
+	}
+	h.lock.RUnlock()
+	r.Status = HealthUp
+	r.Checks = make(map[string]CheckResult, len(probes))
+	results := make([]CheckResult, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p HealthProbe) {
+			defer wg.Done()
+			results[i] = h.run(ctx, p)
+		}(i, p)
+	}
+	wg.Wait()
+	for i, x := range results {
+		r.Checks[probes[i].Name] = x
+		if x.Status == HealthUp {
+			continue
+		}
+		if x.Critical {
+			r.Status = HealthDown
+		} else if r.Status == HealthUp {
+			r.Status = HealthDegraded
+		}
+	}
+	if kind&Readiness != 0 && h.shutdown.Load() {
+		r.Status = HealthDown
+		r.Checks["shutdown"] = CheckResult{Status: HealthDown, Critical: true, Error: "shutting down", Duration: "0s"}
+	}
+	return
+}
+
+func (h *Health) handler(kind ProbeKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.Check(r.Context(), kind)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status == HealthDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+// Livez handler of liveness probe
+func (h *Health) Livez() http.HandlerFunc {
+	return h.handler(Liveness)
+}
+
+// Readyz handler of readiness probe
+func (h *Health) Readyz() http.HandlerFunc {
+	return h.handler(Readiness)
+}
+
+// Healthz handler of all checks
+func (h *Health) Healthz() http.HandlerFunc {
+	return h.handler(0)
+}
+
+// Names of registered checks
+func (h *Health) Names() (v []string) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for _, p := range h.probes {
+		v = append(v, p.Name)
+	}
+	sort.Strings(v)
+	return
+}
+
+// WithHealth serve /healthz, /readyz and /livez of the [Health]
+func (c RouterConfigurer) WithHealth(h *Health) RouterConfigurer {
+	c.Handle("/healthz", h.Healthz()).Methods(http.MethodGet, http.MethodHead).Name("healthz")
+	c.Handle("/readyz", h.Readyz()).Methods(http.MethodGet, http.MethodHead).Name("readyz")
+	c.Handle("/livez", h.Livez()).Methods(http.MethodGet, http.MethodHead).Name("livez")
+	return c
+}
+
+//region Checks
+
+// DBCheck ping the database
+func DBCheck(db modeler.SqlxExecutor) HealthCheck {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// BreakerCheck fails when any breaker is open
+func BreakerCheck(breakers ...*breaker.Breaker) HealthCheck {
+	return func(ctx context.Context) error {
+		for _, b := range breakers {
+			if b.State() == breaker.StateOpen {
+				return fmt.Errorf("breaker %s is open", b.Name())
+			}
+		}
+		return nil
+	}
+}
+
+// RingCheck fails when the queued events of the ring reach limit, limit less than 1 means the capacity of the queue.
+func RingCheck(r Backlogged, limit int) HealthCheck {
+	return func(ctx context.Context) error {
+		queued, capacity := r.Backlog()
+		n := limit
+		if n < 1 {
+			n = capacity
+		}
+		if n > 0 && queued >= n {
+			return fmt.Errorf("ring backlog %d reaches %d", queued, n)
+		}
+		return nil
+	}
+}
+
+// DiskCheck fails when free space of the file system of path is less than minFree bytes
+func DiskCheck(path string, minFree uint64) HealthCheck {
+	return func(ctx context.Context) error {
+		free, err := diskFree(path)
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("free space of %s is %d bytes, less than %d", path, free, minFree)
+		}
+		return nil
+	}
+}
+
+//endregion
@@ -0,0 +1,12 @@
+package htt
+
+import "golang.org/x/sys/unix"
+
+// diskFree bytes available to unprivileged users of the file system of path
+func diskFree(path string) (uint64, error) {
+	var s unix.Statfs_t
+	if err := unix.Statfs(path, &s); err != nil {
+		return 0, err
+	}
+	return uint64(s.F_bavail) * uint64(s.F_bsize), nil
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || dragonfly || openbsd || netbsd || solaris)
+
+package htt
+
+import "errors"
+
+// diskFree not supported on this platform, the disk check always fails
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("disk check not supported")
+}
@@ -0,0 +1,14 @@
+//go:build netbsd || solaris
+
+package htt
+
+import "golang.org/x/sys/unix"
+
+// diskFree bytes available to unprivileged users of the file system of path
+func diskFree(path string) (uint64, error) {
+	var s unix.Statvfs_t
+	if err := unix.Statvfs(path, &s); err != nil {
+		return 0, err
+	}
+	return uint64(s.Bavail) * uint64(s.Frsize), nil
+}
@@ -0,0 +1,189 @@
+package htt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ZenLiuCN/gofra/breaker"
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"github.com/gorilla/mux"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func healthy(ctx context.Context) error {
+	return nil
+}
+
+func failing(msg string) HealthCheck {
+	return func(ctx context.Context) error {
+		return errors.New(msg)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	h := NewHealth(nil)
+	h.Timeout = 20 * time.Millisecond
+	h.Register("db", healthy).
+		Register("cache", failing("cache down"), NonCritical()).
+		Register("slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}, WithCheckTimeout(10*time.Millisecond), WithProbes(Liveness)).
+		Register("panic", func(ctx context.Context) error {
+			panic("boom")
+		}, WithProbes(0)).
+		Register("both", healthy, WithProbes(Readiness|Liveness))
+	ctx := context.Background()
+	r := h.Check(ctx, Readiness)
+	if r.Status != HealthDegraded || len(r.Checks) != 3 || r.Checks["cache"].Error != "cache down" || r.Checks["cache"].Critical {
+		t.Fatalf("readiness %+v", r)
+	}
+	r = h.Check(ctx, Liveness)
+	if r.Status != HealthDown || len(r.Checks) != 2 || r.Checks["slow"].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("liveness %+v", r)
+	}
+	r = h.Check(ctx, 0)
+	if r.Status != HealthDown || len(r.Checks) != 5 || r.Checks["panic"].Error != "panic: boom" {
+		t.Fatalf("all %+v", r)
+	}
+	h.Register("cache", healthy)
+	h.Unregister("slow")
+	h.Unregister("panic")
+	if v := h.Names(); !slices.Equal(v, []string{"both", "cache", "db"}) {
+		t.Fatalf("names %v", v)
+	}
+	if r = h.Check(ctx, 0); r.Status != HealthUp {
+		t.Fatalf("replaced %+v", r)
+	}
+	h.SetShutdown(true)
+	if r = h.Check(ctx, Readiness); r.Status != HealthDown || r.Checks["shutdown"].Status != HealthDown {
+		t.Fatalf("shutdown readiness %+v", r)
+	}
+	if r = h.Check(ctx, Liveness); r.Status != HealthUp {
+		t.Fatalf("shutdown should not fail liveness %+v", r)
+	}
+}
+
+func TestHealthHandlers(t *testing.T) {
+	h := NewHealth(nil).
+		Register("db", healthy).
+		Register("cache", failing("cache down"), NonCritical(), WithProbes(Liveness)).
+		Register("queue", failing("queue down"), WithProbes(0))
+	r := RouterConfigurerOf(mux.NewRouter()).WithHealth(h)
+	for path, c := range map[string]struct {
+		code   int
+		status string
+	}{
+		"/readyz":  {http.StatusOK, HealthUp},
+		"/livez":   {http.StatusOK, HealthDegraded},
+		"/healthz": {http.StatusServiceUnavailable, HealthDown},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != c.code || report.Status != c.status ||
+			w.Header().Get("Cache-Control") != "no-store" || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("%s: %d %s %v", path, w.Code, w.Body, err)
+		}
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, path, nil))
+		if w.Code != c.code || w.Body.Len() != 0 {
+			t.Fatalf("HEAD %s: %d %s", path, w.Code, w.Body)
+		}
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: %d", w.Code)
+	}
+}
+
+func TestHealthAttach(t *testing.T) {
+	s := localServer(t, "api", http.NotFoundHandler(), "1s")
+	h := NewHealth(nil).Attach(s)
+	ctx := context.Background()
+	if r := h.Check(ctx, Readiness); r.Status != HealthDown || r.Checks["server:api"].Error != "server api not ready" {
+		t.Fatalf("not started %+v", r)
+	}
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if r := h.Check(ctx, Readiness); r.Status != HealthUp {
+		t.Fatalf("started %+v", r)
+	}
+	var during HealthReport
+	s.OnBeforeShutdown(func(ctx context.Context) {
+		during = h.Check(ctx, Readiness)
+	})
+	_ = s.Shutdown(ctx)
+	if during.Status != HealthDown || during.Checks["shutdown"].Status != HealthDown {
+		t.Fatalf("draining %+v", during)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	ctx := context.Background()
+	if err := DiskCheck(".", 0)(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := DiskCheck(".", math.MaxUint64)(ctx); err == nil {
+		t.Fatal("expect not enough space")
+	}
+	if err := DiskCheck("/not/exists", 0)(ctx); err == nil {
+		t.Fatal("expect missing path")
+	}
+	h := NewHealth(conf.NewConfig(hocon.ParseString(`health{timeout: 1s, disk{minFree: 1b}}
+log.file: "logs/app.log"`)))
+	if h.Timeout != time.Second || !slices.Equal(h.Names(), []string{"disk"}) {
+		t.Fatalf("config %+v %v", h, h.Names())
+	}
+
+	b := new(breaker.Breaker)
+	b.Configure(func(c *breaker.Configure) {
+		c.Name = "remote"
+		c.MaxRequests = 1
+		c.ReadyToTrip = func(counter *breaker.Counter) bool { return counter.ConsecutiveFailures > 0 }
+	})
+	done, err := b.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(true)
+	check := BreakerCheck(b)
+	if err = check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	done, _ = b.Prepare()
+	done(false)
+	if err = check(ctx); err == nil || err.Error() != "breaker remote is open" {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		b     backlog
+		limit int
+		fail  bool
+	}{
+		{backlog{0, 4}, 0, false},
+		{backlog{3, 4}, 0, false},
+		{backlog{4, 4}, 0, true},
+		{backlog{1, 4}, 1, true},
+		{backlog{0, 0}, 0, false},
+	} {
+		if err = RingCheck(c.b, c.limit)(ctx); (err != nil) != c.fail {
+			t.Fatalf("%+v limit %d: %v", c.b, c.limit, err)
+		}
+	}
+}
+
+// backlog stub of [Backlogged]
+type backlog struct{ queued, capacity int }
+
+func (b backlog) Backlog() (int, int) { return b.queued, b.capacity }
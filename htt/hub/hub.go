@@ -0,0 +1,270 @@
+package hub
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Client a connection managed by [Hub], such as websocket or SSE emitter
+	Client[M any] interface {
+		// Deliver enqueue the message without blocking, returns false when the queue is full.
+		Deliver(m M) bool
+		// Close the connection
+		Close() error
+	}
+	// Stats metrics of [Hub]
+	Stats struct {
+		Connections int    `json:"connections"`
+		Users       int    `json:"users"`
+		Topics      int    `json:"topics"`
+		Published   uint64 `json:"published"` //messages published
+		Delivered   uint64 `json:"delivered"` //messages enqueued to clients
+		Dropped     uint64 `json:"dropped"`   //messages dropped by full queues
+		Evicted     uint64 `json:"evicted"`   //slow clients closed
+	}
+	// Hub tracks clients by topic and user, then fan out messages to them.
+	//
+	// Delivery never blocks publishers: messages to a client with full queue are dropped,
+	// and the client is evicted when [Hub.Evict] is true.
+	Hub[M any] struct {
+		Evict                                  bool              //close and remove slow clients, default true
+		OnEvict                                func(c Client[M]) //called after a client evicted, optional
+		lock                                   sync.RWMutex
+		clients                                map[Client[M]]*member
+		topics                                 map[string]map[Client[M]]struct{}
+		users                                  map[string]map[Client[M]]struct{}
+		closed                                 bool
+		published, delivered, dropped, evicted atomic.Uint64
+	}
+	member struct {
+		user   string
+		topics map[string]struct{}
+	}
+)
+
+// ErrClosed the hub already closed
+var ErrClosed = errors.New("hub closed")
+
+// New create [Hub] evicts slow clients
+func New[M any]() *Hub[M] {
+	return &Hub[M]{
+		Evict:   true,
+		clients: map[Client[M]]*member{},
+		topics:  map[string]map[Client[M]]struct{}{},
+		users:   map[string]map[Client[M]]struct{}{},
+	}
+}
+
+// Join add client of user with topics, user is optional.
+func (h *Hub[M]) Join(c Client[M], user string, topics ...string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return ErrClosed
+	}
+	m, ok := h.clients[c]
+	if !ok {
+		m = &member{user: user, topics: map[string]struct{}{}}
+		h.clients[c] = m
+		if user != "" {
+			add(h.users, user, c)
+		}
+	}
+	for _, t := range topics {
+		m.topics[t] = struct{}{}
+		add(h.topics, t, c)
+	}
+	return nil
+}
+
+// Leave remove the client, it does not close the client.
+func (h *Hub[M]) Leave(c Client[M]) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.leave(c)
+}
+
+func (h *Hub[M]) leave(c Client[M]) bool {
+	m, ok := h.clients[c]
+	if !ok {
+		return false
+	}
+	delete(h.clients, c)
+	if m.user != "" {
+		remove(h.users, m.user, c)
+	}
+	for t := range m.topics {
+		remove(h.topics, t, c)
+	}
+	return true
+}
+
+// Subscribe topics for a joined client
+func (h *Hub[M]) Subscribe(c Client[M], topics ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	m, ok := h.clients[c]
+	if !ok {
+		return
+	}
+	for _, t := range topics {
+		m.topics[t] = struct{}{}
+		add(h.topics, t, c)
+	}
+}
+
+// Unsubscribe topics of a client
+func (h *Hub[M]) Unsubscribe(c Client[M], topics ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	m, ok := h.clients[c]
+	if !ok {
+		return
+	}
+	for _, t := range topics {
+		delete(m.topics, t)
+		remove(h.topics, t, c)
+	}
+}
+
+// Publish message to subscribers of topic, returns count of clients delivered.
+func (h *Hub[M]) Publish(topic string, msg M) int {
+	h.lock.RLock()
+	targets := collect(h.topics[topic])
+	h.lock.RUnlock()
+	return h.deliver(targets, msg)
+}
+
+// SendUser send message to all clients of the user, returns count of clients delivered.
+func (h *Hub[M]) SendUser(user string, msg M) int {
+	h.lock.RLock()
+	targets := collect(h.users[user])
+	h.lock.RUnlock()
+	return h.deliver(targets, msg)
+}
+
+// Broadcast message to all clients, returns count of clients delivered.
+func (h *Hub[M]) Broadcast(msg M) int {
+	h.lock.RLock()
+	targets := make([]Client[M], 0, len(h.clients))
+	for c := range h.clients {
+		targets = append(targets, c)
+	}
+	h.lock.RUnlock()
+	return h.deliver(targets, msg)
+}
+
+func (h *Hub[M]) deliver(targets []Client[M], msg M) (n int) {
+	h.published.Add(1)
+	var slow []Client[M]
+	for _, c := range targets {
+		if c.Deliver(msg) {
+			n++
+			continue
+		}
+		h.dropped.Add(1)
+		if h.Evict {
+			slow = append(slow, c)
+		}
+	}
+	h.delivered.Add(uint64(n))
+	if len(slow) > 0 {
+		h.lock.Lock()
+		for i, c := range slow {
+			if !h.leave(c) {
+				slow[i] = nil //evicted by others
+			}
+		}
+		h.lock.Unlock()
+		for _, c := range slow {
+			if c == nil {
+				continue
+			}
+			h.evicted.Add(1)
+			_ = c.Close()
+			if h.OnEvict != nil {
+				h.OnEvict(c)
+			}
+		}
+	}
+	return
+}
+
+// Count of clients subscribed the topic
+func (h *Hub[M]) Count(topic string) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.topics[topic])
+}
+
+// Online check if the user has any client
+func (h *Hub[M]) Online(user string) bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.users[user]) > 0
+}
+
+// Stats of the hub
+func (h *Hub[M]) Stats() Stats {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return Stats{
+		Connections: len(h.clients),
+		Users:       len(h.users),
+		Topics:      len(h.topics),
+		Published:   h.published.Load(),
+		Delivered:   h.delivered.Load(),
+		Dropped:     h.dropped.Load(),
+		Evicted:     h.evicted.Load(),
+	}
+}
+
+// Close all clients and reject new clients
+func (h *Hub[M]) Close() error {
+	h.lock.Lock()
+	if h.closed {
+		h.lock.Unlock()
+		return ErrClosed
+	}
+	h.closed = true
+	clients := make([]Client[M], 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.clients = map[Client[M]]*member{}
+	h.topics = map[string]map[Client[M]]struct{}{}
+	h.users = map[string]map[Client[M]]struct{}{}
+	h.lock.Unlock()
+	for _, c := range clients {
+		_ = c.Close()
+	}
+	return nil
+}
+
+func add[M any](m map[string]map[Client[M]]struct{}, key string, c Client[M]) {
+	s, ok := m[key]
+	if !ok {
+		s = map[Client[M]]struct{}{}
+		m[key] = s
+	}
+	s[c] = struct{}{}
+}
+
+func remove[M any](m map[string]map[Client[M]]struct{}, key string, c Client[M]) {
+	if s, ok := m[key]; ok {
+		delete(s, c)
+		if len(s) == 0 {
+			delete(m, key)
+		}
+	}
+}
+
+func collect[M any](s map[Client[M]]struct{}) []Client[M] {
+	v := make([]Client[M], 0, len(s))
+	for c := range s {
+		v = append(v, c)
+	}
+	return v
+}
@@ -0,0 +1,130 @@
+package hub
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// client queues messages up to cap
+type client struct {
+	lock   sync.Mutex
+	cap    int
+	got    []string
+	closed atomic.Int32
+}
+
+func (c *client) Deliver(m string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed.Load() > 0 || len(c.got) >= c.cap {
+		return false
+	}
+	c.got = append(c.got, m)
+	return true
+}
+
+func (c *client) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+func (c *client) messages() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string(nil), c.got...)
+}
+
+func TestHubRouting(t *testing.T) {
+	h := New[string]()
+	a, b, c := &client{cap: 10}, &client{cap: 10}, &client{cap: 10}
+	_ = h.Join(a, "alice", "news", "sport")
+	_ = h.Join(b, "alice", "news")
+	_ = h.Join(c, "", "sport")
+	if n := h.Publish("news", "n1"); n != 2 {
+		t.Fatalf("news delivered %d", n)
+	}
+	if n := h.SendUser("alice", "u1"); n != 2 {
+		t.Fatalf("user delivered %d", n)
+	}
+	if n := h.Broadcast("all"); n != 3 {
+		t.Fatalf("broadcast delivered %d", n)
+	}
+	if n := h.Publish("none", "x"); n != 0 || h.Count("sport") != 2 || !h.Online("alice") || h.Online("bob") {
+		t.Fatalf("counts %d %d", n, h.Count("sport"))
+	}
+	h.Unsubscribe(a, "sport")
+	h.Subscribe(c, "news")
+	h.Subscribe(&client{}, "news") //not joined
+	if n := h.Publish("sport", "s1"); n != 1 || h.Count("news") != 3 {
+		t.Fatalf("after unsubscribe %d %d", n, h.Count("news"))
+	}
+	if got := a.messages(); len(got) != 3 || got[0] != "n1" || got[1] != "u1" || got[2] != "all" {
+		t.Fatalf("a received %v", got)
+	}
+	h.Leave(a)
+	h.Leave(b)
+	if h.Online("alice") || h.Count("news") != 1 || a.closed.Load() != 0 {
+		t.Fatal("leave should remove without closing")
+	}
+	s := h.Stats()
+	if s.Connections != 1 || s.Users != 0 || s.Topics != 2 || s.Published != 5 || s.Delivered != 8 || s.Dropped != 0 {
+		t.Fatalf("stats %+v", s)
+	}
+	if err := h.Close(); err != nil || c.closed.Load() != 1 {
+		t.Fatalf("close %v %d", err, c.closed.Load())
+	}
+	if !errors.Is(h.Close(), ErrClosed) || !errors.Is(h.Join(a, ""), ErrClosed) || h.Stats().Connections != 0 {
+		t.Fatal("closed hub should reject")
+	}
+}
+
+func TestHubEvict(t *testing.T) {
+	h := New[string]()
+	var evicted []Client[string]
+	h.OnEvict = func(c Client[string]) { evicted = append(evicted, c) }
+	fast, slow := &client{cap: 10}, &client{cap: 1}
+	_ = h.Join(fast, "fast", "t")
+	_ = h.Join(slow, "slow", "t")
+	if n := h.Publish("t", "1"); n != 2 {
+		t.Fatalf("delivered %d", n)
+	}
+	if n := h.Publish("t", "2"); n != 1 {
+		t.Fatalf("delivered %d", n)
+	}
+	if slow.closed.Load() != 1 || h.Online("slow") || h.Count("t") != 1 || len(evicted) != 1 || evicted[0] != slow {
+		t.Fatalf("slow not evicted: %+v", h.Stats())
+	}
+	if s := h.Stats(); s.Dropped != 1 || s.Evicted != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	h = New[string]()
+	h.Evict = false
+	slow = &client{cap: 1}
+	_ = h.Join(slow, "slow", "t")
+	h.Publish("t", "1")
+	h.Publish("t", "2")
+	if slow.closed.Load() != 0 || !h.Online("slow") || h.Stats().Dropped != 1 || h.Stats().Evicted != 0 {
+		t.Fatalf("should only drop without evict: %+v", h.Stats())
+	}
+}
+
+func TestHubConcurrentEvict(t *testing.T) {
+	h := New[string]()
+	slow := &client{cap: 0}
+	_ = h.Join(slow, "u", "t")
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Publish("t", "x")
+		}()
+	}
+	wg.Wait()
+	if slow.closed.Load() != 1 || h.Stats().Evicted != 1 {
+		t.Fatalf("slow client closed %d times, stats %+v", slow.closed.Load(), h.Stats())
+	}
+}
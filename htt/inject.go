@@ -1,17 +1,18 @@
 package htt
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 	"go.opentelemetry.io/otel"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"sort"
 	"time"
 )
 
@@ -33,105 +34,133 @@ func (c RouterConfigurer) WithTelemetry(service string, opts ...otelmux.Option)
 	return c
 }
 
-func parseCORS(cfg conf.Config) (h, o, a string) {
-	if cfg != nil {
-		headers := cfg.GetStringList("cors.headers")
-		var b bytes.Buffer
-		if len(headers) != 0 {
-			for _, header := range headers {
-				if b.Len() > 0 {
-					b.WriteByte(',')
-				}
-				b.WriteString(header)
-			}
-		}
-		if cfg.GetBoolean("cors.authorization", false) {
-			if b.Len() > 0 {
-				b.WriteByte(',')
-			}
-			b.WriteString("Authorization")
-		}
-		if b.Len() == 0 {
-			b.WriteByte('*')
-		}
-		h = b.String()
-		b.Reset()
-		cc := cfg.GetBoolean("cors.credentials", false)
-		if cc {
-			a = "true"
-		}
-		origins := cfg.GetStringList("cors.origin")
-		if len(origins) != 0 {
-			for _, header := range origins {
-				if b.Len() > 0 {
-					b.WriteByte(',')
-				}
-				b.WriteString(header)
-			}
-		}
-		if b.Len() == 0 {
-			b.WriteByte('*')
-		}
-		o = b.String()
-	} else {
-		o = "*"
-		h = "*"
-	}
-	return
-}
+// WithCORS use [CORS] middleware configured from cfg, see [NewCORS]. It panics when config is invalid.
+//
+// Preflight requests of routes without OPTIONS method are also handled, by wrapping the MethodNotAllowedHandler.
 func (c RouterConfigurer) WithCORS(cfg conf.Config) RouterConfigurer {
 	if cfg == nil {
 		return c
 	}
-	c.Use(mux.CORSMethodMiddleware(c.Router))
-	h, o, a := parseCORS(cfg)
-	c.Use(func(next http.Handler) http.Handler {
-		var setter func(w http.ResponseWriter, req *http.Request)
-		if o == "*" {
-			setter = func(w http.ResponseWriter, req *http.Request) {
-				if a != "" {
-					w.Header().Set("Access-Control-Allow-Credentials", a)
-				}
-				var org = req.Header.Get("origin")
-				if org == "" {
-					var u = req.URL
-					org = u.Scheme + u.Host
-				}
-				w.Header().Set("Access-Control-Allow-Headers", h)
-				w.Header().Set("Access-Control-Expose-Header", h)
-				w.Header().Set("Access-Control-Allow-Origin", org)
-			}
-		} else {
-			setter = func(w http.ResponseWriter, req *http.Request) {
-				if a != "" {
-					w.Header().Set("Access-Control-Allow-Credentials", a)
-				}
-				w.Header().Set("Access-Control-Allow-Headers", h)
-				w.Header().Set("Access-Control-Expose-Header", h)
-				w.Header().Set("Access-Control-Allow-Origin", o)
-
-			}
-		}
-		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			setter(w, req)
-			if req.Method == http.MethodOptions {
-				return
-			}
-			next.ServeHTTP(w, req)
+	cors, err := NewCORS(cfg)
+	if err != nil {
+		panic(err)
+	}
+	c.Use(cors.Middleware)
+	fallback := c.MethodNotAllowedHandler
+	if fallback == nil {
+		fallback = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
 		})
-	})
+	}
+	c.MethodNotAllowedHandler = cors.Middleware(fallback)
 	return c
 }
 
-// WithSPA at root path
+// WithJWT verify jwt token of every request with the [Tokenizer] configured by [ConfigJwt].
+// claims creates a new claims for each request, nil for [jwt.MapClaims]. see [ClaimsOf] to fetch claims in handlers.
+func (c RouterConfigurer) WithJWT(claims func() jwt.Claims) RouterConfigurer {
+	if tokenizer == nil {
+		panic(ErrJwtNotConfigured)
+	}
+	c.Use(tokenizer.Middleware(claims))
+	return c
+}
+
+// WithJWKS publish public keys of the [Tokenizer] configured by [ConfigJwt], path default is /.well-known/jwks.json
+func (c RouterConfigurer) WithJWKS(path string) RouterConfigurer {
+	if tokenizer == nil {
+		panic(ErrJwtNotConfigured)
+	}
+	if path == "" {
+		path = "/.well-known/jwks.json"
+	}
+	c.Handle(path, tokenizer).Methods(http.MethodGet).Name("jwks")
+	return c
+}
+
+// WithSPA at root path, the route is named pages, or pages:tpl when pages exists.
 // tpl: the routing prefix template
 // folder: the local directory contains all SPA files
 // index: the index file name
 func (c RouterConfigurer) WithSPA(tpl, folder, index string) RouterConfigurer {
-	c.PathPrefix(tpl).Handler(Spa([2]string{folder, index})).Name("pages")
+	c.PathPrefix(tpl).Handler(Spa([2]string{folder, index})).Name(c.pagesName(tpl))
+	return c
+}
+
+// WithSpaHandler serve the [SpaHandler] at path prefix tpl, such as an [embed.FS] created by [NewSpa].
+func (c RouterConfigurer) WithSpaHandler(tpl string, h *SpaHandler) RouterConfigurer {
+	c.PathPrefix(tpl).Handler(h).Name(c.pagesName(tpl))
+	return c
+}
+
+func (c RouterConfigurer) pagesName(tpl string) string {
+	if c.Router.Get("pages") == nil {
+		return "pages"
+	}
+	return "pages:" + tpl
+}
+
+// WithMount serve a copy of the [SpaHandler] under prefix, which is stripped before looking up files. The route is named mount:name.
+// Mounts are matched in registration order, so register the longer prefixes first. h is not modified, so it can be mounted more than once.
+func (c RouterConfigurer) WithMount(name, prefix string, h *SpaHandler) RouterConfigurer {
+	if name == "" {
+		name = prefix
+	}
+	m := h.clone()
+	m.Prefix = prefix
+	m.Attributes = append(m.Attributes, attribute.String("mount", name), attribute.String("prefix", prefix))
+	c.PathPrefix(prefix).Handler(m).Name("mount:" + name)
 	return c
 }
 
+/*
+WithMounts serve SPAs and static directories of config, longer prefixes are matched first. see [RouterConfigurer.WithMount]
+
+HOCON sample:
+
+	mounts:[
+	 { name: app, prefix: "/", dir: "www", index: "index.html", exclude: ["/api"] } # SPA with index fallback
+	 { name: files, prefix: "/files/", dir: "files", static: true, listing: true, maxAge: 1h } # static directory
+	]
+*/
+func (c RouterConfigurer) WithMounts(cfg conf.Config) RouterConfigurer {
+	if cfg == nil {
+		return c
+	}
+	mounts := cfg.GetObjects("mounts")
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].GetString("prefix", "/")) > len(mounts[j].GetString("prefix", "/"))
+	})
+	for _, m := range mounts {
+		var h *SpaHandler
+		if m.GetBoolean("static", false) {
+			h = NewStatic(os.DirFS(m.GetString("dir", ".")), m.GetBoolean("listing", false))
+		} else {
+			h = NewSpa(os.DirFS(m.GetString("dir", ".")), m.GetString("index"))
+		}
+		h.Exclude = m.GetStringList("exclude")
+		h.MaxAge = m.GetTimeDuration("maxAge", 0)
+		h.Attributes = append(h.Attributes, attribute.String("folder", m.GetString("dir", ".")))
+		c.WithMount(m.GetString("name"), m.GetString("prefix", "/"), h)
+	}
+	return c
+}
+
+func writeJsonError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(units.JsonError{
+		Timestamp: time.Now().Unix(),
+		Code:      code,
+		Message:   message,
+	})
+}
+
+// Server create [Server] of the router, see [NewServer]
+func (c RouterConfigurer) Server(name string, cfg conf.Config) (*Server, error) {
+	return NewServer(name, c.Router, cfg)
+}
+
 // Launch see [StartServer]
 func (c RouterConfigurer) Launch(name string, cfg conf.Config, configure func(server *http.Server), closerConsumer func(func())) {
 	StartServer(name, c.Router, cfg, configure, closerConsumer)
@@ -143,7 +172,10 @@ func (c RouterConfigurer) LaunchContextual(name string, cfg conf.Config, timeout
 }
 
 /*
-StartServer with mux.Router and [conf.Config].This function will block until [http.Server] is shutdown
+StartServer with mux.Router and [conf.Config].This function will block until [http.Server] is shutdown.
+The server is shutdown by SIGINT, SIGTERM or the closer passed to closerConsumer.
+
+Deprecated: use [NewServer] and [Servers.Run], which supports hooks and multiple servers.
 
 HOCON sample:
 
@@ -153,55 +185,47 @@ HOCON sample:
 	readTimeout: 30s
 	idleTimeout: 30s
 	keepAlive: false
+	shutdownTimeout: 30s
+	tls{ cert: "server.crt", key: "server.key" } # see NewTransport for transport options
 	}
 */
 func StartServer(name string, r *mux.Router, c conf.Config, configure func(server *http.Server), closerConsumer func(func())) {
-	if c == nil {
-		c = conf.Empty()
+	s, err := NewServer(name, r, c)
+	if err != nil {
+		conf.Internal().Errorf("http %s server %+v", name, err)
+		return
 	}
-	server := new(http.Server)
-	server.Addr = conf.OrElse("address", "0.0.0.0:8080", c, c.GetString)
-	server.Handler = r
-	server.WriteTimeout = conf.OrElse("writeTimeout", time.Second*30, c, c.GetTimeDuration)
-	server.ReadTimeout = conf.OrElse("readTimeout", time.Second*30, c, c.GetTimeDuration)
-	server.IdleTimeout = conf.OrElse("idleTimeout", time.Second*60, c, c.GetTimeDuration)
-	server.ErrorLog = log.Default()
-	server.SetKeepAlivesEnabled(c.GetBoolean("keepAlive", false))
-	if configure != nil {
-		configure(server)
+	configureServer(s, configure)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if closerConsumer != nil {
+		closerConsumer(cancel)
 	}
-	i := conf.Internal()
-	shutdown := make(chan struct{})
-	ch := make(chan os.Signal, 1)
-	closer := func() {
-		ch <- syscall.SIGINT
+	if err = NewServers(s).Run(ctx); err != nil {
+		conf.Internal().Errorf("shutdown http %s server error %+v", name, err)
 	}
-	closerConsumer(closer)
-	go func() {
-		signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
-		<-ch
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			i.Errorf("http %s server shutting down %+v", name, err)
-		} else {
-			i.Infof("http %s server shutdown success", name)
-			close(shutdown)
-		}
-		signal.Stop(ch)
-	}()
-	i.Infof("http %s server listen %s ", name, server.Addr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		i.Errorf("shutdown http %s server error %+v", name, err)
+}
+
+// configureServer let configure change the [http.Server], the address changed is listened by the tcp transport.
+func configureServer(s *Server, configure func(server *http.Server)) {
+	if configure == nil {
+		return
 	}
-	<-shutdown
-	close(ch)
+	if s.Transport.Network != "tcp" {
+		configure(s.Server)
+		return
+	}
+	s.Server.Addr = s.Transport.Address
+	configure(s.Server)
+	s.Transport.Address = s.Server.Addr
 }
 
 /*
 StartContextServer with mux.Router and [conf.Config].This function will block
-until [http.Server] is shutdown. This function also support a context for close
-server.
+until [http.Server] is shutdown. The closer passed to closerConsumer shutdown the server
+with the context, otherwise timeout is used, the shutdownTimeout of config is used when timeout is not positive.
+
+Deprecated: use [NewServer] and [Servers.Run], which supports hooks and multiple servers.
 
 HOCON sample:
 
@@ -211,67 +235,25 @@ HOCON sample:
 	readTimeout: 30s
 	idleTimeout: 30s
 	keepAlive: false
+	tls{ cert: "server.crt", key: "server.key" } # see NewTransport for transport options
 	}
 */
 func StartContextServer(name string, r *mux.Router, c conf.Config, timeout time.Duration, configure func(server *http.Server), closerConsumer func(func(ctx context.Context))) {
-	if c == nil {
-		c = conf.Empty()
+	s, err := NewServer(name, r, c)
+	if err != nil {
+		conf.Internal().Errorf("http %s server %+v", name, err)
+		return
 	}
-	server := new(http.Server)
-	server.Addr = conf.OrElse("address", "0.0.0.0:8080", c, c.GetString)
-	server.Handler = r
-	server.WriteTimeout = conf.OrElse("writeTimeout", time.Second*30, c, c.GetTimeDuration)
-	server.ReadTimeout = conf.OrElse("readTimeout", time.Second*30, c, c.GetTimeDuration)
-	server.IdleTimeout = conf.OrElse("idleTimeout", time.Second*60, c, c.GetTimeDuration)
-	server.ErrorLog = log.Default()
-	server.SetKeepAlivesEnabled(c.GetBoolean("keepAlive", false))
-	if configure != nil {
-		configure(server)
+	if timeout > 0 {
+		s.ShutdownTimeout = timeout
 	}
-	i := conf.Internal()
-	shutdown := make(chan struct{})
-	ch := make(chan os.Signal, 1)
-	ccx := make(chan context.Context, 1)
-	closer := func(ctx context.Context) {
-		ch <- syscall.SIGINT
-		ccx <- ctx
+	configureServer(s, configure)
+	if closerConsumer != nil {
+		closerConsumer(func(ctx context.Context) {
+			go func() { _ = s.Shutdown(ctx) }()
+		})
 	}
-	closerConsumer(closer)
-	go func() {
-		signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
-		<-ch
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		var cx context.Context
-	loop:
-		for {
-			select {
-			case cx = <-ccx:
-				break loop
-			case <-ctx.Done():
-				break loop
-			}
-		}
-		if cx == nil {
-			var cc context.CancelFunc
-			ctx, cc = context.WithTimeout(context.Background(), timeout)
-			defer cc()
-		} else {
-			ctx = cx
-		}
-		if err := server.Shutdown(ctx); err != nil {
-			i.Errorf("http %s server shutting down %+v", name, err)
-		} else {
-			i.Infof("http %s server shutdown success", name)
-			close(shutdown)
-		}
-		signal.Stop(ch)
-	}()
-	i.Infof("http %s server listen %s ", name, server.Addr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		i.Errorf("shutdown http %s server error %+v", name, err)
+	if err = NewServers(s).Run(context.Background()); err != nil {
+		conf.Internal().Errorf("shutdown http %s server error %+v", name, err)
 	}
-	<-shutdown
-	close(ch)
-	close(ccx)
 }
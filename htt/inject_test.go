@@ -0,0 +1,126 @@
+package htt
+
+import (
+	"context"
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithMount(t *testing.T) {
+	h := NewSpa(spaFS(), "")
+	h.Attributes = make([]attribute.KeyValue, 1, 4) //spare capacity must not be shared by mounts
+	h.Attributes[0] = attribute.String("app", "web")
+	r := mux.NewRouter()
+	RouterConfigurerOf(r).WithMount("admin", "/admin/", h).WithMount("", "/", h)
+	if h.Prefix != "" || len(h.Attributes) != 1 {
+		t.Fatalf("mounted handler modified: prefix %q attributes %v", h.Prefix, h.Attributes)
+	}
+	admin, _ := r.Get("mount:admin").GetHandler().(*SpaHandler)
+	root, _ := r.Get("mount:/").GetHandler().(*SpaHandler)
+	if admin == nil || root == nil || admin == h || root == h || admin.Prefix != "/admin/" || root.Prefix != "/" {
+		t.Fatalf("mounts %+v %+v", admin, root)
+	}
+	if a := admin.Attributes; len(a) != 3 || a[0].Value.AsString() != "web" || a[1].Value.AsString() != "admin" || a[2].Value.AsString() != "/admin/" {
+		t.Fatalf("admin attributes %v", a)
+	}
+	if a := root.Attributes; len(a) != 3 || a[1].Value.AsString() != "/" {
+		t.Fatalf("root attributes %v", a)
+	}
+	for p, body := range map[string]string{"/admin/style.css": "body{}", "/style.css": "body{}", "/admin/docs/index.html": "docs"} {
+		if w := spaGet(r, p); w.Code != http.StatusOK || w.Body.String() != body {
+			t.Fatalf("%s: %d %q", p, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestWithMounts(t *testing.T) {
+	app, files := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{
+		filepath.Join(app, "index.html"): "<html>app</html>",
+		filepath.Join(files, "a.txt"):    "a",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := `mounts:[
+	 { name: app, prefix: "/", dir: "` + filepath.ToSlash(app) + `", exclude: ["/api"] }
+	 { name: files, prefix: "/files/", dir: "` + filepath.ToSlash(files) + `", static: true, listing: true, maxAge: 1h }
+	]`
+	r := mux.NewRouter()
+	RouterConfigurerOf(r).WithMounts(conf.NewConfig(hocon.ParseString(src)))
+	for p, status := range map[string]int{
+		"/files/a.txt": http.StatusOK,
+		"/files/":      http.StatusOK,
+		"/files/b.txt": http.StatusNotFound,
+		"/users/1":     http.StatusOK,
+		"/api/users":   http.StatusNotFound,
+	} {
+		if w := spaGet(r, p); w.Code != status {
+			t.Fatalf("%s status %d, want %d", p, w.Code, status)
+		}
+	}
+	if w := spaGet(r, "/files/a.txt"); w.Header().Get("Cache-Control") != "public, max-age=3600" {
+		t.Fatalf("static max age %v", w.Header())
+	}
+	if w := spaGet(r, "/users/1"); !strings.Contains(w.Body.String(), "app") {
+		t.Fatalf("spa fallback %q", w.Body.String())
+	}
+	if r.Get("mount:app") == nil || r.Get("mount:files") == nil {
+		t.Fatal("mount routes not named")
+	}
+}
+
+func TestWithSpaHandler(t *testing.T) {
+	r := mux.NewRouter()
+	RouterConfigurerOf(r).WithSpaHandler("/app/", NewSpa(spaFS(), "")).WithSpaHandler("/", NewSpa(spaFS(), ""))
+	if r.Get("pages") == nil || r.Get("pages:/") == nil {
+		t.Fatal("pages routes not named")
+	}
+}
+
+func TestStartContextServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	closer := make(chan func(ctx context.Context), 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		StartContextServer("start", mux.NewRouter(), conf.NewConfig(hocon.ParseString(`address: "127.0.0.1:1"`)), 0, func(s *http.Server) {
+			if s.Addr != "127.0.0.1:1" {
+				t.Errorf("configured address %q", s.Addr)
+			}
+			s.Addr = addr
+		}, func(fn func(ctx context.Context)) { closer <- fn })
+	}()
+	shutdown := <-closer
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = c.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("address of configure not listened")
+		}
+	}
+	shutdown(context.Background())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server not shutdown")
+	}
+}
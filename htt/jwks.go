@@ -0,0 +1,301 @@
+package htt
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// Jwk a JSON Web Key of public key, see RFC 7517
+	Jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid,omitempty"`
+		Use string `json:"use,omitempty"`
+		Alg string `json:"alg,omitempty"`
+		N   string `json:"n,omitempty"`
+		E   string `json:"e,omitempty"`
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+	// JwkSet a JSON Web Key Set
+	JwkSet struct {
+		Keys []Jwk `json:"keys"`
+	}
+	// JwksClient fetch and cache remote JWKS to verify tokens.
+	JwksClient struct {
+		URL        string
+		Client     *http.Client
+		TTL        time.Duration //cache time to live
+		MinRefresh time.Duration //minimal interval to refetch when kid not found, also the backoff after a failed fetch
+		MaxBytes   int64         //maximum size of the document, default is [DefaultJwksMaxBytes]
+		lock       sync.RWMutex
+		keys       map[string]*JwtKey
+		fetched    time.Time
+		failed     time.Time //last failed fetch
+		failure    error     //error of last failed fetch
+		flightLock sync.Mutex
+		flight     *jwksFlight
+	}
+	// jwksFlight a refresh in progress, concurrent lookups wait for it instead of fetching again
+	jwksFlight struct {
+		done chan struct{}
+		err  error
+	}
+)
+
+var b64 = base64.RawURLEncoding
+
+// DefaultJwksMaxBytes the default size limit of remote JWKS document
+const DefaultJwksMaxBytes = 1 << 20
+
+// JwkOf convert the public key to [Jwk], HMAC keys are not supported.
+func JwkOf(k *JwtKey) (j Jwk, err error) {
+	j.Kid = k.ID
+	j.Use = "sig"
+	if k.Method != nil {
+		j.Alg = k.Method.Alg()
+	}
+	switch p := k.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = b64.EncodeToString(p.N.Bytes())
+		j.E = b64.EncodeToString(big.NewInt(int64(p.E)).Bytes())
+	case *ecdsa.PublicKey:
+		j.Kty = "EC"
+		j.Crv = p.Curve.Params().Name
+		var e *ecdh.PublicKey
+		if e, err = p.ECDH(); err != nil {
+			return
+		}
+		b := e.Bytes()[1:] //uncompressed point 0x04|X|Y
+		j.X = b64.EncodeToString(b[:len(b)/2])
+		j.Y = b64.EncodeToString(b[len(b)/2:])
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = b64.EncodeToString(p)
+	default:
+		err = fmt.Errorf("unsupported jwk key type %T", k.VerifyKey)
+	}
+	return
+}
+
+// Key convert [Jwk] to [JwtKey] for verification
+func (j Jwk) Key() (k *JwtKey, err error) {
+	k = &JwtKey{ID: j.Kid}
+	if j.Alg != "" {
+		if k.Method = jwt.GetSigningMethod(j.Alg); k.Method == nil {
+			return nil, fmt.Errorf("unknown jwk alg %s", j.Alg)
+		}
+	}
+	switch j.Kty {
+	case "RSA":
+		var n, e []byte
+		if n, err = b64.DecodeString(j.N); err != nil {
+			return
+		}
+		if e, err = b64.DecodeString(j.E); err != nil {
+			return
+		}
+		ev := new(big.Int).SetBytes(e)
+		if !ev.IsInt64() || ev.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid jwk rsa exponent")
+		}
+		k.VerifyKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(ev.Int64())}
+	case "EC":
+		var c elliptic.Curve
+		var d ecdh.Curve
+		switch j.Crv {
+		case "P-256":
+			c, d = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			c, d = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			c, d = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported jwk curve %s", j.Crv)
+		}
+		var x, y []byte
+		if x, err = b64.DecodeString(j.X); err != nil {
+			return
+		}
+		if y, err = b64.DecodeString(j.Y); err != nil {
+			return
+		}
+		size := (c.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid jwk ec point")
+		}
+		if _, err = d.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		k.VerifyKey = &ecdsa.PublicKey{Curve: c, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported jwk curve %s", j.Crv)
+		}
+		var x []byte
+		if x, err = b64.DecodeString(j.X); err != nil {
+			return
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid jwk ed25519 key")
+		}
+		k.VerifyKey = ed25519.PublicKey(x)
+	default:
+		return nil, fmt.Errorf("unsupported jwk kty %s", j.Kty)
+	}
+	return
+}
+
+// JWKS the public keys of all asymmetric keys which not expired, including keys not yet activated.
+func (t *Tokenizer) JWKS() (s JwkSet) {
+	s.Keys = []Jwk{}
+	now := time.Now()
+	for _, k := range t.Keys() {
+		if !k.Expire.IsZero() && !k.Expire.After(now) {
+			continue
+		}
+		if j, err := JwkOf(k); err == nil {
+			s.Keys = append(s.Keys, j)
+		}
+	}
+	return
+}
+
+// ServeHTTP serve the JWKS document of [Tokenizer]
+func (t *Tokenizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(t.JWKS())
+}
+
+// NewJwksClient create [JwksClient] with cache ttl
+func NewJwksClient(url string, ttl time.Duration) *JwksClient {
+	return &JwksClient{URL: url, TTL: ttl, MinRefresh: 10 * time.Second}
+}
+
+// Refresh fetch the remote JWKS document, a failure delays refetching of [JwksClient.Lookup] by MinRefresh.
+func (c *JwksClient) Refresh(ctx context.Context) (err error) {
+	defer func() {
+		if err != nil {
+			c.lock.Lock()
+			c.failed, c.failure = time.Now(), err
+			c.lock.Unlock()
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	cli := c.Client
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+	res, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks %s: %s", c.URL, res.Status)
+	}
+	limit := c.MaxBytes
+	if limit <= 0 {
+		limit = DefaultJwksMaxBytes
+	}
+	var s JwkSet
+	if err = json.NewDecoder(io.LimitReader(res.Body, limit)).Decode(&s); err != nil {
+		return fmt.Errorf("decode jwks %s: %w", c.URL, err)
+	}
+	keys := make(map[string]*JwtKey, len(s.Keys))
+	for _, j := range s.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		if k, err := j.Key(); err == nil {
+			keys[k.ID] = k
+		}
+	}
+	c.lock.Lock()
+	c.keys = keys
+	c.fetched = time.Now()
+	c.failed, c.failure = time.Time{}, nil
+	c.lock.Unlock()
+	return nil
+}
+
+// get the key of kid, fetch reports whether the document should be refetched, failure is the error of the last fetch in backoff.
+func (c *JwksClient) get(kid string) (k *JwtKey, fetch bool, failure error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if kid == "" && len(c.keys) == 1 {
+		for _, x := range c.keys {
+			k = x
+		}
+	} else {
+		k = c.keys[kid]
+	}
+	if !c.failed.IsZero() && time.Since(c.failed) <= c.MinRefresh {
+		return k, false, c.failure
+	}
+	age := time.Since(c.fetched)
+	fetch = c.keys == nil || age > c.TTL || (k == nil && age > c.MinRefresh)
+	return
+}
+
+// Lookup key by kid, the document is refetched when cache expired or kid not found.
+// After a failed fetch, the cached keys are used without refetching until MinRefresh passed.
+func (c *JwksClient) Lookup(kid string) (k *JwtKey, err error) {
+	k, fetch, failure := c.get(kid)
+	if fetch {
+		if err = c.refresh(); err != nil && k == nil {
+			return nil, errors.Join(ErrJwtKeyNotFound, err)
+		}
+		k, _, _ = c.get(kid)
+	}
+	if k == nil {
+		if failure != nil {
+			return nil, errors.Join(ErrJwtKeyNotFound, failure)
+		}
+		return nil, ErrJwtKeyNotFound
+	}
+	return k, nil
+}
+
+// refresh the document once for concurrent callers
+func (c *JwksClient) refresh() error {
+	c.flightLock.Lock()
+	if f := c.flight; f != nil {
+		c.flightLock.Unlock()
+		<-f.done
+		return f.err
+	}
+	f := &jwksFlight{done: make(chan struct{})}
+	c.flight = f
+	c.flightLock.Unlock()
+	ctx, cc := context.WithTimeout(context.Background(), 10*time.Second)
+	f.err = c.Refresh(ctx)
+	cc()
+	c.flightLock.Lock()
+	c.flight = nil
+	c.flightLock.Unlock()
+	close(f.done)
+	return f.err
+}
@@ -0,0 +1,578 @@
+package htt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/andybalholm/brotli"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//region ResponseWriter
+
+// ResponseWriter records status and written bytes of a [http.ResponseWriter].
+// It keeps [http.Flusher], [http.Hijacker] and [http.ResponseController] working on the wrapped writer.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WrapResponseWriter wrap w, returns w itself if already wrapped.
+func WrapResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	if x, ok := w.(*ResponseWriter); ok {
+		return x
+	}
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+// Status the written status, zero if not written
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Bytes the written body size
+func (w *ResponseWriter) Bytes() int64 {
+	return w.bytes
+}
+
+// Written check if header is written
+func (w *ResponseWriter) Written() bool {
+	return w.status != 0
+}
+func (w *ResponseWriter) WriteHeader(code int) {
+	if w.status == 0 || code < 200 {
+		if code >= 200 {
+			w.status = code
+		}
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+func (w *ResponseWriter) Write(p []byte) (n int, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err = w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return
+}
+func (w *ResponseWriter) Flush() {
+	_ = w.FlushError()
+}
+func (w *ResponseWriter) FlushError() error {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+//endregion
+
+//region RequestID
+
+type requestIDKey struct{}
+
+// RequestIDOf fetch request id from context, which set by [RouterConfigurer.WithRequestID]
+func RequestIDOf(ctx context.Context) string {
+	v, _ := ctx.Value(requestIDKey{}).(string)
+	return v
+}
+
+func validRequestID(v string) bool {
+	if len(v) == 0 || len(v) > 128 {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < 0x21 || v[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID generate a random request id
+func NewRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID propagate the request id from header or generate a new one, the id is stored in context and response header.
+func RequestID(header string) func(http.Handler) http.Handler {
+	if header == "" {
+		header = "X-Request-ID"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if !validRequestID(id) {
+				id = NewRequestID()
+				r.Header.Set(header, id)
+			}
+			w.Header().Set(header, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+//endregion
+
+//region AccessLog
+
+// AccessLog log each request in form of key=value after handled, requests slower than slow are logged as warning.
+func AccessLog(logger conf.ILogger, slow time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+			rw := WrapResponseWriter(w)
+			defer func() {
+				l := logger
+				if l == nil {
+					l = conf.Internal()
+				}
+				latency := time.Since(begin)
+				status := rw.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				format := "access method=%s path=%q status=%d bytes=%d latency=%s remote=%s rid=%s"
+				args := []any{r.Method, r.URL.RequestURI(), status, rw.Bytes(), latency, r.RemoteAddr, RequestIDOf(r.Context())}
+				switch {
+				case status >= 500:
+					l.ErrorContextf(r.Context(), format, args...)
+				case slow > 0 && latency > slow:
+					l.WarnContextf(r.Context(), format, args...)
+				default:
+					l.InfoContextf(r.Context(), format, args...)
+				}
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+//endregion
+
+//region Recovery
+
+// Recovery recover panics of handlers as json error, see [units.JsonSafeHandleFunc].
+// The stack of the panic is logged with the error. [http.ErrAbortHandler] is re-panicked to abort the response.
+func Recovery(logger conf.ILogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := logger
+			if l == nil {
+				l = conf.Internal()
+			}
+			abort := false
+			var stack []byte
+			units.JsonSafeHandleFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer func() {
+					if e := recover(); e != nil {
+						if err, ok := e.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+							abort = true
+							return
+						}
+						//! the stack is still the one of panicking handler, it's lost once re-panicked
+						stack = debug.Stack()
+						panic(e)
+					}
+				}()
+				next.ServeHTTP(w, r)
+			}, func(format string, args ...any) {
+				args = append(append([]any{RequestIDOf(r.Context())}, args...), stack)
+				l.ErrorContextf(r.Context(), "recovered rid=%s "+format+"\n%s", args...)
+			})(w, r)
+			if abort {
+				panic(http.ErrAbortHandler)
+			}
+		})
+	}
+}
+
+//endregion
+
+//region BodyLimit
+
+// BodyLimit reject requests with body larger than n bytes with 413
+func BodyLimit(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				writeJsonError(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//endregion
+
+//region Timeout
+
+// Timeout set a deadline on request context. Handlers should observe the context,
+// when the deadline exceeded and the handler returns without writing response, a 503 json error is written.
+// Streaming requests (SSE and websocket upgrade) are not limited.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if d <= 0 || strings.Contains(r.Header.Get("Accept"), "text/event-stream") || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cc := context.WithTimeout(r.Context(), d)
+			defer cc()
+			rw := WrapResponseWriter(w)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+			if !rw.Written() && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				writeJsonError(rw, http.StatusServiceUnavailable, "request timeout")
+			}
+		})
+	}
+}
+
+//endregion
+
+//region Compression
+
+var (
+	// CompressibleTypes content types to compress
+	CompressibleTypes = []string{
+		"text/html", "text/css", "text/plain", "text/javascript", "text/xml", "text/csv",
+		"application/json", "application/javascript", "application/xml", "application/problem+json",
+		"application/wasm", "image/svg+xml",
+	}
+	gzipPools sync.Map
+)
+
+type encoderFactory struct {
+	name string
+	new  func(w io.Writer) io.WriteCloser
+	put  func(io.WriteCloser)
+}
+
+func gzipFactory(level int) encoderFactory {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	p, _ := gzipPools.LoadOrStore(level, &sync.Pool{})
+	pool := p.(*sync.Pool)
+	return encoderFactory{
+		name: "gzip",
+		new: func(w io.Writer) io.WriteCloser {
+			if g, ok := pool.Get().(*gzip.Writer); ok {
+				g.Reset(w)
+				return g
+			}
+			g, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				g = gzip.NewWriter(w)
+			}
+			return g
+		},
+		put: func(c io.WriteCloser) {
+			pool.Put(c)
+		},
+	}
+}
+func brotliFactory(level int) encoderFactory {
+	if level == 0 {
+		level = brotli.DefaultCompression
+	}
+	return encoderFactory{
+		name: "br",
+		new: func(w io.Writer) io.WriteCloser {
+			return brotli.NewWriterLevel(w, level)
+		},
+		put: func(io.WriteCloser) {},
+	}
+}
+
+// accepts check if the encoding is acceptable by Accept-Encoding with non-zero quality
+func accepts(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		params = strings.ReplaceAll(params, " ", "")
+		if q, ok := strings.CutPrefix(params, "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type compressWriter struct {
+	*ResponseWriter
+	factory encoderFactory
+	minSize int
+	types   []string
+	status  int
+	decided bool
+	enc     io.WriteCloser
+	buf     []byte
+}
+
+func (c *compressWriter) compressible() bool {
+	h := c.Header()
+	if h.Get("Content-Encoding") != "" || c.status == http.StatusNoContent || c.status == http.StatusNotModified || c.status == http.StatusPartialContent {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(c.buf)
+		h.Set("Content-Type", ct)
+	}
+	ct, _, _ = strings.Cut(ct, ";")
+	ct = strings.TrimSpace(strings.ToLower(ct))
+	for _, t := range c.types {
+		if t == ct {
+			return true
+		}
+	}
+	return false
+}
+func (c *compressWriter) decide(compress bool) (err error) {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if compress && c.compressible() {
+		h := c.Header()
+		h.Set("Content-Encoding", c.factory.name)
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		c.ResponseWriter.WriteHeader(c.status)
+		c.enc = c.factory.new(c.ResponseWriter)
+		if len(c.buf) > 0 {
+			_, err = c.enc.Write(c.buf)
+		}
+	} else {
+		c.ResponseWriter.WriteHeader(c.status)
+		if len(c.buf) > 0 {
+			_, err = c.ResponseWriter.Write(c.buf)
+		}
+	}
+	c.buf = nil
+	return
+}
+func (c *compressWriter) WriteHeader(code int) {
+	if code < 200 {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if c.status == 0 {
+		c.status = code
+	}
+}
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.decided {
+		if c.enc != nil {
+			return c.enc.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize {
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+func (c *compressWriter) Flush() {
+	_ = c.FlushError()
+}
+func (c *compressWriter) FlushError() error {
+	if !c.decided {
+		if err := c.decide(true); err != nil {
+			return err
+		}
+	}
+	if f, ok := c.enc.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return c.ResponseWriter.FlushError()
+}
+func (c *compressWriter) close() {
+	if !c.decided {
+		_ = c.decide(false)
+	}
+	if c.enc != nil {
+		_ = c.enc.Close()
+		c.factory.put(c.enc)
+		c.enc = nil
+	}
+}
+
+// Compress compress responses with the encoding when accepted by client.
+// When multiple compression middlewares are used, the inner one takes precedence.
+func compress(factory encoderFactory, minSize int, types []string) func(http.Handler) http.Handler {
+	if len(types) == 0 {
+		types = CompressibleTypes
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !accepts(r.Header.Get("Accept-Encoding"), factory.name) || r.Method == http.MethodHead || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c := &compressWriter{ResponseWriter: &ResponseWriter{ResponseWriter: w}, factory: factory, minSize: minSize, types: types}
+			defer c.close()
+			next.ServeHTTP(c, r)
+		})
+	}
+}
+
+// Gzip compress responses with gzip, level 0 for default level.
+func Gzip(level, minSize int, types ...string) func(http.Handler) http.Handler {
+	return compress(gzipFactory(level), minSize, types)
+}
+
+// Brotli compress responses with brotli, level 0 for default level.
+func Brotli(level, minSize int, types ...string) func(http.Handler) http.Handler {
+	return compress(brotliFactory(level), minSize, types)
+}
+
+//endregion
+
+//region Configurer
+
+func middlewareConfig(cfg conf.Config, name string) conf.Config {
+	if cfg == nil || !cfg.IsObject("middlewares."+name) {
+		return conf.Empty()
+	}
+	return cfg.GetObject("middlewares." + name)
+}
+
+// WithRequestID see [RequestID], config path: middlewares.requestId
+func (c RouterConfigurer) WithRequestID(cfg conf.Config) RouterConfigurer {
+	m := middlewareConfig(cfg, "requestId")
+	c.Use(RequestID(m.GetString("header", "X-Request-ID")))
+	return c
+}
+
+// WithAccessLog see [AccessLog], config path: middlewares.accessLog. logger is optional, default is [conf.Internal].
+func (c RouterConfigurer) WithAccessLog(cfg conf.Config, logger conf.ILogger) RouterConfigurer {
+	m := middlewareConfig(cfg, "accessLog")
+	c.Use(AccessLog(logger, m.GetTimeDuration("slow", 0)))
+	return c
+}
+
+// WithRecovery see [Recovery]. logger is optional, default is [conf.Internal].
+func (c RouterConfigurer) WithRecovery(logger conf.ILogger) RouterConfigurer {
+	c.Use(Recovery(logger))
+	return c
+}
+
+// WithGzip see [Gzip], config path: middlewares.gzip
+func (c RouterConfigurer) WithGzip(cfg conf.Config) RouterConfigurer {
+	m := middlewareConfig(cfg, "gzip")
+	c.Use(Gzip(int(m.GetInt32("level", 0)), int(m.GetByteSizeOr("minSize", big.NewInt(1024)).Int64()), m.GetStringList("types")...))
+	return c
+}
+
+// WithBrotli see [Brotli], config path: middlewares.brotli
+func (c RouterConfigurer) WithBrotli(cfg conf.Config) RouterConfigurer {
+	m := middlewareConfig(cfg, "brotli")
+	c.Use(Brotli(int(m.GetInt32("level", 0)), int(m.GetByteSizeOr("minSize", big.NewInt(1024)).Int64()), m.GetStringList("types")...))
+	return c
+}
+
+// WithBodyLimit see [BodyLimit], config path: middlewares.bodyLimit, default 4m
+func (c RouterConfigurer) WithBodyLimit(cfg conf.Config) RouterConfigurer {
+	n := int64(4 << 20)
+	if cfg != nil && cfg.HasPath("middlewares.bodyLimit") {
+		n = cfg.GetByteSize("middlewares.bodyLimit").Int64()
+	}
+	c.Use(BodyLimit(n))
+	return c
+}
+
+// WithTimeout see [Timeout], config path: middlewares.timeout, default 30s
+func (c RouterConfigurer) WithTimeout(cfg conf.Config) RouterConfigurer {
+	d := 30 * time.Second
+	if cfg != nil {
+		d = cfg.GetTimeDuration("middlewares.timeout", d)
+	}
+	c.Use(Timeout(d))
+	return c
+}
+
+/*
+WithMiddlewares use middlewares present in config, in order of:
+requestId, accessLog, recovery, timeout, bodyLimit, gzip, brotli.
+
+HOCON sample:
+
+	middlewares{
+	 requestId{ header: X-Request-ID }
+	 accessLog{ slow: 1s }
+	 recovery{}
+	 timeout: 30s
+	 bodyLimit: 4m
+	 gzip{ level: 5, minSize: 1k, types: [application/json] }
+	 brotli{ level: 4, minSize: 1k }
+	}
+*/
+func (c RouterConfigurer) WithMiddlewares(cfg conf.Config, logger conf.ILogger) RouterConfigurer {
+	if cfg == nil || !cfg.HasPath("middlewares") {
+		return c
+	}
+	if cfg.HasPath("middlewares.requestId") {
+		c.WithRequestID(cfg)
+	}
+	if cfg.HasPath("middlewares.accessLog") {
+		c.WithAccessLog(cfg, logger)
+	}
+	if cfg.HasPath("middlewares.recovery") {
+		c.WithRecovery(logger)
+	}
+	if cfg.HasPath("middlewares.timeout") {
+		c.WithTimeout(cfg)
+	}
+	if cfg.HasPath("middlewares.bodyLimit") {
+		c.WithBodyLimit(cfg)
+	}
+	if cfg.HasPath("middlewares.gzip") {
+		c.WithGzip(cfg)
+	}
+	if cfg.HasPath("middlewares.brotli") {
+		c.WithBrotli(cfg)
+	}
+	return c
+}
+
+//endregion
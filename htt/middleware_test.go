@@ -0,0 +1,253 @@
+package htt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordLogger a [conf.ILogger] keeps formatted lines by level
+type recordLogger struct {
+	lock  sync.Mutex
+	lines map[string][]string
+}
+
+func (l *recordLogger) log(level string, s string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.lines == nil {
+		l.lines = map[string][]string{}
+	}
+	l.lines[level] = append(l.lines[level], s)
+}
+func (l *recordLogger) get(level string) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), l.lines[level]...)
+}
+func (l *recordLogger) Info(v ...any)                 { l.log("info", fmt.Sprint(v...)) }
+func (l *recordLogger) Infof(format string, v ...any) { l.log("info", fmt.Sprintf(format, v...)) }
+func (l *recordLogger) Warn(v ...any)                 { l.log("warn", fmt.Sprint(v...)) }
+func (l *recordLogger) Warnf(format string, v ...any) { l.log("warn", fmt.Sprintf(format, v...)) }
+func (l *recordLogger) Error(v ...any)                { l.log("error", fmt.Sprint(v...)) }
+func (l *recordLogger) Errorf(format string, v ...any) {
+	l.log("error", fmt.Sprintf(format, v...))
+}
+func (l *recordLogger) InfoContext(ctx context.Context, v ...any) { l.Info(v...) }
+func (l *recordLogger) InfoContextf(ctx context.Context, format string, v ...any) {
+	l.Infof(format, v...)
+}
+func (l *recordLogger) WarnContext(ctx context.Context, v ...any) { l.Warn(v...) }
+func (l *recordLogger) WarnContextf(ctx context.Context, format string, v ...any) {
+	l.Warnf(format, v...)
+}
+func (l *recordLogger) ErrorContext(ctx context.Context, v ...any) { l.Error(v...) }
+func (l *recordLogger) ErrorContextf(ctx context.Context, format string, v ...any) {
+	l.Errorf(format, v...)
+}
+
+func TestRequestID(t *testing.T) {
+	var got string
+	h := RequestID("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestIDOf(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got != "abc-123" || w.Header().Get("X-Request-ID") != "abc-123" {
+		t.Fatalf("propagate: %s %v", got, w.Header())
+	}
+	for _, v := range []string{"", "has space", strings.Repeat("x", 129)} {
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Request-ID", v)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got == v || len(got) != 32 || w.Header().Get("X-Request-ID") != got {
+			t.Fatalf("%q: generated %q", v, got)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	l := new(recordLogger)
+	h := RequestID("")(AccessLog(l, 20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/slow":
+			time.Sleep(30 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("hello"))
+	})))
+	for _, p := range []string{"/ok?q=1", "/fail", "/slow"} {
+		r := httptest.NewRequest(http.MethodGet, p, nil)
+		r.Header.Set("X-Request-ID", "rid"+p[1:3])
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	for level, want := range map[string]string{
+		"info":  `access method=GET path="/ok?q=1" status=200 bytes=5`,
+		"error": `path="/fail" status=502`,
+		"warn":  `path="/slow" status=200`,
+	} {
+		if v := l.get(level); len(v) != 1 || !strings.Contains(v[0], want) || !strings.Contains(v[0], "rid=rid") {
+			t.Fatalf("%s: %v", level, v)
+		}
+	}
+}
+
+func panicking(v any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(v)
+	})
+}
+
+func TestRecovery(t *testing.T) {
+	l := new(recordLogger)
+	for name, c := range map[string]struct {
+		v    any
+		code int
+		msg  string
+	}{
+		"value":    {"boom", http.StatusInternalServerError, ""},
+		"error":    {errors.New("boom"), http.StatusInternalServerError, ""},
+		"response": {units.ResponseError{Code: http.StatusConflict, Message: "conflict"}, http.StatusConflict, "conflict"},
+		"invalid":  {units.ResponseError{Code: 42, Message: "odd"}, http.StatusInternalServerError, "odd"},
+	} {
+		w := httptest.NewRecorder()
+		RequestID("")(Recovery(l)(panicking(c.v))).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/p", nil))
+		if w.Code != c.code || !strings.Contains(w.Body.String(), fmt.Sprintf(`"code":%d`, c.code)) ||
+			(c.msg != "" && !strings.Contains(w.Body.String(), c.msg)) {
+			t.Fatalf("%s: %d %s", name, w.Code, w.Body)
+		}
+	}
+	logs := l.get("error")
+	if len(logs) != 4 {
+		t.Fatalf("logged %d", len(logs))
+	}
+	for _, v := range logs {
+		if !strings.Contains(v, "recovered rid=") || !strings.Contains(v, "handle /p") || !strings.Contains(v, "htt.panicking.func1") {
+			t.Fatalf("missing origin stack: %s", v)
+		}
+	}
+	defer func() {
+		if e := recover(); e != http.ErrAbortHandler {
+			t.Fatalf("expect abort re-panicked, got %v", e)
+		}
+		if len(l.get("error")) != 4 {
+			t.Fatal("abort should not be logged")
+		}
+	}()
+	Recovery(l)(panicking(http.ErrAbortHandler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestBodyLimit(t *testing.T) {
+	h := BodyLimit(4)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+	for body, code := range map[string]int{"1234": http.StatusOK, "12345": http.StatusRequestEntityTooLarge} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		if w.Code != code {
+			t.Fatalf("%s: %d", body, w.Code)
+		}
+		//! unknown length is limited while reading
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader(body)))
+		r.ContentLength = -1
+		h.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("%s chunked: %d", body, w.Code)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fast" {
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		<-r.Context().Done()
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("slow: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("fast: %d", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	ctx, cc := context.WithCancel(r.Context())
+	time.AfterFunc(30*time.Millisecond, cc)
+	begin := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+	if time.Since(begin) < 30*time.Millisecond {
+		t.Fatal("stream request should not be limited")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"hello":"world"}`, 100)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{}`))
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(body))
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte(body))
+		}
+	})
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for name, h := range map[string]http.Handler{"gzip": Gzip(0, 256)(handler), "br": Brotli(0, 256)(handler)} {
+		serve := func(path, accept string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.Header.Set("Accept-Encoding", accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+		w := serve("/", "deflate, "+name+";q=0.5")
+		if w.Header().Get("Content-Encoding") != name || w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("ETag") != `W/"v1"` {
+			t.Fatalf("%s: %v", name, w.Header())
+		}
+		dec, err := decoders[name](w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := io.ReadAll(dec); err != nil || string(b) != body {
+			t.Fatalf("%s: decode %v", name, err)
+		}
+		for path, accept := range map[string]string{"/small": name, "/png": name, "/": name + ";q=0", "/ ": "identity"} {
+			w = serve(strings.TrimSpace(path), accept)
+			if w.Header().Get("Content-Encoding") != "" || w.Body.Len() == 0 || !bytes.HasPrefix(w.Body.Bytes(), []byte("{")) {
+				t.Fatalf("%s %s %s: should not compress %v", name, path, accept, w.Header())
+			}
+		}
+	}
+}
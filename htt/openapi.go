@@ -0,0 +1,754 @@
+package htt
+
+import (
+	"encoding"
+	"encoding/json"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+	"html/template"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// OpenAPI generates OpenAPI 3.1 document from routes of the router.
+	//
+	// Every route with methods is documented, annotate it by [Describe] to add types and descriptions.
+	OpenAPI struct {
+		Router          *mux.Router
+		Title           string
+		Version         string
+		Description     string
+		Servers         []string
+		SecuritySchemes map[string]any //default has bearer of JWT
+		Path            string         //path of document without extension, default /openapi
+	}
+	// Operation annotation of a route, see [Describe]
+	Operation struct {
+		id          string
+		summary     string
+		description string
+		tags        []string
+		deprecated  bool
+		hidden      bool
+		security    []string
+		request     reflect.Type
+		responses   map[int]opResponse
+		params      []opParam
+	}
+	opResponse struct {
+		typ         reflect.Type
+		description string
+		problem     bool
+	}
+	opParam struct {
+		In          string         `json:"in"`
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Required    bool           `json:"required,omitempty"`
+		Schema      map[string]any `json:"schema"`
+		typ         reflect.Type
+	}
+	schemaGen struct {
+		defs  map[string]any
+		names map[reflect.Type]string
+		used  map[string]reflect.Type
+	}
+)
+
+var (
+	operations    sync.Map //*mux.Route => *Operation
+	pathVarExpr   = regexp.MustCompile(`\{([^{}:]+)(?::((?:[^{}]|\{[^{}]*})*))?}`)
+	problemType   = reflect.TypeOf(Problem{})
+	rawJsonType   = reflect.TypeOf(json.RawMessage{})
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	schemas       sync.Map //reflect.Type => map[string]any
+)
+
+// RegisterSchema override the schema of the type of v, which is used for types of custom JSON encoding.
+//
+// Without override, types implement [encoding.TextMarshaler] are strings, types implement [json.Marshaler] are any values.
+func RegisterSchema(v any, schema map[string]any) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	schemas.Store(t, schema)
+}
+
+// copySchema deep copy the schema, which may be modified by rules of fields
+func copySchema(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, x := range v {
+			m[k] = copySchema(x)
+		}
+		return m
+	case []any:
+		a := make([]any, len(v))
+		for i, x := range v {
+			a[i] = copySchema(x)
+		}
+		return a
+	default:
+		return v
+	}
+}
+
+// implements check if t or *t implements the interface
+func implements(t, i reflect.Type) bool {
+	return t.Implements(i) || reflect.PointerTo(t).Implements(i)
+}
+
+// Describe annotate the route for [OpenAPI], repeat calls return the same [Operation].
+func Describe(r *mux.Route) *Operation {
+	v, _ := operations.LoadOrStore(r, &Operation{responses: map[int]opResponse{}})
+	return v.(*Operation)
+}
+
+// Endpoint register the handler with method and returns the [Operation] to annotate.
+func (c RouterConfigurer) Endpoint(method, path string, h http.HandlerFunc) *Operation {
+	return Describe(c.HandleFunc(path, h).Methods(method))
+}
+
+// ID set operationId, default is the route name
+func (o *Operation) ID(id string) *Operation {
+	o.id = id
+	return o
+}
+
+// Summary set summary and optional description
+func (o *Operation) Summary(summary string, description ...string) *Operation {
+	o.summary = summary
+	o.description = strings.Join(description, "\n")
+	return o
+}
+
+// Tags add tags
+func (o *Operation) Tags(tags ...string) *Operation {
+	o.tags = append(o.tags, tags...)
+	return o
+}
+
+// Deprecated mark the operation deprecated
+func (o *Operation) Deprecated() *Operation {
+	o.deprecated = true
+	return o
+}
+
+// Hidden exclude the route from document
+func (o *Operation) Hidden() *Operation {
+	o.hidden = true
+	return o
+}
+
+// Secure require the security schemes, default is bearer
+func (o *Operation) Secure(schemes ...string) *Operation {
+	if len(schemes) == 0 {
+		schemes = []string{"bearer"}
+	}
+	o.security = append(o.security, schemes...)
+	return o
+}
+
+// Request set the request type of v, which follows [Bind]: fields tagged with query and path are parameters, the rest is the body.
+func (o *Operation) Request(v any) *Operation {
+	o.request = reflect.TypeOf(v)
+	return o
+}
+
+// Response set the response of status, v is the json body or nil for no content.
+func (o *Operation) Response(status int, v any, description ...string) *Operation {
+	d := strings.Join(description, "\n")
+	if d == "" {
+		d = http.StatusText(status)
+	}
+	o.responses[status] = opResponse{typ: reflect.TypeOf(v), description: d}
+	return o
+}
+
+// Errors add problem responses of errors, the statuses are resolved by [ProblemOf].
+func (o *Operation) Errors(errs ...error) *Operation {
+	for _, err := range errs {
+		p := ProblemOf(err)
+		d := p.Title
+		if r, ok := o.responses[p.Status]; ok && r.problem && !strings.Contains(r.description, d) {
+			d = r.description + ", " + d
+		}
+		o.responses[p.Status] = opResponse{typ: problemType, description: d, problem: true}
+	}
+	return o
+}
+
+// Param add a parameter, in is one of path, query, header and cookie. v is a sample value of the type.
+func (o *Operation) Param(in, name string, v any, description string) *Operation {
+	o.params = append(o.params, opParam{In: in, Name: name, Description: description, Required: in == "path", typ: reflect.TypeOf(v)})
+	return o
+}
+
+/*
+NewOpenAPI create [OpenAPI] of the router from config, cfg is optional.
+
+HOCON sample:
+
+	openapi{
+	 path: /openapi # serves /openapi.json and /openapi.yaml
+	 title: "api"
+	 version: "1.0.0"
+	 description: ""
+	 servers: ["https://api.example.com"]
+	 ui{ path: /docs, kind: swagger, assets: "https://unpkg.com/swagger-ui-dist@5" } # kind is swagger or redoc
+	}
+*/
+func NewOpenAPI(r *mux.Router, cfg conf.Config) *OpenAPI {
+	a := &OpenAPI{
+		Router:  r,
+		Title:   "API",
+		Version: "1.0.0",
+		Path:    "/openapi",
+		SecuritySchemes: map[string]any{
+			"bearer": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		},
+	}
+	if cfg == nil || !cfg.IsObject("openapi") {
+		return a
+	}
+	c := cfg.GetObject("openapi")
+	a.Path = strings.TrimSuffix(c.GetString("path", a.Path), ".json")
+	a.Title = c.GetString("title", a.Title)
+	a.Version = c.GetString("version", a.Version)
+	a.Description = c.GetString("description")
+	a.Servers = c.GetStringList("servers")
+	return a
+}
+
+// Document generate the document
+func (a *OpenAPI) Document() map[string]any {
+	g := &schemaGen{defs: map[string]any{}, names: map[reflect.Type]string{}, used: map[string]reflect.Type{}}
+	paths := map[string]map[string]any{}
+	_ = a.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil || len(methods) == 0 {
+			return nil
+		}
+		var op *Operation
+		if v, ok := operations.Load(route); ok {
+			op = v.(*Operation)
+		} else {
+			op = &Operation{}
+		}
+		if op.hidden {
+			return nil
+		}
+		p, patterns := openapiPath(tpl)
+		item := paths[p]
+		if item == nil {
+			item = map[string]any{}
+			paths[p] = item
+		}
+		for _, m := range methods {
+			m = strings.ToLower(m)
+			if m == "options" && len(methods) > 1 {
+				continue
+			}
+			item[m] = g.operation(op, route.GetName(), m, patterns)
+		}
+		return nil
+	})
+	info := map[string]any{"title": a.Title, "version": a.Version}
+	if a.Description != "" {
+		info["description"] = a.Description
+	}
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info":    info,
+		"paths":   paths,
+	}
+	if len(a.Servers) > 0 {
+		servers := make([]map[string]string, len(a.Servers))
+		for i, s := range a.Servers {
+			servers[i] = map[string]string{"url": s}
+		}
+		doc["servers"] = servers
+	}
+	components := map[string]any{}
+	if len(g.defs) > 0 {
+		components["schemas"] = g.defs
+	}
+	if len(a.SecuritySchemes) > 0 {
+		components["securitySchemes"] = a.SecuritySchemes
+	}
+	if len(components) > 0 {
+		doc["components"] = components
+	}
+	return doc
+}
+
+// JSON document
+func (a *OpenAPI) JSON() ([]byte, error) {
+	return json.MarshalIndent(a.Document(), "", "  ")
+}
+
+// YAML document
+func (a *OpenAPI) YAML() ([]byte, error) {
+	var v map[string]any
+	b, err := json.Marshal(a.Document())
+	if err == nil {
+		err = json.Unmarshal(b, &v) //normalize values for yaml
+	}
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// ServeHTTP serves the document, YAML when path ends with .yaml or .yml, otherwise JSON.
+func (a *OpenAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b []byte
+	var err error
+	ct := "application/json; charset=utf-8"
+	if ext := path.Ext(r.URL.Path); ext == ".yaml" || ext == ".yml" {
+		ct = "application/yaml; charset=utf-8"
+		b, err = a.YAML()
+	} else {
+		b, err = a.JSON()
+	}
+	if err != nil {
+		WriteProblem(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	_, _ = w.Write(b)
+}
+
+var openapiUI = template.Must(template.New("ui").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+{{if eq .Kind "redoc"}}</head>
+<body>
+<redoc spec-url="{{.Spec}}"></redoc>
+<script src="{{.Assets}}/redoc.standalone.js"></script>
+{{else}}<link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+</head>
+<body>
+<div id="ui"></div>
+<script src="{{.Assets}}/swagger-ui-bundle.js"></script>
+<script>window.onload = function () { SwaggerUIBundle({url: "{{.Spec}}", dom_id: "#ui"}) }</script>
+{{end}}</body>
+</html>
+`))
+
+// UI handler of Swagger UI or Redoc, assets is the base url of scripts, default loads from CDN.
+func (a *OpenAPI) UI(kind, assets string) http.HandlerFunc {
+	if assets == "" {
+		if kind == "redoc" {
+			assets = "https://cdn.redoc.ly/redoc/latest/bundles"
+		} else {
+			assets = "https://unpkg.com/swagger-ui-dist@5"
+		}
+	}
+	data := map[string]string{"Title": a.Title, "Kind": kind, "Assets": strings.TrimSuffix(assets, "/"), "Spec": a.Path + ".json"}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = openapiUI.Execute(w, data)
+	}
+}
+
+// WithOpenAPI serve OpenAPI document of the router and the optional UI, see [NewOpenAPI]. Routes registered later are also documented.
+func (c RouterConfigurer) WithOpenAPI(cfg conf.Config) RouterConfigurer {
+	a := NewOpenAPI(c.Router, cfg)
+	Describe(c.Handle(a.Path+".json", a).Methods(http.MethodGet).Name("openapi")).Hidden()
+	Describe(c.Handle(a.Path+".yaml", a).Methods(http.MethodGet).Name("openapi-yaml")).Hidden()
+	if cfg != nil && cfg.IsObject("openapi.ui") {
+		ui := cfg.GetObject("openapi.ui")
+		Describe(c.Handle(ui.GetString("path", "/docs"), a.UI(ui.GetString("kind", "swagger"), ui.GetString("assets"))).
+			Methods(http.MethodGet).Name("openapi-ui")).Hidden()
+	}
+	return c
+}
+
+// openapiPath convert mux path template to OpenAPI path, returns the patterns of variables
+func openapiPath(tpl string) (string, map[string]string) {
+	patterns := map[string]string{}
+	p := pathVarExpr.ReplaceAllStringFunc(tpl, func(s string) string {
+		m := pathVarExpr.FindStringSubmatch(s)
+		patterns[m[1]] = m[2]
+		return "{" + m[1] + "}"
+	})
+	return p, patterns
+}
+
+func (g *schemaGen) operation(o *Operation, name, method string, patterns map[string]string) map[string]any {
+	op := map[string]any{}
+	if o.id != "" {
+		op["operationId"] = o.id
+	} else if name != "" {
+		op["operationId"] = name
+	}
+	if o.summary != "" {
+		op["summary"] = o.summary
+	}
+	if o.description != "" {
+		op["description"] = o.description
+	}
+	if len(o.tags) > 0 {
+		op["tags"] = o.tags
+	}
+	if o.deprecated {
+		op["deprecated"] = true
+	}
+	if len(o.security) > 0 {
+		sec := make([]map[string][]string, len(o.security))
+		for i, s := range o.security {
+			sec[i] = map[string][]string{s: {}}
+		}
+		op["security"] = sec
+	}
+	var params []opParam
+	seen := map[string]bool{}
+	add := func(p opParam) {
+		if !seen[p.In+":"+p.Name] {
+			seen[p.In+":"+p.Name] = true
+			params = append(params, p)
+		}
+	}
+	for _, p := range o.params {
+		p.Schema = g.schema(p.typ)
+		add(p)
+	}
+	var body map[string]any
+	if t := o.request; t != nil {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			for _, p := range g.params(t) {
+				add(p)
+			}
+			if method != "get" && method != "head" && method != "delete" {
+				body = g.body(t)
+			}
+		}
+	}
+	vars := make([]string, 0, len(patterns))
+	for v := range patterns {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	for _, v := range vars {
+		s := map[string]any{"type": "string"}
+		if patterns[v] != "" {
+			s["pattern"] = "^" + patterns[v] + "$"
+		}
+		add(opParam{In: "path", Name: v, Required: true, Schema: s})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if body != nil {
+		op["requestBody"] = body
+	}
+	responses := map[string]any{}
+	for status, r := range o.responses {
+		res := map[string]any{"description": r.description}
+		if r.typ != nil {
+			ct := "application/json"
+			if r.problem {
+				ct = ContentTypeProblem
+			}
+			res["content"] = map[string]any{ct: map[string]any{"schema": g.schema(r.typ)}}
+		}
+		responses[strconv.Itoa(status)] = res
+	}
+	if len(responses) == 0 {
+		responses["default"] = map[string]any{"description": "response"}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// params of query and path tagged fields
+func (g *schemaGen) params(t reflect.Type) (ps []opParam) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			ps = append(ps, g.params(f.Type)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		for _, in := range []string{"path", "query"} {
+			v, ok := f.Tag.Lookup(in)
+			if !ok {
+				continue
+			}
+			name, _, _ := strings.Cut(v, ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s := g.schema(f.Type)
+			required := g.rules(s, f)
+			ps = append(ps, opParam{In: in, Name: name, Description: f.Tag.Get("doc"), Required: required || in == "path", Schema: s})
+		}
+	}
+	return
+}
+
+// body schema of fields not tagged with query and path
+func (g *schemaGen) body(t reflect.Type) map[string]any {
+	if _, ok := schemas.Load(t); ok || implements(t, jsonMarshaler) {
+		return map[string]any{"required": true, "content": map[string]any{"application/json": map[string]any{"schema": g.schema(t)}}}
+	}
+	props := map[string]any{}
+	var required []string
+	form := g.fields(t, props, &required, true)
+	if len(props) == 0 {
+		return nil
+	}
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	content := map[string]any{}
+	if form {
+		content["multipart/form-data"] = map[string]any{"schema": s}
+		content["application/x-www-form-urlencoded"] = map[string]any{"schema": s}
+	} else if len(g.params(t)) == 0 {
+		content["application/json"] = map[string]any{"schema": g.schema(t)}
+	} else {
+		content["application/json"] = map[string]any{"schema": s} //exclude parameters
+	}
+	return map[string]any{"required": true, "content": content}
+}
+
+// fields collect properties of struct, returns true when has form tags or files
+func (g *schemaGen) fields(t reflect.Type, props map[string]any, required *[]string, body bool) (form bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if body && (f.Tag.Get("query") != "" || f.Tag.Get("path") != "") {
+			continue
+		}
+		tag := "json"
+		if _, ok := f.Tag.Lookup("form"); ok && body {
+			tag = "form"
+			form = true
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			e := f.Type
+			if e.Kind() == reflect.Pointer {
+				e = e.Elem()
+			}
+			if e.Kind() == reflect.Struct {
+				form = g.fields(e, props, required, body) || form
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		e := f.Type
+		for e.Kind() == reflect.Pointer || e.Kind() == reflect.Slice {
+			e = e.Elem()
+		}
+		if e == fileHeaderType.Elem() {
+			form = true
+		}
+		s := g.schema(f.Type)
+		if d := f.Tag.Get("doc"); d != "" {
+			s["description"] = d
+		}
+		if x, ok := f.Tag.Lookup("example"); ok {
+			s["examples"] = []string{x}
+		}
+		if g.rules(s, f) {
+			*required = append(*required, name)
+		}
+		props[name] = s
+	}
+	return
+}
+
+// rules apply validate rules to schema, returns if the field is required
+func (g *schemaGen) rules(s map[string]any, f reflect.StructField) (required bool) {
+	rs, _ := parseRules(f.Tag.Get("validate"))
+	t := f.Type
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	items := s
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if m, ok := s["items"].(map[string]any); ok {
+			items = m
+		}
+	}
+	for _, r := range rs {
+		switch r.name {
+		case "required":
+			required = true
+		case "min", "max":
+			var key string
+			switch t.Kind() {
+			case reflect.String:
+				key = "Length"
+			case reflect.Slice, reflect.Array:
+				key = "Items"
+			case reflect.Map:
+				key = "Properties"
+			default:
+				if r.name == "min" {
+					s["minimum"] = r.num
+				} else {
+					s["maximum"] = r.num
+				}
+				continue
+			}
+			s[r.name+key] = int(r.num)
+		case "regex":
+			items["pattern"] = r.arg
+		case "enum":
+			items["enum"] = r.enum
+		case "email":
+			items["format"] = "email"
+		}
+	}
+	return
+}
+
+// schema of the type, named structs are referenced from components
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	if t == nil {
+		return map[string]any{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if v, ok := schemas.Load(t); ok {
+		return copySchema(v).(map[string]any)
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == fileHeaderType.Elem():
+		return map[string]any{"type": "string", "format": "binary"}
+	case t == rawJsonType:
+		return map[string]any{}
+	case t == problemType:
+		g.problem()
+		return map[string]any{"$ref": "#/components/schemas/Problem"}
+	case implements(t, jsonMarshaler):
+		return map[string]any{} //the encoding is unknown, use RegisterSchema to describe it
+	case implements(t, textMarshaler) || reflect.PointerTo(t).Implements(textUnmarshaler):
+		return map[string]any{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.nameOf(t)
+			g.names[t] = name
+			g.defs[name] = map[string]any{} //placeholder for recursive types
+			g.defs[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	g.fields(t, props, &required, false)
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+var schemaNameExpr = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// nameOf unique component name of the type
+func (g *schemaGen) nameOf(t reflect.Type) string {
+	name := strings.Trim(schemaNameExpr.ReplaceAllString(t.Name(), "_"), "_")
+	if x, ok := g.used[name]; ok && x != t {
+		name = path.Base(t.PkgPath()) + "." + name
+		for i := 2; ; i++ {
+			if x, ok = g.used[name]; !ok || x == t {
+				break
+			}
+			name = path.Base(t.PkgPath()) + "." + schemaNameExpr.ReplaceAllString(t.Name(), "_") + strconv.Itoa(i)
+		}
+	}
+	g.used[name] = t
+	return name
+}
+
+func (g *schemaGen) problem() {
+	if _, ok := g.defs["Problem"]; ok {
+		return
+	}
+	g.used["Problem"] = problemType
+	g.names[problemType] = "Problem"
+	g.defs["Problem"] = map[string]any{
+		"type":        "object",
+		"description": "RFC 7807 problem details",
+		"properties": map[string]any{
+			"type":     map[string]any{"type": "string", "format": "uri-reference"},
+			"title":    map[string]any{"type": "string"},
+			"status":   map[string]any{"type": "integer", "format": "int32"},
+			"detail":   map[string]any{"type": "string"},
+			"instance": map[string]any{"type": "string", "format": "uri-reference"},
+		},
+		"additionalProperties": true,
+	}
+}
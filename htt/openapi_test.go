@@ -0,0 +1,135 @@
+package htt
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"github.com/ZenLiuCN/gofra/hasher"
+	"github.com/gorilla/mux"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files of testdata")
+
+type (
+	apiMoney struct {
+		cents int64
+	}
+	apiAddress struct {
+		City string `json:"city" validate:"required"`
+		Zip  string `json:"zip" validate:"regex=^[0-9]{3\\,5}$"`
+	}
+	apiUser struct {
+		ID      int64                    `json:"id"`
+		Name    string                   `json:"name" validate:"required,min=2,max=32" doc:"display name" example:"alice"`
+		Email   string                   `json:"email,omitempty" validate:"email"`
+		Role    string                   `json:"role" validate:"enum=admin|user"`
+		Tags    []string                 `json:"tags" validate:"max=8"`
+		Secret  hasher.Encrypted[string] `json:"secret"`
+		Balance apiMoney                 `json:"balance"`
+		IP      net.IP                   `json:"ip"`
+		Home    *apiAddress              `json:"home"`
+		Created time.Time                `json:"created"`
+		Friends []*apiUser               `json:"friends,omitempty"`
+	}
+	apiUpdate struct {
+		ID     int64  `path:"id"`
+		Notify bool   `query:"notify" doc:"send notification"`
+		Name   string `json:"name" validate:"min=2"`
+	}
+	apiUpload struct {
+		Title string                `form:"title" validate:"required"`
+		File  *multipart.FileHeader `form:"file"`
+	}
+)
+
+func (m apiMoney) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + time.Duration(m.cents).String() + `"`), nil
+}
+
+func openapiRouter() *mux.Router {
+	r := mux.NewRouter()
+	c := RouterConfigurerOf(r)
+	c.Endpoint(http.MethodGet, "/users/{id:[0-9]+}", func(http.ResponseWriter, *http.Request) {}).
+		ID("getUser").Summary("get user", "by id").Tags("users").Secure().
+		Response(http.StatusOK, apiUser{}).
+		Errors(ErrTokenMissing, errors.New("unknown"))
+	c.Endpoint(http.MethodPut, "/users/{id}", func(http.ResponseWriter, *http.Request) {}).
+		Tags("users").Request(apiUpdate{}).Response(http.StatusNoContent, nil)
+	c.Endpoint(http.MethodPost, "/users/{id}/avatar", func(http.ResponseWriter, *http.Request) {}).
+		Request(&apiUpload{}).Param("header", "X-Trace", "", "trace id").Deprecated()
+	c.Endpoint(http.MethodPost, "/users", func(http.ResponseWriter, *http.Request) {}).
+		Request(apiUser{}).Response(http.StatusCreated, &apiUser{})
+	c.Endpoint(http.MethodGet, "/internal", func(http.ResponseWriter, *http.Request) {}).Hidden()
+	r.HandleFunc("/plain", func(http.ResponseWriter, *http.Request) {}).Methods(http.MethodGet, http.MethodOptions).Name("plain")
+	return r
+}
+
+func TestOpenAPIGolden(t *testing.T) {
+	RegisterSchema(&apiMoney{}, map[string]any{"type": "string", "format": "duration", "examples": []any{"1.5s"}})
+	a := NewOpenAPI(openapiRouter(), nil)
+	a.Title = "golden"
+	a.Servers = []string{"https://api.example.com"}
+	b, err := a.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = append(b, '\n')
+	golden := filepath.Join("testdata", "openapi.golden.json")
+	if *updateGolden {
+		if err = os.MkdirAll("testdata", 0o755); err == nil {
+			err = os.WriteFile(golden, b, 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("document differs from %s, run with -update to accept:\n%s", golden, b)
+	}
+}
+
+func TestOpenAPISchema(t *testing.T) {
+	g := &schemaGen{defs: map[string]any{}, names: map[reflect.Type]string{}, used: map[string]reflect.Type{}}
+	if s := g.schema(reflect.TypeOf(hasher.Encrypted[string]{})); len(s) != 0 {
+		t.Fatalf("json marshaler struct should be any value: %v", s)
+	}
+	if s := g.schema(reflect.TypeOf(net.IP{})); s["type"] != "string" {
+		t.Fatalf("text marshaler should be string: %v", s)
+	}
+	type override struct{ A int }
+	RegisterSchema(override{}, map[string]any{"type": "integer", "items": map[string]any{}})
+	s := g.schema(reflect.TypeOf(&override{}))
+	s["type"] = "changed"
+	s["items"].(map[string]any)["enum"] = []string{"x"}
+	if s = g.schema(reflect.TypeOf(override{})); s["type"] != "integer" || len(s["items"].(map[string]any)) != 0 {
+		t.Fatalf("registered schema modified: %v", s)
+	}
+	if len(g.defs) != 0 {
+		t.Fatalf("opaque types should not be components: %v", g.defs)
+	}
+}
+
+func TestOpenAPIServe(t *testing.T) {
+	a := NewOpenAPI(openapiRouter(), nil)
+	for p, ct := range map[string]string{"/openapi.json": "application/json", "/openapi.yaml": "application/yaml"} {
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), ct) || !strings.Contains(w.Body.String(), "getUser") {
+			t.Fatalf("%s: %d %s", p, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+}
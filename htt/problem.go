@@ -0,0 +1,336 @@
+package htt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ZenLiuCN/gofra/breaker"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/error_db/mysqlerr"
+	"github.com/ZenLiuCN/gofra/units"
+	errno "github.com/bombsimon/mysql-error-numbers"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-jwt/jwt/v5"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Problem details of an error response, see RFC 7807
+	Problem struct {
+		Type       string         `json:"type,omitempty"` //default about:blank
+		Title      string         `json:"title,omitempty"`
+		Status     int            `json:"status,omitempty"`
+		Detail     string         `json:"detail,omitempty"`
+		Instance   string         `json:"instance,omitempty"`
+		Extensions map[string]any `json:"-"` //extension members, which flatten into the document
+		Err        error          `json:"-"` //the cause, not exposed
+	}
+	// ErrorMapper convert error to [Problem], returns nil when not handled
+	ErrorMapper func(err error) *Problem
+	// ProblemRegistry resolves errors to problems by registered mappers, the later registered takes precedence.
+	ProblemRegistry struct {
+		lock    sync.RWMutex
+		mappers []ErrorMapper
+	}
+)
+
+const (
+	ContentTypeProblem = "application/problem+json"
+	// StatusClientClosed the non-standard status of client closed request
+	StatusClientClosed = 499
+)
+
+// NewProblem create [Problem] with status and detail, title is the status text.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Status: status, Title: http.StatusText(status), Detail: detail}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return strconv.Itoa(p.Status) + " " + p.Title + ": " + p.Detail
+	}
+	return strconv.Itoa(p.Status) + " " + p.Title
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// With set an extension member
+func (p *Problem) With(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+// Wrap set the cause
+func (p *Problem) Wrap(err error) *Problem {
+	p.Err = err
+	return p
+}
+
+// clone copy the problem and its extensions, invalid status is replaced by 500
+func (p *Problem) clone() *Problem {
+	cp := *p
+	if cp.Status < 100 || cp.Status > 599 {
+		cp.Status = http.StatusInternalServerError
+	}
+	if p.Extensions != nil {
+		cp.Extensions = make(map[string]any, len(p.Extensions))
+		for k, v := range p.Extensions {
+			cp.Extensions[k] = v
+		}
+	}
+	return &cp
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	if p.Type == "" {
+		m["type"] = "about:blank"
+	}
+	m["status"] = p.Status
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+//region Registry
+
+// Register a mapper
+func (g *ProblemRegistry) Register(fn ErrorMapper) *ProblemRegistry {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.mappers = append(g.mappers, fn)
+	return g
+}
+
+// RegisterError map errors matched target by [errors.Is] to the status, title is optional.
+// The detail is the message of the error. It panics when status is not a valid http status.
+func (g *ProblemRegistry) RegisterError(target error, status int, title string) *ProblemRegistry {
+	if status < 100 || status > 599 {
+		panic(fmt.Errorf("invalid status %d of %v", status, target))
+	}
+	return g.Register(func(err error) *Problem {
+		if !errors.Is(err, target) {
+			return nil
+		}
+		p := NewProblem(status, err.Error())
+		if title != "" {
+			p.Title = title
+		}
+		return p
+	})
+}
+
+// Resolve the error to [Problem], unresolved errors are 500 without detail, so are problems without a valid status.
+// The result is a copy, which is safe to modify even if the error or the mapper returns a shared [Problem].
+func (g *ProblemRegistry) Resolve(err error) (p *Problem) {
+	if err == nil {
+		return nil
+	}
+	if errors.As(err, &p) {
+		return p.clone()
+	}
+	g.lock.RLock()
+	mappers := g.mappers
+	g.lock.RUnlock()
+	for i := len(mappers) - 1; i >= 0; i-- {
+		if p = mappers[i](err); p != nil {
+			p = p.clone()
+			if p.Err == nil {
+				p.Err = err
+			}
+			return
+		}
+	}
+	return NewProblem(http.StatusInternalServerError, "").Wrap(err)
+}
+
+// Problems the default registry, see [ProblemOf]
+var Problems = new(ProblemRegistry)
+
+// RegisterError see [ProblemRegistry.RegisterError] of [Problems]
+func RegisterError(target error, status int, title string) {
+	Problems.RegisterError(target, status, title)
+}
+
+// RegisterErrorMapper see [ProblemRegistry.Register] of [Problems]
+func RegisterErrorMapper(fn ErrorMapper) {
+	Problems.Register(fn)
+}
+
+// ProblemOf resolve error by [Problems]
+func ProblemOf(err error) *Problem {
+	return Problems.Resolve(err)
+}
+
+func mysqlProblem(err error) *Problem {
+	var n errno.ErrorNumber
+	var me *mysql.MySQLError
+	var pe mysqlerr.MySQLError
+	switch {
+	case errors.As(err, &me):
+		n = errno.ErrorNumber(me.Number)
+	case errors.As(err, &pe):
+		n = errno.ErrorNumber(pe)
+	default:
+		return nil
+	}
+	switch n {
+	case errno.ErrDupEntry:
+		return NewProblem(http.StatusConflict, "duplicate entry")
+	case errno.ErrRowIsReferenced2:
+		return NewProblem(http.StatusConflict, "entry is referenced")
+	case errno.ErrNoReferencedRow2:
+		return NewProblem(http.StatusUnprocessableEntity, "referenced entry not exists")
+	case errno.ErrLockDeadlock, errno.ErrLockWaitTimeout:
+		return NewProblem(http.StatusServiceUnavailable, "resource busy, try later")
+	default:
+		return NewProblem(http.StatusInternalServerError, "")
+	}
+}
+
+func init() {
+	Problems.
+		Register(mysqlProblem).
+		RegisterError(breaker.ErrOpenState, http.StatusServiceUnavailable, "").
+		RegisterError(breaker.ErrTooManyRequests, http.StatusTooManyRequests, "").
+		RegisterError(context.DeadlineExceeded, http.StatusGatewayTimeout, "").
+		RegisterError(context.Canceled, StatusClientClosed, "Client Closed Request").
+		Register(func(err error) *Problem {
+			var re units.ResponseError
+			if !errors.As(err, &re) {
+				return nil
+			}
+			status := re.Code
+			if status < 400 || status > 599 {
+				status = http.StatusInternalServerError
+			}
+			return NewProblem(status, re.Message)
+		}).
+		Register(func(err error) *Problem {
+			var ve ValidationError
+			if !errors.As(err, &ve) {
+				return nil
+			}
+			return NewProblem(http.StatusBadRequest, "validation failed").With("errors", []units.FieldError(ve))
+		})
+	for _, target := range []error{
+		jwt.ErrTokenMalformed, jwt.ErrTokenUnverifiable, jwt.ErrTokenSignatureInvalid, jwt.ErrTokenRequiredClaimMissing,
+		jwt.ErrTokenInvalidAudience, jwt.ErrTokenExpired, jwt.ErrTokenUsedBeforeIssued, jwt.ErrTokenInvalidIssuer,
+		jwt.ErrTokenInvalidSubject, jwt.ErrTokenNotValidYet, jwt.ErrTokenInvalidId, jwt.ErrTokenInvalidClaims,
+		ErrTokenMissing, ErrJwtKeyNotFound, ErrTokenRevoked, ErrTokenReused, ErrTokenType,
+	} {
+		Problems.RegisterError(target, http.StatusUnauthorized, "")
+	}
+}
+
+//endregion
+
+//region Response
+
+// prefersText check if the client prefers plain text over json by Accept header
+func prefersText(r *http.Request) bool {
+	best, text := -1.0, false
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var isText bool
+		switch {
+		case mt == ContentTypeProblem || mt == "application/json" || mt == "*/*" || mt == "application/*":
+		case mt == "text/plain" || mt == "text/html" || mt == "text/*":
+			isText = true
+		default:
+			continue
+		}
+		if q > best || (q == best && !isText) {
+			best, text = q, isText
+		}
+	}
+	return text
+}
+
+// WriteProblem write the error as problem+json, or plain text when the client prefers.
+// Server errors are logged with the cause. Nil error writes nothing.
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	if err == nil {
+		return
+	}
+	p := ProblemOf(err) //a copy, the instance is not written back to shared problems
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.Status >= 500 {
+		conf.Internal().ErrorContextf(r.Context(), "handle %s: %+v", r.URL.RequestURI(), p.Err)
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	if prefersText(r) {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(p.Status)
+		_, _ = fmt.Fprintln(w, p.Error())
+		return
+	}
+	h.Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// ProblemHandleFunc adapt handler returns error, errors and panics of errors are written by [WriteProblem].
+func ProblemHandleFunc(h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := WrapResponseWriter(w)
+		defer func() {
+			if e := recover(); e != nil {
+				err, ok := e.(error)
+				if ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(e)
+				}
+				if !ok {
+					err = fmt.Errorf("panic: %v", e)
+				}
+				if !rw.Written() {
+					WriteProblem(rw, r, err)
+				} else {
+					conf.Internal().ErrorContextf(r.Context(), "handle %s after response written: %+v", r.URL.RequestURI(), err)
+				}
+			}
+		}()
+		if err := h(rw, r); err != nil {
+			if !rw.Written() {
+				WriteProblem(rw, r, err)
+			} else {
+				conf.Internal().ErrorContextf(r.Context(), "handle %s after response written: %+v", r.URL.RequestURI(), err)
+			}
+		}
+	}
+}
+
+//endregion
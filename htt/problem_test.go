@@ -0,0 +1,143 @@
+package htt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func problemGet(h http.Handler, path, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestProblemRegistry(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+	shared := NewProblem(http.StatusPaymentRequired, "pay first").With("plan", "free")
+	g := new(ProblemRegistry)
+	g.RegisterError(errQuota, http.StatusTooManyRequests, "Quota").
+		Register(func(err error) *Problem {
+			if err.Error() != "shared" {
+				return nil
+			}
+			return shared
+		})
+	if p := g.Resolve(nil); p != nil {
+		t.Fatalf("nil error %v", p)
+	}
+	p := g.Resolve(fmt.Errorf("wrap: %w", errQuota))
+	if p.Status != http.StatusTooManyRequests || p.Title != "Quota" || !errors.Is(p, errQuota) {
+		t.Fatalf("registered error %+v", p)
+	}
+	p = g.Resolve(errors.New("unknown"))
+	if p.Status != http.StatusInternalServerError || p.Detail != "" || p.Err == nil {
+		t.Fatalf("unresolved %+v", p)
+	}
+
+	p = g.Resolve(errors.New("shared"))
+	p.Instance = "/a"
+	p.With("plan", "pro")
+	if p == shared || shared.Instance != "" || shared.Err != nil || shared.Extensions["plan"] != "free" {
+		t.Fatalf("mapper problem modified %+v", shared)
+	}
+	p = g.Resolve(fmt.Errorf("wrap: %w", shared))
+	p.Instance = "/b"
+	if p == shared || shared.Instance != "" || p.Status != http.StatusPaymentRequired {
+		t.Fatalf("wrapped problem modified %+v", shared)
+	}
+	if p = g.Resolve(&Problem{Title: "no status"}); p.Status != http.StatusInternalServerError || p.Title != "no status" {
+		t.Fatalf("zero status %+v", p)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("invalid status should be rejected")
+			}
+		}()
+		g.RegisterError(errQuota, 0, "")
+	}()
+	w := httptest.NewRecorder()
+	WriteProblem(w, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Fatalf("nil error written %q", w.Body.String())
+	}
+	for _, err := range []error{jwt.ErrTokenSignatureInvalid, jwt.ErrTokenNotValidYet, ErrJwtKeyNotFound, ErrTokenReused, ErrTokenType,
+		fmt.Errorf("%w: %w", jwt.ErrTokenInvalidClaims, jwt.ErrTokenInvalidAudience)} {
+		if p = ProblemOf(err); p.Status != http.StatusUnauthorized {
+			t.Fatalf("%v: status %d", err, p.Status)
+		}
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	errGone := NewProblem(http.StatusGone, "moved away").With("to", "/new")
+	h := ProblemHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.URL.Path {
+		case "/gone", "/other":
+			return errGone
+		case "/validate":
+			return ValidationError{{Field: "name", Rule: "required", Message: "is required"}}
+		case "/response":
+			return units.ResponseError{Code: http.StatusNotFound, Message: "no item"}
+		case "/bad-code":
+			return units.ResponseError{Code: 1, Message: "odd"}
+		case "/timeout":
+			return context.DeadlineExceeded
+		case "/panic":
+			panic("boom")
+		case "/written":
+			w.WriteHeader(http.StatusAccepted)
+			return errGone
+		}
+		return nil
+	})
+	w := problemGet(h, "/gone", "")
+	var m map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&m); err != nil || w.Code != http.StatusGone ||
+		w.Header().Get("Content-Type") != ContentTypeProblem ||
+		m["type"] != "about:blank" || m["instance"] != "/gone" || m["detail"] != "moved away" || m["to"] != "/new" {
+		t.Fatalf("problem %d %v %v", w.Code, m, err)
+	}
+	if errGone.Instance != "" {
+		t.Fatalf("instance written back to shared problem %q", errGone.Instance)
+	}
+	m = nil
+	w = problemGet(h, "/other", "")
+	if err := json.NewDecoder(w.Body).Decode(&m); err != nil || m["instance"] != "/other" {
+		t.Fatalf("instance of second request %v %v", m, err)
+	}
+	w = problemGet(h, "/gone", "text/plain, application/json;q=0.5")
+	if w.Header().Get("Content-Type") != "text/plain; charset=utf-8" || strings.TrimSpace(w.Body.String()) != "410 Gone: moved away" {
+		t.Fatalf("text %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+	for path, status := range map[string]int{
+		"/validate": http.StatusBadRequest,
+		"/response": http.StatusNotFound,
+		"/bad-code": http.StatusInternalServerError,
+		"/timeout":  http.StatusGatewayTimeout,
+		"/panic":    http.StatusInternalServerError,
+		"/written":  http.StatusAccepted,
+		"/ok":       http.StatusOK,
+	} {
+		if w = problemGet(h, path, "application/json"); w.Code != status {
+			t.Fatalf("%s status %d, want %d", path, w.Code, status)
+		}
+	}
+	m = nil
+	w = problemGet(h, "/validate", "")
+	if err := json.NewDecoder(w.Body).Decode(&m); err != nil || len(m["errors"].([]any)) != 1 {
+		t.Fatalf("validation errors %v %v", m, err)
+	}
+}
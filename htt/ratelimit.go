@@ -0,0 +1,415 @@
+package htt
+
+import (
+	"context"
+	"fmt"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/gorilla/mux"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// RateAlgorithm algorithm of rate limiting
+	RateAlgorithm string
+	// RateRule limit of requests in a window
+	RateRule struct {
+		Algorithm RateAlgorithm
+		Limit     int           //requests allowed in window
+		Window    time.Duration //the window
+		Burst     int           //bucket capacity of token bucket, default is Limit
+	}
+	// RateResult result of [RateLimitStore.Take]
+	RateResult struct {
+		Allowed    bool
+		Limit      int
+		Remaining  int
+		Reset      time.Duration //time until quota fully restored
+		RetryAfter time.Duration //time until next request allowed, zero when allowed
+	}
+	// RateLimitStore stores states of rate limiting, implementations must be safe for concurrent use.
+	RateLimitStore interface {
+		// Take one request of the key under the rule, returns error when the rule is invalid, see [RateRule.Validate].
+		Take(ctx context.Context, key string, rule RateRule, now time.Time) (RateResult, error)
+	}
+	// RateKeyFunc extract the key to limit of a request, empty key skips limiting.
+	RateKeyFunc func(r *http.Request) string
+	// RateLimiter a rate limit middleware with default rule and per route rules, routes are matched by name.
+	RateLimiter struct {
+		Store   RateLimitStore
+		Rule    RateRule
+		Key     RateKeyFunc
+		Routes  map[string]RateLimitRoute
+		Headers bool             //write RateLimit-* headers
+		Clock   func() time.Time //optional clock, default is [time.Now]
+	}
+	// RateLimitRoute rule and key for a named route
+	RateLimitRoute struct {
+		Rule RateRule
+		Key  RateKeyFunc
+	}
+	rateState struct {
+		lock   sync.Mutex
+		tokens float64   //token bucket: current tokens
+		last   time.Time //token bucket: last refill; sliding window: start of current window
+		prev   int       //sliding window: count of previous window
+		count  int       //sliding window: count of current window
+	}
+	memoryRateStore struct {
+		lock  sync.Mutex //guards creating states
+		cache units.Cache[string, *rateState]
+	}
+)
+
+const (
+	TokenBucket   RateAlgorithm = "token"
+	SlidingWindow RateAlgorithm = "sliding"
+)
+
+// NewMemoryRateStore create an in memory [RateLimitStore] built on [units.Cache], ttl should be at least twice of the largest window.
+//
+// Keys not taken within ttl are evicted. The time passed to Take only drives the rule, so an idle state restarts
+// with full quota by the rule even before evicted.
+func NewMemoryRateStore(ttl time.Duration) RateLimitStore {
+	ttl = max(ttl, time.Second)
+	c := units.NewCache[string, *rateState](ttl/2, ttl, units.MILLS, units.WithExpiredAfterAccess(ttl))
+	c.StartKeeping()
+	return &memoryRateStore{cache: c}
+}
+
+func (m *memoryRateStore) state(key string) *rateState {
+	if s, ok := m.cache.Get(key); ok {
+		return s
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, ok := m.cache.Get(key)
+	if !ok {
+		s = new(rateState)
+		m.cache.Put(key, s)
+	}
+	return s
+}
+
+func (m *memoryRateStore) Take(ctx context.Context, key string, rule RateRule, now time.Time) (RateResult, error) {
+	if err := rule.Validate(); err != nil {
+		return RateResult{}, err
+	}
+	s := m.state(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.take(rule, now), nil
+}
+
+// Validate the rule, limit and window must be positive, burst must not be negative.
+func (r RateRule) Validate() error {
+	switch {
+	case r.Algorithm != "" && r.Algorithm != TokenBucket && r.Algorithm != SlidingWindow:
+		return fmt.Errorf("unknown rate limit algorithm %q", r.Algorithm)
+	case r.Limit <= 0 || r.Window <= 0:
+		return fmt.Errorf("rate limit requires positive limit and window")
+	case r.Burst < 0:
+		return fmt.Errorf("rate limit requires non-negative burst")
+	}
+	return nil
+}
+
+func (s *rateState) take(rule RateRule, now time.Time) RateResult {
+	if rule.Algorithm == SlidingWindow {
+		return s.slide(rule, now)
+	}
+	return s.bucket(rule, now)
+}
+
+func (s *rateState) bucket(rule RateRule, now time.Time) (r RateResult) {
+	capacity := rule.Burst
+	if capacity <= 0 {
+		capacity = rule.Limit
+	}
+	rate := float64(rule.Limit) / rule.Window.Seconds() //tokens per second
+	if s.last.IsZero() {
+		s.tokens = float64(capacity)
+	} else if d := now.Sub(s.last).Seconds(); d > 0 {
+		s.tokens = math.Min(float64(capacity), s.tokens+d*rate)
+	}
+	s.last = now
+	r.Limit = capacity
+	if s.tokens >= 1 {
+		s.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = time.Duration((1 - s.tokens) / rate * float64(time.Second))
+	}
+	r.Remaining = int(s.tokens)
+	r.Reset = time.Duration((float64(capacity) - s.tokens) / rate * float64(time.Second))
+	return
+}
+
+func (s *rateState) slide(rule RateRule, now time.Time) (r RateResult) {
+	w := rule.Window
+	start := now.Truncate(w)
+	switch {
+	case s.last.IsZero() || start.Sub(s.last) > w:
+		s.prev, s.count = 0, 0
+	case start.After(s.last):
+		s.prev, s.count = s.count, 0
+	}
+	s.last = start
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(w)
+	estimate := float64(s.prev)*weight + float64(s.count)
+	r.Limit = rule.Limit
+	r.Reset = w - elapsed
+	if estimate+1 <= float64(rule.Limit) {
+		s.count++
+		estimate++
+		r.Allowed = true
+	} else if s.count+1 > rule.Limit || s.prev == 0 {
+		r.RetryAfter = r.Reset
+	} else {
+		//wait for the weight of previous window decay enough
+		need := 1 - (float64(rule.Limit-s.count-1) / float64(s.prev))
+		r.RetryAfter = time.Duration(need*float64(w)) - elapsed
+	}
+	r.Remaining = max(0, rule.Limit-int(math.Ceil(estimate)))
+	return
+}
+
+//region Keys
+
+// ClientIP resolve client address. Forwarded headers are honored only when the remote address is a trusted proxy,
+// and addresses of trusted proxies in X-Forwarded-For are skipped from right to left.
+func ClientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !trustedAddr(addr, trusted) {
+		return host
+	}
+	if v := r.Header.Values("X-Forwarded-For"); len(v) > 0 {
+		hops := strings.Split(strings.Join(v, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			if !trustedAddr(a, trusted) || i == 0 {
+				return a.Unmap().String()
+			}
+		}
+	}
+	if v := r.Header.Get("X-Real-IP"); v != "" {
+		if a, err := netip.ParseAddr(strings.TrimSpace(v)); err == nil {
+			return a.Unmap().String()
+		}
+	}
+	return host
+}
+
+func trustedAddr(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes parse CIDR or single addresses
+func ParsePrefixes(v ...string) (p []netip.Prefix, err error) {
+	for _, s := range v {
+		var x netip.Prefix
+		if strings.Contains(s, "/") {
+			x, err = netip.ParsePrefix(s)
+		} else {
+			var a netip.Addr
+			if a, err = netip.ParseAddr(s); err == nil {
+				x = netip.PrefixFrom(a, a.BitLen())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, x.Masked())
+	}
+	return
+}
+
+// KeyByIP limit by client ip, see [ClientIP]
+func KeyByIP(trusted []netip.Prefix) RateKeyFunc {
+	return func(r *http.Request) string {
+		return "ip:" + ClientIP(r, trusted)
+	}
+}
+
+// KeyBySubject limit by subject of verified jwt, which requires [RouterConfigurer.WithJWT] before. Requests without token are limited by ip.
+func KeyBySubject(trusted []netip.Prefix) RateKeyFunc {
+	return func(r *http.Request) string {
+		if t := TokenOf(r.Context()); t != nil && t.Claims != nil {
+			if s, err := t.Claims.GetSubject(); err == nil && s != "" {
+				return "sub:" + s
+			}
+		}
+		return "ip:" + ClientIP(r, trusted)
+	}
+}
+
+// KeyByRoute limit all requests of a route together, by route name or path template.
+func KeyByRoute(r *http.Request) string {
+	if m := mux.CurrentRoute(r); m != nil {
+		if n := m.GetName(); n != "" {
+			return "route:" + n
+		}
+		if t, err := m.GetPathTemplate(); err == nil {
+			return "route:" + t
+		}
+	}
+	return "route:" + r.URL.Path
+}
+
+//endregion
+
+func parseRateRule(c conf.Config, def RateRule) (r RateRule, err error) {
+	r = def
+	r.Algorithm = RateAlgorithm(c.GetString("algorithm", string(def.Algorithm)))
+	r.Limit = int(c.GetInt32("limit", int32(def.Limit)))
+	r.Window = c.GetTimeDuration("window", def.Window)
+	r.Burst = int(c.GetInt32("burst", int32(def.Burst)))
+	err = r.Validate()
+	return
+}
+
+func parseRateKey(kind string, trusted []netip.Prefix) (RateKeyFunc, error) {
+	switch kind {
+	case "", "ip":
+		return KeyByIP(trusted), nil
+	case "subject":
+		return KeyBySubject(trusted), nil
+	case "route":
+		return KeyByRoute, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit key %q", kind)
+	}
+}
+
+/*
+NewRateLimiter create [RateLimiter] from config, store is optional, default is an in memory store.
+
+HOCON sample:
+
+	rateLimit{
+	 algorithm: token # token or sliding
+	 limit: 100 # requests per window
+	 window: 1m
+	 burst: 20 # bucket capacity of token algorithm, default is limit
+	 key: ip # ip, subject or route
+	 trustedProxies: [10.0.0.0/8, 127.0.0.1]
+	 headers: true # write RateLimit-* headers
+	 routes{
+	  login{ limit: 5, window: 1m } # by route name, unspecified values inherit from above
+	 }
+	}
+*/
+func NewRateLimiter(cfg conf.Config, store RateLimitStore) (l *RateLimiter, err error) {
+	c := cfg.GetObject("rateLimit")
+	if c == nil {
+		c = conf.Empty()
+	}
+	l = &RateLimiter{Store: store, Headers: c.GetBoolean("headers", true), Routes: map[string]RateLimitRoute{}}
+	var trusted []netip.Prefix
+	if trusted, err = ParsePrefixes(c.GetStringList("trustedProxies")...); err != nil {
+		return nil, err
+	}
+	if l.Rule, err = parseRateRule(c, RateRule{Algorithm: TokenBucket, Limit: 100, Window: time.Minute}); err != nil {
+		return nil, err
+	}
+	key := c.GetString("key", "ip")
+	if l.Key, err = parseRateKey(key, trusted); err != nil {
+		return nil, err
+	}
+	window := l.Rule.Window
+	for name, rc := range c.GetStringMap("routes") {
+		var r RateLimitRoute
+		if r.Rule, err = parseRateRule(rc, l.Rule); err != nil {
+			return nil, fmt.Errorf("rate limit route %s: %w", name, err)
+		}
+		if r.Key, err = parseRateKey(rc.GetString("key", key), trusted); err != nil {
+			return nil, fmt.Errorf("rate limit route %s: %w", name, err)
+		}
+		window = max(window, r.Rule.Window)
+		l.Routes[name] = r
+	}
+	if l.Store == nil {
+		l.Store = NewMemoryRateStore(2 * window)
+	}
+	return
+}
+
+func (l *RateLimiter) writeHeaders(h http.Header, rule RateRule, res RateResult) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+	h.Set("RateLimit-Policy", strconv.Itoa(rule.Limit)+";w="+strconv.Itoa(int(rule.Window.Seconds())))
+}
+
+// Middleware limit requests, exceeded requests are responded with 429 and Retry-After header.
+// Errors of store are logged and the request is allowed.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, key, scope := l.Rule, l.Key, ""
+		if m := mux.CurrentRoute(r); m != nil && len(l.Routes) > 0 {
+			if x, ok := l.Routes[m.GetName()]; ok {
+				rule, key, scope = x.Rule, x.Key, m.GetName()+"|"
+			}
+		}
+		k := key(r)
+		if k == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now
+		if l.Clock != nil {
+			now = l.Clock
+		}
+		res, err := l.Store.Take(r.Context(), scope+k, rule, now())
+		if err != nil {
+			conf.Internal().WarnContextf(r.Context(), "rate limit store of %s: %s", k, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if l.Headers {
+			l.writeHeaders(w.Header(), rule, res)
+		}
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(res.RetryAfter.Seconds())))))
+			writeJsonError(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithRateLimit use [RateLimiter] configured from cfg, see [NewRateLimiter]. store is optional. It panics when config is invalid.
+//
+// Use it after [RouterConfigurer.WithJWT] to limit by subject.
+func (c RouterConfigurer) WithRateLimit(cfg conf.Config, store RateLimitStore) RouterConfigurer {
+	if cfg == nil {
+		return c
+	}
+	l, err := NewRateLimiter(cfg, store)
+	if err != nil {
+		panic(err)
+	}
+	c.Use(l.Middleware)
+	return c
+}
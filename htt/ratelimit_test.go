@@ -0,0 +1,210 @@
+package htt
+
+import (
+	"context"
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rateClock a manual clock for limiters
+type rateClock struct {
+	now time.Time
+}
+
+func (c *rateClock) Now() time.Time {
+	return c.now
+}
+func (c *rateClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func takeRate(t *testing.T, s RateLimitStore, key string, rule RateRule, now time.Time, allowed bool, remaining int, retry time.Duration) RateResult {
+	t.Helper()
+	r, err := s.Take(context.Background(), key, rule, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Allowed != allowed || r.Remaining != remaining || r.RetryAfter != retry {
+		t.Fatalf("%s at %s: expect allowed=%v remaining=%d retry=%s, got %+v", key, now.Format(time.TimeOnly), allowed, remaining, retry, r)
+	}
+	return r
+}
+
+func TestTokenBucket(t *testing.T) {
+	s := NewMemoryRateStore(time.Hour)
+	rule := RateRule{Algorithm: TokenBucket, Limit: 10, Window: 10 * time.Second, Burst: 3}
+	c := &rateClock{now: time.Unix(1000, 0)}
+	for i := 2; i >= 0; i-- {
+		takeRate(t, s, "k", rule, c.Now(), true, i, 0)
+	}
+	if r := takeRate(t, s, "k", rule, c.Now(), false, 0, time.Second); r.Limit != 3 || r.Reset != 3*time.Second {
+		t.Fatalf("burst exhausted: %+v", r)
+	}
+	c.Add(500 * time.Millisecond)
+	takeRate(t, s, "k", rule, c.Now(), false, 0, 500*time.Millisecond)
+	c.Add(500 * time.Millisecond)
+	takeRate(t, s, "k", rule, c.Now(), true, 0, 0)
+	takeRate(t, s, "other", rule, c.Now(), true, 2, 0)
+	c.Add(time.Hour - time.Second)
+	takeRate(t, s, "k", rule, c.Now(), true, 2, 0)
+	//! burst defaults to limit
+	takeRate(t, s, "nb", RateRule{Limit: 2, Window: time.Second}, c.Now(), true, 1, 0)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	s := NewMemoryRateStore(time.Hour)
+	rule := RateRule{Algorithm: SlidingWindow, Limit: 4, Window: 10 * time.Second}
+	c := &rateClock{now: time.Unix(1000, 0)}
+	for i := 3; i >= 0; i-- {
+		takeRate(t, s, "k", rule, c.Now(), true, i, 0)
+	}
+	takeRate(t, s, "k", rule, c.Now(), false, 0, 10*time.Second)
+	c.Add(15 * time.Second) //previous window weights half
+	takeRate(t, s, "k", rule, c.Now(), true, 1, 0)
+	takeRate(t, s, "k", rule, c.Now(), true, 0, 0)
+	r := takeRate(t, s, "k", rule, c.Now(), false, 0, 2500*time.Millisecond)
+	if r.Reset != 5*time.Second {
+		t.Fatalf("reset %s", r.Reset)
+	}
+	c.Add(r.RetryAfter)
+	takeRate(t, s, "k", rule, c.Now(), true, 0, 0)
+	c.Add(30 * time.Second) //both windows passed
+	takeRate(t, s, "k", rule, c.Now(), true, 3, 0)
+}
+
+func TestMemoryRateStoreEviction(t *testing.T) {
+	s := NewMemoryRateStore(time.Minute).(*memoryRateStore)
+	defer s.cache.Close()
+	rule := RateRule{Algorithm: TokenBucket, Limit: 1, Window: time.Hour}
+	now := time.Unix(1000, 0)
+	takeRate(t, s, "a", rule, now, true, 0, 0)
+	takeRate(t, s, "b", rule, now, true, 0, 0)
+	s.cache.Purify() //half of ttl passed
+	takeRate(t, s, "b", rule, now, false, 0, time.Hour)
+	s.cache.Purify()
+	if _, ok := s.cache.Get("a"); ok || s.cache.Count() != 1 {
+		t.Fatalf("idle key not evicted: %d", s.cache.Count())
+	}
+	takeRate(t, s, "b", rule, now, false, 0, time.Hour)
+	takeRate(t, s, "a", rule, now, true, 0, 0)
+}
+
+func TestRateRuleValidate(t *testing.T) {
+	s := NewMemoryRateStore(time.Minute)
+	for _, rule := range []RateRule{
+		{Limit: 1},
+		{Window: time.Second},
+		{Limit: -1, Window: time.Second},
+		{Limit: 1, Window: time.Second, Burst: -1},
+		{Algorithm: "leaky", Limit: 1, Window: time.Second},
+	} {
+		if _, err := s.Take(context.Background(), "k", rule, time.Now()); err == nil {
+			t.Fatalf("%+v: expect error", rule)
+		}
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	c := &rateClock{now: time.Unix(1000, 0)}
+	l, err := NewRateLimiter(conf.NewConfig(hocon.ParseString(`rateLimit{
+ limit: 2
+ window: 1m
+ key: ip
+ trustedProxies: [10.0.0.0/8]
+ routes{ login{ algorithm: sliding, limit: 1, key: route } }
+}`)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clock = c.Now
+	r := mux.NewRouter()
+	r.Use(l.Middleware)
+	r.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {})
+	r.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {}).Name("login")
+	serve := func(path, remote, forwarded string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	for i := 1; i >= 0; i-- {
+		if w := serve("/api", "10.0.0.1:1", "1.1.1.1"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != string(rune('0'+i)) {
+			t.Fatalf("allowed: %d %v", w.Code, w.Header())
+		}
+	}
+	w := serve("/api", "10.0.0.2:1", "1.1.1.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || w.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Fatalf("limited: %d %v", w.Code, w.Header())
+	}
+	if w = serve("/api", "10.0.0.1:1", "2.2.2.2"); w.Code != http.StatusOK {
+		t.Fatalf("other client: %d", w.Code)
+	}
+	if w = serve("/login", "1.1.1.1:1", ""); w.Code != http.StatusOK {
+		t.Fatalf("route: %d", w.Code)
+	}
+	if w = serve("/login", "3.3.3.3:1", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("route keyed by route: %d", w.Code)
+	}
+	c.Add(time.Minute)
+	if w = serve("/login", "3.3.3.3:1", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("sliding window weights previous: %d", w.Code)
+	}
+	c.Add(time.Minute)
+	if w = serve("/login", "3.3.3.3:1", ""); w.Code != http.StatusOK {
+		t.Fatalf("route after windows: %d", w.Code)
+	}
+}
+
+func TestRateLimiterConfig(t *testing.T) {
+	for name, src := range map[string]string{
+		"algorithm": `rateLimit{algorithm: leaky}`,
+		"limit":     `rateLimit{limit: 0}`,
+		"key":       `rateLimit{key: header}`,
+		"proxies":   `rateLimit{trustedProxies: [bad]}`,
+		"route":     `rateLimit{routes{a{window: 0s}}}`,
+	} {
+		if _, err := NewRateLimiter(conf.NewConfig(hocon.ParseString(src)), nil); err == nil {
+			t.Fatalf("%s: expect error", name)
+		}
+	}
+	l, err := NewRateLimiter(conf.Empty(), nil)
+	if err != nil || l.Rule != (RateRule{Algorithm: TokenBucket, Limit: 100, Window: time.Minute}) || !l.Headers {
+		t.Fatalf("default: %+v %v", l, err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, _ := ParsePrefixes("10.0.0.0/8", "::1")
+	for _, c := range []struct {
+		remote, forwarded, real, want string
+	}{
+		{"1.1.1.1:80", "2.2.2.2", "", "1.1.1.1"},
+		{"10.0.0.1:80", "2.2.2.2, 10.0.0.3", "", "2.2.2.2"},
+		{"10.0.0.1:80", "3.3.3.3, 2.2.2.2", "", "2.2.2.2"},
+		{"10.0.0.1:80", "10.0.0.4, 10.0.0.3", "", "10.0.0.4"},
+		{"[::1]:80", "", "4.4.4.4", "4.4.4.4"},
+		{"[::ffff:10.0.0.1]:80", "::ffff:5.5.5.5", "", "5.5.5.5"},
+		{"10.0.0.1:80", "bad", "", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.real != "" {
+			r.Header.Set("X-Real-IP", c.real)
+		}
+		if v := ClientIP(r, trusted); v != c.want {
+			t.Fatalf("%+v: got %s", c, v)
+		}
+	}
+}
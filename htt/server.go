@@ -0,0 +1,373 @@
+package htt
+
+import (
+	"context"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type (
+	// Server manages lifecycle of a [http.Server].
+	//
+	// Shutdown marks the server not ready, runs before hooks, signals draining to streaming handlers (see [Draining]),
+	// stops accepting and waits for in-flight requests and hijacked connections (such as websockets) until the timeout,
+	// then forcibly closes the remaining connections and runs after hooks.
+	Server struct {
+		*http.Server
+		Name            string
+		Transport       *Transport
+		ShutdownTimeout time.Duration //used when the context of Shutdown has no deadline, default 30 seconds
+		before, after   []func(ctx context.Context)
+		ready           atomic.Bool
+		started         atomic.Bool
+		draining        chan struct{}
+		done            chan struct{}
+		once            sync.Once
+		err             error //serving error
+		shutdownErr     error
+		listener        atomic.Pointer[trackedListener]
+		connLock        sync.Mutex
+		conns           map[*trackedConn]struct{} //accepted connections not closed yet
+		idle            chan struct{}             //closed when all connections closed
+	}
+	// trackedListener tracks the accepted connections of [Server], which [http.Server.Shutdown] not waits for once hijacked.
+	trackedListener struct {
+		net.Listener
+		s *Server
+	}
+	trackedConn struct {
+		net.Conn
+		s    *Server
+		once sync.Once
+	}
+	// Servers run several named servers under one signal handler
+	Servers struct {
+		Signals []os.Signal //default SIGINT and SIGTERM
+		servers []*Server
+	}
+	serverKey struct{}
+)
+
+// ErrServerStarted the server is already started
+var ErrServerStarted = errors.New("server already started")
+
+/*
+NewServer create [Server] from config.
+
+HOCON sample:
+
+	{
+	address: "0.0.0.0:8080" # default address to listen with
+	writeTimeout: 30s
+	readTimeout: 30s
+	idleTimeout: 60s
+	keepAlive: false
+	shutdownTimeout: 30s
+	tls{ cert: "server.crt", key: "server.key" } # see NewTransport for transport options
+	}
+*/
+func NewServer(name string, h http.Handler, c conf.Config) (s *Server, err error) {
+	if c == nil {
+		c = conf.Empty()
+	}
+	s = &Server{Name: name, Server: new(http.Server), draining: make(chan struct{}), done: make(chan struct{})}
+	if s.Transport, err = NewTransport(c); err != nil {
+		return nil, err
+	}
+	s.Handler = h
+	s.WriteTimeout = c.GetTimeDuration("writeTimeout", time.Second*30)
+	s.ReadTimeout = c.GetTimeDuration("readTimeout", time.Second*30)
+	s.IdleTimeout = c.GetTimeDuration("idleTimeout", time.Second*60)
+	s.ShutdownTimeout = c.GetTimeDuration("shutdownTimeout", time.Second*30)
+	s.ErrorLog = log.Default()
+	s.SetKeepAlivesEnabled(c.GetBoolean("keepAlive", false))
+	return
+}
+
+// ServerOf fetch the [Server] which serving the request
+func ServerOf(ctx context.Context) *Server {
+	s, _ := ctx.Value(serverKey{}).(*Server)
+	return s
+}
+
+// Draining returns a channel closed when the server of the request starts shutdown.
+// Long-running handlers like SSE should finish when it closed. The channel is nil when not served by [Server].
+func Draining(ctx context.Context) <-chan struct{} {
+	if s := ServerOf(ctx); s != nil {
+		return s.draining
+	}
+	return nil
+}
+
+// OnBeforeShutdown add hook called before draining
+func (s *Server) OnBeforeShutdown(fn func(ctx context.Context)) *Server {
+	s.before = append(s.before, fn)
+	return s
+}
+
+// OnAfterShutdown add hook called after all connections closed
+func (s *Server) OnAfterShutdown(fn func(ctx context.Context)) *Server {
+	s.after = append(s.after, fn)
+	return s
+}
+
+// Ready check if the server is serving and not draining
+func (s *Server) Ready() bool {
+	return s.ready.Load()
+}
+
+// SetReady change readiness, such as during warming up. It takes no effect after shutdown.
+func (s *Server) SetReady(ready bool) {
+	select {
+	case <-s.draining:
+	default:
+		s.ready.Store(ready)
+	}
+}
+
+// Done returns a channel closed when the server stopped serving
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait until the server stopped, returns the serving error
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+func (l trackedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	t := &trackedConn{Conn: c, s: l.s}
+	l.s.connLock.Lock()
+	l.s.conns[t] = struct{}{}
+	l.s.connLock.Unlock()
+	return t, nil
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		s := c.s
+		s.connLock.Lock()
+		delete(s.conns, c)
+		if len(s.conns) == 0 && s.idle != nil {
+			close(s.idle)
+			s.idle = nil
+		}
+		s.connLock.Unlock()
+	})
+	return err
+}
+
+// ReadFrom keeps sendfile of the underlying connection
+func (c *trackedConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+// waitConns wait for all tracked connections closed, the remaining are closed when ctx done.
+func (s *Server) waitConns(ctx context.Context) error {
+	s.connLock.Lock()
+	if len(s.conns) == 0 {
+		s.connLock.Unlock()
+		return nil
+	}
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+	}
+	idle := s.idle
+	s.connLock.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		s.connLock.Lock()
+		n := len(s.conns)
+		for c := range s.conns {
+			_ = c.Conn.Close()
+		}
+		s.connLock.Unlock()
+		conf.Internal().Errorf("http %s server forcibly closed %d hijacked connections", s.Name, n)
+		return ctx.Err()
+	}
+}
+
+// Addr the listening address, nil when not started
+func (s *Server) Addr() net.Addr {
+	if l := s.listener.Load(); l != nil {
+		return l.Addr()
+	}
+	return nil
+}
+
+// Start listen and serve in background, the listening error is returned directly.
+// The server is shutdown when ctx is done, ctx is optional.
+func (s *Server) Start(ctx context.Context) (err error) {
+	if !s.started.CompareAndSwap(false, true) {
+		return ErrServerStarted
+	}
+	var l net.Listener
+	if err = s.Transport.Configure(s.Server); err == nil {
+		l, err = s.Transport.Listen()
+	}
+	if err != nil {
+		s.err = err
+		close(s.done)
+		return
+	}
+	s.conns = map[*trackedConn]struct{}{}
+	tracked := &trackedListener{Listener: l, s: s}
+	s.listener.Store(tracked)
+	l = tracked
+	base := s.BaseContext
+	s.BaseContext = func(l net.Listener) context.Context {
+		c := context.Background()
+		if base != nil {
+			c = base(l)
+		}
+		return context.WithValue(c, serverKey{}, s)
+	}
+	s.ready.Store(true)
+	conf.Internal().Infof("http %s server listen %s", s.Name, s.Transport)
+	go func() {
+		defer close(s.done)
+		if err := s.Transport.ServeListener(s.Server, l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.err = err
+			conf.Internal().Errorf("http %s server serving %+v", s.Name, err)
+		}
+		s.ready.Store(false)
+	}()
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = s.Shutdown(context.Background())
+			case <-s.done:
+			}
+		}()
+	}
+	return
+}
+
+// Shutdown gracefully, it is safe to call multiple times and returns the result of the first call.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.once.Do(func() {
+		s.ready.Store(false)
+		if _, ok := ctx.Deadline(); !ok && s.ShutdownTimeout > 0 {
+			var cc context.CancelFunc
+			ctx, cc = context.WithTimeout(ctx, s.ShutdownTimeout)
+			defer cc()
+		}
+		for _, fn := range s.before {
+			fn(ctx)
+		}
+		close(s.draining)
+		if s.started.CompareAndSwap(false, true) {
+			close(s.done) //never started
+		} else if s.shutdownErr = s.Server.Shutdown(ctx); s.shutdownErr != nil {
+			conf.Internal().Errorf("http %s server shutting down %+v", s.Name, s.shutdownErr)
+			_ = s.Server.Close()
+		}
+		<-s.done
+		if s.conns != nil {
+			if err := s.waitConns(ctx); s.shutdownErr == nil {
+				s.shutdownErr = err
+			}
+		}
+		if s.shutdownErr == nil {
+			conf.Internal().Infof("http %s server shutdown success", s.Name)
+		}
+		for _, fn := range s.after {
+			fn(ctx)
+		}
+	})
+	return s.shutdownErr
+}
+
+// NewServers create [Servers]
+func NewServers(servers ...*Server) *Servers {
+	return &Servers{servers: servers}
+}
+
+// Add servers
+func (g *Servers) Add(servers ...*Server) *Servers {
+	g.servers = append(g.servers, servers...)
+	return g
+}
+
+// Get server by name
+func (g *Servers) Get(name string) *Server {
+	for _, s := range g.servers {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Ready check if all servers are ready
+func (g *Servers) Ready() bool {
+	for _, s := range g.servers {
+		if !s.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// Shutdown all servers concurrently
+func (g *Servers) Shutdown(ctx context.Context) error {
+	errs := make([]error, len(g.servers))
+	var wg sync.WaitGroup
+	for i, s := range g.servers {
+		wg.Add(1)
+		go func(i int, s *Server) {
+			defer wg.Done()
+			errs[i] = s.Shutdown(ctx)
+		}(i, s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Run start all servers and block until ctx done, a signal received or any server stopped, then shutdown all servers.
+func (g *Servers) Run(ctx context.Context) error {
+	signals := g.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	ctx, stop := signal.NotifyContext(ctx, signals...)
+	defer stop()
+	stopped := make(chan struct{}, len(g.servers))
+	for _, s := range g.servers {
+		if err := s.Start(nil); err != nil {
+			return errors.Join(err, g.Shutdown(context.Background()))
+		}
+		go func(s *Server) {
+			<-s.Done()
+			stopped <- struct{}{}
+		}(s)
+	}
+	select {
+	case <-ctx.Done():
+	case <-stopped:
+	}
+	err := g.Shutdown(context.Background())
+	for _, s := range g.servers {
+		err = errors.Join(err, s.Wait())
+	}
+	return err
+}
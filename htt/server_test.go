@@ -0,0 +1,273 @@
+package htt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// eventLog records events in order
+type eventLog struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (e *eventLog) add(v string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.events = append(e.events, v)
+}
+func (e *eventLog) get() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return slices.Clone(e.events)
+}
+
+func localServer(t *testing.T, name string, h http.Handler, timeout string) *Server {
+	s, err := NewServer(name, h, conf.NewConfig(hocon.ParseString(`address: "127.0.0.1:0", shutdownTimeout: `+timeout)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestServerLifecycle(t *testing.T) {
+	var s *Server
+	s = localServer(t, "main", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ServerOf(r.Context()) != s || Draining(r.Context()) == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}), "1s")
+	events := new(eventLog)
+	s.OnBeforeShutdown(func(ctx context.Context) {
+		if s.Ready() || closed(s.draining) {
+			t.Error("before hook should run after not ready and before draining")
+		}
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("shutdown timeout not applied")
+		}
+		events.add("before")
+	}).OnAfterShutdown(func(ctx context.Context) {
+		if !closed(s.Done()) {
+			t.Error("after hook should run after stopped")
+		}
+		events.add("after")
+	})
+	if s.Addr() != nil {
+		t.Fatal("address before start")
+	}
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(nil); !errors.Is(err, ErrServerStarted) {
+		t.Fatal(err)
+	}
+	if !s.Ready() {
+		t.Fatal("not ready after start")
+	}
+	s.SetReady(false)
+	if s.Ready() {
+		t.Fatal("set ready")
+	}
+	s.SetReady(true)
+	res, err := http.Get("http://" + s.Addr().String())
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatal(res, err)
+	}
+	_ = res.Body.Close()
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := events.get(); !slices.Equal(v, []string{"before", "after"}) {
+		t.Fatalf("hooks %v", v)
+	}
+	s.SetReady(true)
+	if s.Ready() || s.Wait() != nil {
+		t.Fatal("ready after shutdown")
+	}
+	idle := localServer(t, "idle", http.NotFoundHandler(), "1s")
+	idle.OnAfterShutdown(func(ctx context.Context) { events.add("idle") })
+	if err = idle.Shutdown(context.Background()); err != nil || !closed(idle.Done()) || !slices.Contains(events.get(), "idle") {
+		t.Fatal("shutdown never started server", err)
+	}
+	ctx, cc := context.WithCancel(context.Background())
+	auto := localServer(t, "auto", http.NotFoundHandler(), "1s")
+	if err = auto.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cc()
+	select {
+	case <-auto.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not shutdown by context")
+	}
+}
+
+// hijackServer a server hijacks every request, the connection is closed after draining and delay, negative delay never close.
+func hijackServer(t *testing.T, delay time.Duration, timeout string) (*Server, *atomic.Bool) {
+	var released atomic.Bool
+	s := localServer(t, "ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+		if delay < 0 {
+			return
+		}
+		go func() {
+			<-Draining(r.Context())
+			time.Sleep(delay)
+			released.Store(true)
+			_ = c.Close()
+		}()
+	}), timeout)
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	return s, &released
+}
+
+func upgrade(t *testing.T, s *Server) *bufio.Reader {
+	c, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	_, _ = c.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n"))
+	r := bufio.NewReader(c)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal(res, err)
+	}
+	return r
+}
+
+func TestServerShutdownHijacked(t *testing.T) {
+	s, released := hijackServer(t, 50*time.Millisecond, "1s")
+	r := upgrade(t, s)
+	var after atomic.Bool
+	s.OnAfterShutdown(func(ctx context.Context) { after.Store(released.Load()) })
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !after.Load() {
+		t.Fatal("shutdown not wait for hijacked connection")
+	}
+	if _, err := r.ReadByte(); err == nil {
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestServerShutdownHijackedTimeout(t *testing.T) {
+	s, _ := hijackServer(t, -1, "100ms")
+	r := upgrade(t, s)
+	begin := time.Now()
+	if err := s.Shutdown(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d < 100*time.Millisecond || d > time.Second {
+		t.Fatalf("shutdown took %s", d)
+	}
+	if _, err := r.ReadByte(); err == nil {
+		t.Fatal("hijacked connection not forcibly closed")
+	}
+}
+
+func runServers(t *testing.T, g *Servers, ctx context.Context) chan error {
+	done := make(chan error, 1)
+	go func() { done <- g.Run(ctx) }()
+	for i := 0; !g.Ready(); i++ {
+		if i > 100 {
+			t.Fatal("servers not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return done
+}
+
+func waitRun(t *testing.T, done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("run not returned")
+		return nil
+	}
+}
+
+func TestServersRun(t *testing.T) {
+	events := new(eventLog)
+	hooked := func(name string) *Server {
+		s := localServer(t, name, http.NotFoundHandler(), "1s")
+		s.OnBeforeShutdown(func(ctx context.Context) { events.add(name + ".before") })
+		s.OnAfterShutdown(func(ctx context.Context) { events.add(name + ".after") })
+		return s
+	}
+	ctx, cc := context.WithCancel(context.Background())
+	g := NewServers(hooked("a")).Add(hooked("b"))
+	if g.Get("b") == nil || g.Get("c") != nil {
+		t.Fatal("get by name")
+	}
+	done := runServers(t, g, ctx)
+	cc()
+	if err := waitRun(t, done); err != nil {
+		t.Fatal(err)
+	}
+	v := events.get()
+	for _, n := range []string{"a", "b"} {
+		if i, j := slices.Index(v, n+".before"), slices.Index(v, n+".after"); i < 0 || j < i {
+			t.Fatalf("hooks of %s: %v", n, v)
+		}
+	}
+	if g.Ready() {
+		t.Fatal("ready after run")
+	}
+
+	//! one server stopped shutdown the others
+	g = NewServers(hooked("c"), hooked("d"))
+	done = runServers(t, g, context.Background())
+	_ = g.Get("c").Shutdown(context.Background())
+	if err := waitRun(t, done); err != nil || !closed(g.Get("d").Done()) {
+		t.Fatal("other servers not shutdown", err)
+	}
+
+	g = NewServers(hooked("e"))
+	g.Signals = []os.Signal{syscall.SIGUSR1}
+	done = runServers(t, g, context.Background())
+	_ = syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	if err := waitRun(t, done); err != nil {
+		t.Fatal(err)
+	}
+
+	bad, _ := NewServer("bad", http.NotFoundHandler(), conf.NewConfig(hocon.ParseString(`address: "256.0.0.1:0"`)))
+	g = NewServers(hooked("f"), bad)
+	if err := g.Run(context.Background()); err == nil || !closed(g.Get("f").Done()) || !slices.Contains(events.get(), "f.after") {
+		t.Fatal("start failure should shutdown started servers", err)
+	}
+}
@@ -0,0 +1,301 @@
+package htt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/ZenLiuCN/gofra/modeler"
+	"github.com/ZenLiuCN/gofra/units"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// RevocationStore records revoked token ids (jti) and session families (sid) until they expire.
+	// The ids are prefixed by "jti:" or "sid:", so a token id never collides with a session id.
+	RevocationStore interface {
+		// Revoke the id until the time, returns true only when the id is not revoked before.
+		Revoke(ctx context.Context, id string, until time.Time) (first bool, err error)
+		// Revoked check if the id is revoked
+		Revoked(ctx context.Context, id string) (bool, error)
+	}
+	// SessionClaims claims of tokens issued by [SessionIssuer]
+	SessionClaims struct {
+		jwt.RegisteredClaims
+		Session string         `json:"sid"`           //the family of tokens derived from one login
+		Type    string         `json:"typ"`           //access or refresh
+		Data    map[string]any `json:"dat,omitempty"` //extra data carried to refreshed tokens
+	}
+	// TokenPair the issued tokens
+	TokenPair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"` //seconds of access token to live
+	}
+	// SessionIssuer issue access and refresh tokens with rotation.
+	//
+	// Each refresh revokes the used refresh token and issues a new pair in the same session.
+	// Presenting a revoked refresh token again is treated as token theft, the whole session is revoked,
+	// which invalidates all access and refresh tokens of it when the [Tokenizer] has the same [RevocationStore].
+	SessionIssuer struct {
+		Tokenizer  *Tokenizer
+		Store      RevocationStore
+		AccessTTL  time.Duration //default 15 minutes
+		RefreshTTL time.Duration //default 7 days
+		Issuer     string        //optional issuer, default is issuer of Tokenizer
+		Audience   []string      //optional audiences, default is audiences of Tokenizer
+	}
+	memoryRevocation struct {
+		lock  sync.Mutex
+		cache units.Cache[string, time.Time]
+	}
+	// SqlRevocation a [RevocationStore] with a sql table, which requires columns:
+	//
+	//	CREATE TABLE jwt_revocation (jti VARCHAR(128) PRIMARY KEY, expire_at TIMESTAMP NOT NULL);
+	//
+	// Only standard INSERT, SELECT and DELETE are used, so it works with any dialect supported by the [modeler.Executor]:
+	// a duplicate id is detected by the primary key violation of the insert.
+	SqlRevocation struct {
+		Executor modeler.Executor
+		Table    string //default jwt_revocation
+	}
+)
+
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+const (
+	revokedToken   = "jti:"
+	revokedSession = "sid:"
+)
+
+var (
+	// ErrTokenRevoked the token or it's session is revoked
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrTokenReused the refresh token is used more than once, the session is revoked
+	ErrTokenReused = errors.New("refresh token reused")
+	// ErrTokenType the token is not the expected type
+	ErrTokenType = errors.New("token type mismatch")
+)
+
+// DefaultTokenizer the [Tokenizer] configured by [ConfigJwt]
+func DefaultTokenizer() *Tokenizer {
+	return tokenizer
+}
+
+// NewMemoryRevocation create an in memory [RevocationStore], ttl should not less than the refresh token lifetime.
+func NewMemoryRevocation(ttl time.Duration) RevocationStore {
+	c := units.NewCache[string, time.Time](time.Minute, ttl, units.SECONDS)
+	c.StartKeeping()
+	return &memoryRevocation{cache: c}
+}
+
+func (m *memoryRevocation) Revoke(ctx context.Context, id string, until time.Time) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if v, ok := m.cache.Get(id); ok && v.After(time.Now()) {
+		return false, nil
+	}
+	m.cache.Put(id, until)
+	return true, nil
+}
+
+func (m *memoryRevocation) Revoked(ctx context.Context, id string) (bool, error) {
+	v, ok := m.cache.Get(id)
+	return ok && v.After(time.Now()), nil
+}
+
+func (s SqlRevocation) table() string {
+	if s.Table == "" {
+		return "jwt_revocation"
+	}
+	return s.Table
+}
+
+func (s SqlRevocation) Revoke(ctx context.Context, id string, until time.Time) (bool, error) {
+	_, err := s.Executor.Execute(ctx, "INSERT INTO "+s.table()+" (jti, expire_at) VALUES (:jti, :expire_at)",
+		map[string]any{"jti": id, "expire_at": until.UTC()})
+	if err == nil {
+		return true, nil
+	}
+	//! the insert failed, it's a duplicate only when the record exists
+	if n, e := s.count(ctx, id, time.Time{}); e != nil || n == 0 {
+		return false, err
+	}
+	return false, nil
+}
+
+func (s SqlRevocation) Revoked(ctx context.Context, id string) (bool, error) {
+	n, err := s.count(ctx, id, time.Now())
+	return n > 0, err
+}
+
+// count records of id which expire after the time
+func (s SqlRevocation) count(ctx context.Context, id string, after time.Time) (int64, error) {
+	var row struct {
+		N int64 `db:"n"`
+	}
+	err := s.Executor.QueryOne(ctx, &row, "SELECT COUNT(*) AS n FROM "+s.table()+" WHERE jti = :jti AND expire_at > :now",
+		map[string]any{"jti": id, "now": after.UTC()})
+	return row.N, err
+}
+
+// Purge remove expired records
+func (s SqlRevocation) Purge(ctx context.Context) (int64, error) {
+	r, err := s.Executor.Execute(ctx, "DELETE FROM "+s.table()+" WHERE expire_at <= :now", map[string]any{"now": time.Now().UTC()})
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *SessionIssuer) ttl() (access, refresh time.Duration) {
+	access, refresh = s.AccessTTL, s.RefreshTTL
+	if access <= 0 {
+		access = 15 * time.Minute
+	}
+	if refresh <= 0 {
+		refresh = 7 * 24 * time.Hour
+	}
+	return
+}
+
+func (s *SessionIssuer) claims(subject, session, kind string, data map[string]any, ttl time.Duration, now time.Time) (c *SessionClaims, err error) {
+	c = &SessionClaims{Session: session, Type: kind, Data: data}
+	if c.ID, err = randomID(); err != nil {
+		return nil, err
+	}
+	c.Subject = subject
+	if c.Issuer = s.Issuer; c.Issuer == "" {
+		c.Issuer = s.Tokenizer.Issuer
+	}
+	if c.Audience = s.Audience; len(c.Audience) == 0 {
+		c.Audience = s.Tokenizer.Audience
+	}
+	c.IssuedAt = jwt.NewNumericDate(now)
+	c.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return
+}
+
+func (s *SessionIssuer) pair(subject, session string, data map[string]any) (p TokenPair, err error) {
+	now := time.Now()
+	access, refresh := s.ttl()
+	var c *SessionClaims
+	if c, err = s.claims(subject, session, TokenTypeAccess, data, access, now); err != nil {
+		return
+	}
+	if p.AccessToken, err = s.Tokenizer.Sign(c); err != nil {
+		return
+	}
+	if c, err = s.claims(subject, session, TokenTypeRefresh, data, refresh, now); err != nil {
+		return
+	}
+	if p.RefreshToken, err = s.Tokenizer.Sign(c); err != nil {
+		return
+	}
+	p.TokenType = "Bearer"
+	p.ExpiresIn = int64(access.Seconds())
+	return
+}
+
+// Issue a new session for subject, data is optional extra claims.
+func (s *SessionIssuer) Issue(ctx context.Context, subject string, data map[string]any) (TokenPair, error) {
+	session, err := randomID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return s.pair(subject, session, data)
+}
+
+// Refresh rotate the refresh token to a new pair. The used refresh token is revoked,
+// reuse of a revoked refresh token revokes the whole session and returns [ErrTokenReused].
+func (s *SessionIssuer) Refresh(ctx context.Context, refresh string) (p TokenPair, err error) {
+	c := new(SessionClaims)
+	if _, err = s.Tokenizer.parse(ctx, refresh, c, false); err != nil {
+		return
+	}
+	if c.Type != TokenTypeRefresh {
+		return p, ErrTokenType
+	}
+	_, ttl := s.ttl()
+	var revoked bool
+	if revoked, err = s.Store.Revoked(ctx, revokedSession+c.Session); err != nil {
+		return
+	} else if revoked {
+		return p, ErrTokenRevoked
+	}
+	var first bool
+	if first, err = s.Store.Revoke(ctx, revokedToken+c.ID, c.ExpiresAt.Time); err != nil {
+		return
+	}
+	if !first {
+		if _, err = s.Store.Revoke(ctx, revokedSession+c.Session, time.Now().Add(ttl)); err != nil {
+			return
+		}
+		return p, ErrTokenReused
+	}
+	return s.pair(c.Subject, c.Session, c.Data)
+}
+
+// Logout revoke the session of the token, which can be either access or refresh token.
+func (s *SessionIssuer) Logout(ctx context.Context, token string) error {
+	c := new(SessionClaims)
+	if _, err := s.Tokenizer.parse(ctx, token, c, false); err != nil {
+		return err
+	}
+	_, ttl := s.ttl()
+	_, err := s.Store.Revoke(ctx, revokedSession+c.Session, time.Now().Add(ttl))
+	return err
+}
+
+type tokenIDs struct {
+	Jti string `json:"jti"`
+	Sid string `json:"sid"`
+	Typ string `json:"typ"`
+}
+
+// readTokenIDs read jti, sid and typ from the payload of a verified token
+func readTokenIDs(raw string) (v tokenIDs) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+		_ = json.Unmarshal(b, &v)
+	}
+	return
+}
+
+// checkRevoked check the jti and sid of token against [Tokenizer.Revocation]
+func (t *Tokenizer) checkRevoked(ctx context.Context, tk *jwt.Token) error {
+	if t.Revocation == nil {
+		return nil
+	}
+	v := readTokenIDs(tk.Raw)
+	for _, id := range [...][2]string{{revokedToken, v.Jti}, {revokedSession, v.Sid}} {
+		if id[1] == "" {
+			continue
+		}
+		if ok, err := t.Revocation.Revoked(ctx, id[0]+id[1]); err != nil {
+			return err
+		} else if ok {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
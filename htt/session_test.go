@@ -0,0 +1,166 @@
+package htt
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tableExecutor a [modeler.Executor] over an in memory table, which only understands statements of [SqlRevocation]
+type tableExecutor struct {
+	lock sync.Mutex
+	rows map[string]time.Time
+}
+
+var errDuplicateKey = errors.New("duplicate key")
+
+func (e *tableExecutor) QueryOne(ctx context.Context, out any, q string, args map[string]any) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !strings.HasPrefix(q, "SELECT COUNT(*) AS n FROM jwt_revocation WHERE") {
+		return errors.New("unexpected query " + q)
+	}
+	var n int64
+	if v, ok := e.rows[args["jti"].(string)]; ok && v.After(args["now"].(time.Time)) {
+		n = 1
+	}
+	reflect.ValueOf(out).Elem().Field(0).SetInt(n)
+	return nil
+}
+
+func (e *tableExecutor) Execute(ctx context.Context, q string, args map[string]any) (sql.Result, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	switch {
+	case strings.HasPrefix(q, "INSERT INTO jwt_revocation "):
+		id := args["jti"].(string)
+		if _, ok := e.rows[id]; ok {
+			return nil, errDuplicateKey
+		}
+		e.rows[id] = args["expire_at"].(time.Time)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(q, "DELETE FROM jwt_revocation "):
+		var n int64
+		for k, v := range e.rows {
+			if !v.After(args["now"].(time.Time)) {
+				delete(e.rows, k)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, errors.New("unexpected statement " + q)
+}
+
+func (e *tableExecutor) Close(ctx context.Context) bool {
+	return true
+}
+
+func TestRevocationStores(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range map[string]RevocationStore{
+		"memory": NewMemoryRevocation(time.Hour),
+		"sql":    SqlRevocation{Executor: &tableExecutor{rows: map[string]time.Time{}}},
+	} {
+		until := time.Now().Add(time.Hour)
+		if ok, err := s.Revoked(ctx, "a"); ok || err != nil {
+			t.Fatalf("%s: revoked before revoke: %v %v", name, ok, err)
+		}
+		if first, err := s.Revoke(ctx, "a", until); !first || err != nil {
+			t.Fatalf("%s: first revoke: %v %v", name, first, err)
+		}
+		if first, err := s.Revoke(ctx, "a", until); first || err != nil {
+			t.Fatalf("%s: second revoke: %v %v", name, first, err)
+		}
+		if ok, err := s.Revoked(ctx, "a"); !ok || err != nil {
+			t.Fatalf("%s: not revoked: %v %v", name, ok, err)
+		}
+		if _, err := s.Revoke(ctx, "b", time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(name, err)
+		}
+		if ok, _ := s.Revoked(ctx, "b"); ok {
+			t.Fatalf("%s: expired record revoked", name)
+		}
+	}
+	store := SqlRevocation{Executor: &tableExecutor{rows: map[string]time.Time{}}}
+	_, _ = store.Revoke(ctx, "old", time.Now().Add(-time.Second))
+	_, _ = store.Revoke(ctx, "new", time.Now().Add(time.Hour))
+	if n, err := store.Purge(ctx); n != 1 || err != nil {
+		t.Fatalf("purge %d %v", n, err)
+	}
+	failing := SqlRevocation{Executor: &tableExecutor{}, Table: "missing"}
+	if _, err := failing.Revoke(ctx, "a", time.Now()); err == nil {
+		t.Fatal("expect insert error")
+	}
+}
+
+func TestSessionIssuer(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	store := NewMemoryRevocation(time.Hour)
+	tk := &Tokenizer{Revocation: store}
+	tk.AddKey(&JwtKey{Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret})
+	s := &SessionIssuer{Tokenizer: tk, Store: store}
+	ctx := context.Background()
+	p, err := s.Issue(ctx, "alice", map[string]any{"role": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Refresh(ctx, p.AccessToken); !errors.Is(err, ErrTokenType) {
+		t.Fatalf("refresh by access token: %v", err)
+	}
+	next, err := s.Refresh(ctx, p.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(SessionClaims)
+	if _, err = tk.Verify(next.AccessToken, c); err != nil || c.Subject != "alice" || c.Data["role"] != "admin" {
+		t.Fatalf("refreshed token: %+v %v", c, err)
+	}
+	if _, err = s.Refresh(ctx, p.RefreshToken); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("reuse: %v", err)
+	}
+	for _, v := range []string{p.AccessToken, next.AccessToken} {
+		if _, err = tk.VerifyContext(ctx, v, nil); !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("session not revoked after reuse: %v", err)
+		}
+	}
+	if _, err = s.Refresh(ctx, next.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("refresh in revoked session: %v", err)
+	}
+	other, _ := s.Issue(ctx, "bob", nil)
+	if err = s.Logout(ctx, other.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tk.VerifyContext(ctx, other.RefreshToken, nil); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("logout: %v", err)
+	}
+}
+
+func TestRevocationKeySpace(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	store := NewMemoryRevocation(time.Hour)
+	tk := &Tokenizer{Revocation: store}
+	tk.AddKey(&JwtKey{Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret})
+	ctx := context.Background()
+	s, _ := tk.Sign(&SessionClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "same", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}, Session: "same"})
+	_, _ = store.Revoke(ctx, "same", time.Now().Add(time.Hour))
+	if _, err := tk.VerifyContext(ctx, s, nil); err != nil {
+		t.Fatalf("unprefixed id revoked token: %v", err)
+	}
+	_, _ = store.Revoke(ctx, revokedSession+"same", time.Now().Add(time.Hour))
+	if _, err := tk.VerifyContext(ctx, s, nil); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("session: %v", err)
+	}
+	s2, _ := tk.Sign(&SessionClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "x", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}, Session: "y"})
+	_, _ = store.Revoke(ctx, revokedToken+"x", time.Now().Add(time.Hour))
+	if _, err := tk.VerifyContext(ctx, s2, nil); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("token: %v", err)
+	}
+}
@@ -1,26 +1,133 @@
 package htt
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/ZenLiuCN/ote"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Spa [2]string{wwwRoot,indexFile}
+//
+// Deprecated: use [NewSpa] with [os.DirFS] or [embed.FS], which supports caching and precompressed assets.
 type Spa [2]string
 
+type (
+	// SpaHandler serves single page application from [fs.FS].
+	//
+	//   - paths without file extension which not exist are served with the index page, missing assets are 404.
+	//   - the index page is no-cache, and its placeholder is replaced with runtime config, see [SpaHandler.Config].
+	//   - hashed assets (like app-3f2a9c1b.js) are cached as immutable, others are revalidated by ETag.
+	//   - precompressed variants (.br, .gz) are served when accepted by the client.
+	SpaHandler struct {
+		FS          fs.FS
+		Prefix      string                    //mount prefix to strip from url path, optional
+		Static      bool                      //serve files only, without index fallback
+		Listing     bool                      //list directories without index file, only for static
+		Exclude     []string                  //url path prefixes never served, such as /api, which responds 404 problem+json
+		Index       string                    //index file, default index.html
+		Placeholder string                    //placeholder in index to replace with config script, default <!--spa:config-->
+		Variable    string                    //global variable of the config, default __SPA_CONFIG__
+		Config      func(r *http.Request) any //runtime config injected into index page, optional
+		Immutable   func(name string) bool    //check if the asset is content hashed, default [HashedAsset]
+		MaxAge      time.Duration             //max age of assets not hashed, default 0 means always revalidate
+		Attributes  []attribute.KeyValue      //attributes of telemetry span
+		etags       sync.Map                  //name => spaEtag
+	}
+	spaEtag struct {
+		mod  time.Time
+		size int64
+		tag  string
+	}
+)
+
 var (
 	scope ote.TelemetryProviderFn = func() (scope string, opt []trace.SpanStartOption) {
 		return "spa", nil
 	}
+	spaHandlers sync.Map //Spa => *SpaHandler
+	hashedExpr  = regexp.MustCompile(`[.-]([A-Za-z0-9_]{8,})\.[A-Za-z0-9]+$`)
 )
 
+// HashedAsset check if file name contains content hash, such as app.3f2a9c1b.js or index-BXr3f2Aa.css
+func HashedAsset(name string) bool {
+	m := hashedExpr.FindStringSubmatch(path.Base(name))
+	return m != nil && strings.ContainsAny(m[1], "0123456789")
+}
+
+// NewSpa create [SpaHandler], index default is index.html
+func NewSpa(fsys fs.FS, index string) *SpaHandler {
+	if index == "" {
+		index = "index.html"
+	}
+	return &SpaHandler{FS: fsys, Index: index}
+}
+
+// NewStatic create [SpaHandler] serves static files, directories are served with index.html or listed when listing.
+func NewStatic(fsys fs.FS, listing bool) *SpaHandler {
+	return &SpaHandler{FS: fsys, Index: "index.html", Static: true, Listing: listing}
+}
+
+// clone shallow copy of the settings, attributes are copied and etags are not shared
+func (h *SpaHandler) clone() *SpaHandler {
+	return &SpaHandler{
+		FS:          h.FS,
+		Prefix:      h.Prefix,
+		Static:      h.Static,
+		Listing:     h.Listing,
+		Exclude:     h.Exclude,
+		Index:       h.Index,
+		Placeholder: h.Placeholder,
+		Variable:    h.Variable,
+		Config:      h.Config,
+		Immutable:   h.Immutable,
+		MaxAge:      h.MaxAge,
+		Attributes:  slices.Clone(h.Attributes),
+	}
+}
+
+// excluded check if url path under excluded prefixes
+func (h *SpaHandler) excluded(p string) bool {
+	for _, x := range h.Exclude {
+		x = strings.TrimSuffix(x, "/")
+		if p == x || strings.HasPrefix(p, x+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (h Spa) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v, ok := spaHandlers.Load(h)
+	if !ok {
+		s := NewSpa(os.DirFS(h[0]), h[1])
+		s.Attributes = []attribute.KeyValue{attribute.String("folder", h[0])}
+		v, _ = spaHandlers.LoadOrStore(h, s)
+	}
+	v.(*SpaHandler).ServeHTTP(w, r)
+}
+
+func (h *SpaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if t, cx := ote.ByContext(r.Context(), scope); t != nil {
-		cx, span := t.StartSpan("spa", cx, attribute.String("folder", h[0]), attribute.String("index", h[1]))
+		cx, span := t.StartSpan("spa", cx, append([]attribute.KeyValue{attribute.String("index", h.Index)}, h.Attributes...)...)
 		defer func() {
 			defer span.End()
 			if r, ok := t.HandleRecover(recover()); ok {
@@ -29,15 +136,223 @@ func (h Spa) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}()
 		r = r.WithContext(cx)
 	}
-	path := filepath.Join(h[0], r.URL.Path)
-	fi, err := os.Stat(path)
-	if os.IsNotExist(err) || fi.IsDir() {
-		http.ServeFile(w, r, filepath.Join(h[0], h[1])) // when not found or is dir,redirect to index
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	p := r.URL.Path
+	if h.excluded(p) {
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+	if h.Prefix != "" {
+		var ok bool
+		if p, ok = strings.CutPrefix(p, strings.TrimSuffix(h.Prefix, "/")); !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	name, ok := spaName(p)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.Static && (name == "" || name == h.Index) {
+		h.serveIndex(w, r)
+		return
+	}
+	if name == "" {
+		name = "."
+	}
+	fi, err := fs.Stat(h.FS, name)
+	switch {
+	case err == nil && !fi.IsDir():
+		h.serveAsset(w, r, name, fi)
+	case err == nil && h.Static:
+		h.serveDir(w, r, name)
+	case !h.Static && (err == nil || errors.Is(err, fs.ErrNotExist)):
+		if ext := path.Ext(name); ext != "" && ext != ".html" {
+			http.NotFound(w, r) //missing asset should not fallback to index
+			return
+		}
+		h.serveIndex(w, r)
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// serveDir serve index file of directory, or list it when enabled
+func (h *SpaHandler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		u := *r.URL
+		u.Path += "/"
+		http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+		return
+	}
+	idx := path.Join(name, h.Index)
+	if fi, err := fs.Stat(h.FS, idx); err == nil && !fi.IsDir() {
+		h.serveAsset(w, r, idx, fi)
+		return
+	}
+	if !h.Listing {
+		http.NotFound(w, r)
+		return
+	}
+	entries, err := fs.ReadDir(h.FS, name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	hd := w.Header()
+	hd.Set("Content-Type", "text/html; charset=utf-8")
+	hd.Set("Cache-Control", "no-cache")
+	title := html.EscapeString(r.URL.Path)
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta charset=\"utf-8\">\n<title>Index of " + title + "</title>\n<h1>Index of " + title + "</h1>\n<ul>\n")
+	if name != "." {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, e := range entries {
+		n := e.Name()
+		if strings.HasPrefix(n, ".") {
+			continue //hidden files
+		}
+		if e.IsDir() {
+			n += "/"
+		}
+		b.WriteString("<li><a href=\"./" + (&url.URL{Path: n}).EscapedPath() + "\">" + html.EscapeString(n) + "</a></li>\n")
+	}
+	b.WriteString("</ul>\n")
+	if r.Method != http.MethodHead {
+		_, _ = io.WriteString(w, b.String())
+	}
+}
+
+// spaName convert url path to name of fs, rejects invalid paths
+func spaName(p string) (string, bool) {
+	if strings.ContainsAny(p, "\\\x00") {
+		return "", false
+	}
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "", true
+	}
+	return name, fs.ValidPath(name)
+}
+
+func (h *SpaHandler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	b, err := fs.ReadFile(h.FS, h.Index)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if h.Config != nil {
+		if b, err = h.inject(b, h.Config(r)); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	sum := sha256.Sum256(b)
+	hd := w.Header()
+	hd.Set("Content-Type", "text/html; charset=utf-8")
+	hd.Set("Cache-Control", "no-cache")
+	hd.Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	http.ServeContent(w, r, h.Index, time.Time{}, bytes.NewReader(b))
+}
+
+// inject config script at the placeholder, or before </head> when no placeholder
+func (h *SpaHandler) inject(page []byte, cfg any) ([]byte, error) {
+	v, err := json.Marshal(cfg) //html characters are escaped
+	if err != nil {
+		return nil, err
+	}
+	variable, placeholder := h.Variable, h.Placeholder
+	if variable == "" {
+		variable = "__SPA_CONFIG__"
+	}
+	if placeholder == "" {
+		placeholder = "<!--spa:config-->"
+	}
+	script := []byte("<script>window." + variable + "=" + string(v) + ";</script>")
+	if i := bytes.Index(page, []byte(placeholder)); i >= 0 {
+		return bytes.Join([][]byte{page[:i], script, page[i+len(placeholder):]}, nil), nil
+	}
+	if i := bytes.Index(page, []byte("</head>")); i >= 0 {
+		return bytes.Join([][]byte{page[:i], script, page[i:]}, nil), nil
+	}
+	return page, nil
+}
+
+func (h *SpaHandler) serveAsset(w http.ResponseWriter, r *http.Request, name string, fi fs.FileInfo) {
+	hd := w.Header()
+	immutable := h.Immutable
+	if immutable == nil {
+		immutable = HashedAsset
+	}
+	switch {
+	case immutable(name):
+		hd.Set("Cache-Control", "public, max-age=31536000, immutable")
+	case h.MaxAge > 0:
+		hd.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.MaxAge.Seconds())))
+	default:
+		hd.Set("Cache-Control", "no-cache")
+	}
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		hd.Set("Content-Type", ct)
+	}
+	served := name
+	if ae := r.Header.Get("Accept-Encoding"); ae != "" {
+		for _, enc := range [][2]string{{"br", ".br"}, {"gzip", ".gz"}} {
+			if !accepts(ae, enc[0]) {
+				continue
+			}
+			if x, err := fs.Stat(h.FS, name+enc[1]); err == nil && !x.IsDir() {
+				hd.Set("Content-Encoding", enc[0])
+				served, fi = name+enc[1], x
+				break
+			}
+		}
+		hd.Add("Vary", "Accept-Encoding")
+	}
+	f, err := h.FS.Open(served)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.NotFound(w, r)
 		return
 	}
-	http.FileServer(http.Dir(h[0])).ServeHTTP(w, r)
+	defer f.Close()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		rs = bytes.NewReader(b)
+	}
+	if tag, err := h.etag(served, fi, rs); err == nil {
+		hd.Set("ETag", tag)
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), rs)
+}
+
+// etag of the file content, cached by name, modify time and size
+func (h *SpaHandler) etag(name string, fi fs.FileInfo, rs io.ReadSeeker) (string, error) {
+	if v, ok := h.etags.Load(name); ok {
+		if e := v.(spaEtag); e.mod.Equal(fi.ModTime()) && e.size == fi.Size() {
+			return e.tag, nil
+		}
+	}
+	d := sha256.New()
+	if _, err := io.Copy(d, rs); err != nil {
+		return "", err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	tag := `"` + hex.EncodeToString(d.Sum(nil)[:8]) + `"`
+	h.etags.Store(name, spaEtag{mod: fi.ModTime(), size: fi.Size(), tag: tag})
+	return tag, nil
 }
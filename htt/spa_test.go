@@ -0,0 +1,206 @@
+package htt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func spaFS() fstest.MapFS {
+	mod := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s), ModTime: mod} }
+	return fstest.MapFS{
+		"index.html":             file(`<html><head><!--spa:config--></head><body>app</body></html>`),
+		"app-3f2a9c1b.js":        file(`console.log("hashed")`),
+		"app-3f2a9c1b.js.br":     file(`brotli`),
+		"app-3f2a9c1b.js.gz":     file(`gzip`),
+		"style.css":              file(`body{}`),
+		"docs/index.html":        file(`docs`),
+		"files/a.txt":            file(`a`),
+		"files/.hidden":          file(`hidden`),
+		"files/sub dir/b.txt":    file(`b`),
+		"plain/index.html":       file(`<html><head></head></html>`),
+		"plain/app-noHash.js":    file(`x`),
+		"plain/app.3f2a9c1b.css": file(`x`),
+	}
+}
+
+func spaGet(h http.Handler, path string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHashedAsset(t *testing.T) {
+	for name, want := range map[string]bool{
+		"app-3f2a9c1b.js":           true,
+		"assets/index-BXr3f2Aa.css": true,
+		"app.3f2a9c1b.js":           true,
+		"app.js":                    false,
+		"app-abcdefgh.js":           false, //no digit
+		"app-3f2a.js":               false, //too short
+		"index.html":                false,
+	} {
+		if HashedAsset(name) != want {
+			t.Fatalf("HashedAsset(%s) should be %v", name, want)
+		}
+	}
+}
+
+func TestSpaHandler(t *testing.T) {
+	h := NewSpa(spaFS(), "")
+	h.Exclude = []string{"/api/"}
+	h.Config = func(r *http.Request) any { return map[string]string{"api": "/api", "xss": "</script>"} }
+
+	for _, p := range []string{"/", "/index.html", "/users/1", "/deep/route.html"} {
+		w := spaGet(h, p)
+		body := w.Body.String()
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" || !strings.Contains(body, "<body>app</body>") {
+			t.Fatalf("%s should serve index: %d %q", p, w.Code, body)
+		}
+		if !strings.Contains(body, `<head><script>window.__SPA_CONFIG__={"api":"/api","xss":"\u003c/script\u003e"};</script></head>`) {
+			t.Fatalf("config not injected at placeholder: %q", body)
+		}
+	}
+	etag := spaGet(h, "/").Header().Get("ETag")
+	if w := spaGet(h, "/", "If-None-Match", etag); etag == "" || w.Code != http.StatusNotModified {
+		t.Fatalf("index revalidation %q %d", etag, w.Code)
+	}
+
+	for p, status := range map[string]int{
+		"/missing.js":    http.StatusNotFound,
+		"/api/users":     http.StatusNotFound,
+		"/api":           http.StatusNotFound,
+		"/apis":          http.StatusOK,
+		"/../index.html": http.StatusOK,
+		"/a\\b":          http.StatusNotFound,
+	} {
+		if w := spaGet(h, p); w.Code != status {
+			t.Fatalf("%s status %d, want %d", p, w.Code, status)
+		}
+	}
+	if w := spaGet(h, "/api/users"); w.Header().Get("Content-Type") != ContentTypeProblem {
+		t.Fatalf("excluded should be problem: %s", w.Header().Get("Content-Type"))
+	}
+
+	w := spaGet(h, "/app-3f2a9c1b.js")
+	if w.Body.String() != `console.log("hashed")` || w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatalf("hashed asset %q %v", w.Body.String(), w.Header())
+	}
+	for enc, body := range map[string]string{"gzip, br": "brotli", "gzip": "gzip", "deflate": `console.log("hashed")`} {
+		w = spaGet(h, "/app-3f2a9c1b.js", "Accept-Encoding", enc)
+		if w.Body.String() != body || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("encoding %s served %q %v", enc, w.Body.String(), w.Header())
+		}
+	}
+	if w = spaGet(h, "/app-3f2a9c1b.js", "Accept-Encoding", "br;q=0, gzip"); w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("refused encoding used %v", w.Header())
+	}
+
+	w = spaGet(h, "/style.css")
+	etag = w.Header().Get("ETag")
+	if w.Header().Get("Cache-Control") != "no-cache" || etag == "" {
+		t.Fatalf("plain asset %v", w.Header())
+	}
+	if w = spaGet(h, "/style.css", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("asset revalidation %d", w.Code)
+	}
+	h.MaxAge = time.Hour
+	if w = spaGet(h, "/style.css"); w.Header().Get("Cache-Control") != "public, max-age=3600" {
+		t.Fatalf("max age %v", w.Header())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Fatalf("post %d", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodHead, "/style.css", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("head %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestSpaInject(t *testing.T) {
+	h := NewSpa(spaFS(), "plain/index.html")
+	h.Variable = "CFG"
+	h.Config = func(r *http.Request) any { return r.URL.Path }
+	if w := spaGet(h, "/x"); w.Body.String() != `<html><head><script>window.CFG="/x";</script></head></html>` {
+		t.Fatalf("inject before head %q", w.Body.String())
+	}
+	h.Config = func(r *http.Request) any { return make(chan int) }
+	if w := spaGet(h, "/x"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("invalid config %d", w.Code)
+	}
+	if w := spaGet(NewSpa(spaFS(), "none.html"), "/"); w.Code != http.StatusNotFound {
+		t.Fatalf("missing index %d", w.Code)
+	}
+	h = NewSpa(spaFS(), "")
+	h.Immutable = func(name string) bool { return strings.HasSuffix(name, ".css") }
+	if w := spaGet(h, "/plain/app.3f2a9c1b.css"); !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("custom immutable %v", w.Header())
+	}
+	if w := spaGet(h, "/plain/app-noHash.js"); w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("custom immutable %v", w.Header())
+	}
+}
+
+func TestSpaStatic(t *testing.T) {
+	h := NewStatic(spaFS(), false)
+	h.Prefix = "/static/"
+	for p, status := range map[string]int{
+		"/static/style.css":   http.StatusOK,
+		"/static/docs/":       http.StatusOK,
+		"/static/docs":        http.StatusMovedPermanently,
+		"/static/files/":      http.StatusNotFound, //listing disabled
+		"/static/missing":     http.StatusNotFound,
+		"/static/users/1":     http.StatusNotFound, //no index fallback
+		"/other/style.css":    http.StatusNotFound,
+		"/static/files/a.txt": http.StatusOK,
+	} {
+		if w := spaGet(h, p); w.Code != status {
+			t.Fatalf("%s status %d, want %d", p, w.Code, status)
+		}
+	}
+	if w := spaGet(h, "/static/docs?v=1"); w.Header().Get("Location") != "/static/docs/?v=1" {
+		t.Fatalf("redirect %v", w.Header())
+	}
+	h.Listing = true
+	w := spaGet(h, "/static/files/")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `<a href="./a.txt">a.txt</a>`) || !strings.Contains(body, `<a href="./sub%20dir/">sub dir/</a>`) ||
+		!strings.Contains(body, `<a href="../">`) || strings.Contains(body, ".hidden") {
+		t.Fatalf("listing %d %q", w.Code, body)
+	}
+	if body = spaGet(h, "/static/").Body.String(); strings.Contains(body, `<a href="../">`) {
+		t.Fatalf("root listing has parent %q", body)
+	}
+}
+
+func TestSpaDeprecated(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.html"), []byte("<html>main</html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := Spa{dir, "main.html"}
+	if w := spaGet(h, "/route"); w.Code != http.StatusOK || w.Body.String() != "<html>main</html>" {
+		t.Fatalf("spa %d %q", w.Code, w.Body.String())
+	}
+	v, _ := spaHandlers.Load(h)
+	if w := spaGet(h, "/route"); v == nil || w.Code != http.StatusOK {
+		t.Fatal("handler should be cached")
+	}
+}
@@ -1,20 +1,21 @@
 package htt
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/htt/sse"
 	"io"
-	"net"
 	"net/http"
-	"strconv"
-	"strings"
+	"sync/atomic"
 )
 
 // SSE component to send Server-Send-Event to http client.
 //
 // A SSE can only use once. [SSE.Await] should only call inside [http.Handler]. it will block until all events are done.
 // All send methods may return [io.EOF] if the SSE already shutdown.
+//
+// Deprecated: use [sse.Emitter], SSE is a shim over it, which only maps errors to [io.EOF].
 type SSE interface {
 	Close() error                             //close the SSE, returns [io.EOF] if already closed.
 	Send(data string) error                   //alias of SendEventId.
@@ -26,218 +27,107 @@ type SSE interface {
 	Await(ctx context.Context) error          // Await with optional context inside [http.Handler] , returns [io.EOF] if SSE already closed or the client closed connection.
 	WithOnClose(fn func()) SSE                //set on close hook to called once when Close is called. Only one hook can be set.
 }
-type sse struct {
-	debug   bool
-	ch      chan *msg
-	onClose func()
-	http.ResponseWriter
+type sseShim struct {
+	sse.Emitter
+	closed atomic.Bool //closed by Close or after Await
 }
-type msg struct {
-	ping  bool
-	retry uint32
-	event string
-	id    string
-	data  string
+
+// sseLogger adapts internal logger to [sse.Logger]
+type sseLogger struct {
+	log conf.ILogger
 }
 
-var (
-	bLF    = []byte("\n")
-	bData  = []byte("data: ")
-	bId    = []byte("id: ")
-	bEvent = []byte("event: ")
-	bRetry = []byte("retry: ")
-	bPing  = []byte(": ping\n\n")
-)
+func (l sseLogger) Warn(message string)                { l.log.Warn(message) }
+func (l sseLogger) Warnf(message string, args ...any)  { l.log.Warnf(message, args...) }
+func (l sseLogger) Debug(message string)               { l.log.Info(message) }
+func (l sseLogger) Debugf(message string, args ...any) { l.log.Infof(message, args...) }
+func (l sseLogger) Error(message string)               { l.log.Error(message) }
+func (l sseLogger) Errorf(message string, args ...any) { l.log.Errorf(message, args...) }
 
-func (s *sse) WithOnClose(fn func()) SSE {
-	s.onClose = fn
-	return s
-}
-func (s *sse) Close() error {
-	if s.ch == nil {
+// eof maps closed and connection errors to [io.EOF]
+func eof(err error) error {
+	if errors.Is(err, sse.ErrClosed) || errors.Is(err, sse.ErrConnFailure) {
 		return io.EOF
 	}
-	close(s.ch)
-	s.ch = nil
-	if s.onClose != nil {
-		s.onClose()
-		s.onClose = nil
-	}
-	return nil
+	return err
 }
-func (s *sse) Raw() http.ResponseWriter {
-	return s.ResponseWriter
+
+func (s *sseShim) WithOnClose(fn func()) SSE {
+	s.OnClose(fn)
+	return s
 }
-func (s *sse) Ping() (err error) {
-	return s.send(&msg{ping: true})
+func (s *sseShim) Close() error {
+	s.closed.Store(true)
+	return eof(s.Emitter.Close())
 }
-func (s *sse) SendEventID(id, event, data string) error {
-	return s.send(&msg{id: id, event: event, data: data})
+func (s *sseShim) Ping() error {
+	return eof(s.Emitter.Ping())
 }
-func (s *sse) Retry(mills int) error {
-	return s.send(&msg{retry: uint32(mills)})
+func (s *sseShim) SendEventID(id, event, data string) error {
+	return eof(s.Event(id, event, data))
 }
-
-type flush interface {
-	FlushError() error
+func (s *sseShim) Retry(mills int) error {
+	return eof(s.Emitter.Retry(mills))
 }
-
-func (s *sse) Await(ctx context.Context) (err error) {
-	if s.ch == nil {
-		return io.EOF
-	}
-	defer func() {
-		_ = s.Close()
-	}()
-	if ctx != nil {
-		return s.withContext(ctx)
-	} else {
-		return s.withoutContext()
-	}
+func (s *sseShim) Send(data string) error {
+	return s.SendEventID("", "", data)
 }
-func (s *sse) withoutContext() (err error) {
-	var buf = new(bytes.Buffer)
-	var ok bool
-	var m *msg
-	for {
-		select {
-		case m, ok = <-s.ch:
-			if !ok {
-				if s.debug {
-					conf.Internal().Warn("sse chan closed")
-				}
-				return
-			}
-			err = s.write(m, buf)
-			if err != nil {
-				if s.debug {
-					conf.Internal().Warnf("sse write error %s", err)
-				}
-				return
-			}
-			m = nil
-		}
-	}
+func (s *sseShim) SendEvent(event, data string) error {
+	return s.SendEventID("", event, data)
 }
-func (s *sse) write(m *msg, buf *bytes.Buffer) (err error) {
-	if m.ping {
-		buf.Write(bPing)
-	} else if m.retry > 0 {
-		buf.Write(bRetry)
-		buf.WriteString(strconv.Itoa(int(m.retry)))
-		buf.Write(bLF)
-	} else {
-		if m.id != "" {
-			buf.Write(bId)
-			buf.WriteString(m.id)
-			buf.Write(bLF)
-		}
-		if m.event != "" {
-			buf.Write(bEvent)
-			buf.WriteString(m.event)
-			buf.Write(bLF)
-		}
-		if m.data != "" {
-			data := m.data
-			i := strings.IndexByte(data, '\n')
-			for i >= 0 {
-				buf.Write(bData)
-				buf.WriteString(data[:i])
-				buf.Write(bLF)
-				data = data[i+1:]
-				i = strings.IndexByte(data, '\n')
-			}
-			if len(data) > 0 {
-				buf.Write(bData)
-				buf.WriteString(data)
-				buf.Write(bLF)
-			}
-		}
-		buf.Write(bLF)
-	}
-	_, err = buf.WriteTo(s)
-	if err != nil {
-		return
-	}
-	err = s.ResponseWriter.(flush).FlushError()
-	if err != nil {
-		if _, ok := err.(*net.OpError); ok {
-			return io.EOF
-		}
-		return
+func (s *sseShim) Await(ctx context.Context) error {
+	if s.closed.Swap(true) {
+		return io.EOF
 	}
-	buf.Reset()
-	return
-}
-func (s *sse) withContext(ctx context.Context) (err error) {
-	var buf = new(bytes.Buffer)
-	var ok bool
-	var m *msg
-	for {
-		select {
-		case <-ctx.Done():
-			if s.debug {
-				conf.Internal().Warn("sse context closed")
-			}
-			return
-		case m, ok = <-s.ch:
-			if !ok {
-				if s.debug {
-					conf.Internal().Warn("sse chan closed")
-				}
-				return
-			}
-			err = s.write(m, buf)
-			if err != nil {
-				if s.debug {
-					conf.Internal().Warnf("sse write error %s", err)
-				}
-				return
-			}
-			m = nil
-		}
+	if ctx == nil {
+		ctx = context.Background()
 	}
-
+	s.Keepalive(SSEKeepalive(ctx))
+	return eof(s.Emitter.Await(ctx))
 }
 
-func (s *sse) send(msg *msg) (err error) {
-	if s.ch == nil {
-		return io.EOF
-	}
-	s.ch <- msg
-	return
-}
-func (s *sse) Send(data string) (err error) {
-	return s.SendEventID("", "", data)
+// SSEKeepalive the [sse.DefaultKeepalive] ends streams when the [Server] of request drains, such as
+//
+//	e := sse.NewEmitter(nil, w).Keepalive(htt.SSEKeepalive(r.Context()))
+func SSEKeepalive(ctx context.Context) sse.Keepalive {
+	k := sse.DefaultKeepalive
+	k.Drain = Draining(ctx)
+	return k
 }
 
-func (s *sse) SendEvent(event, data string) (err error) {
-	return s.SendEventID("", event, data)
+// NewSSEHub create [sse.Hub] whose streams end when the [Server] of request drains, see [SSEKeepalive].
+func NewSSEHub(queue int) *sse.Hub {
+	h := sse.NewHub(queue)
+	h.Drain = Draining
+	return h
 }
 
 // NewSSE create new SSE, should not send headers manually, this function will send Server-Send-Event headers.
+//
+// Deprecated: use [sse.NewEmitter]
 func NewSSE(w http.ResponseWriter, bufferSize ...int) (s SSE) {
 	return NewSSEDebug(false, w, bufferSize...)
 }
+
+// NewSSEDebug create new SSE logs with internal logger.
+//
+// Deprecated: use [sse.NewEmitter]
 func NewSSEDebug(debug bool, w http.ResponseWriter, bufferSize ...int) (s SSE) {
-	w.Header().Add("Connection", "keep-alive")
-	w.Header().Add("Transfer-Encoding", "chunked")
-	w.Header().Add("Content-Type", "text/event-stream")
-	w.Header().Add("X-Accel-Buffering", "no")
-	w.Header().Add("Cache-Control", "no-cache, must-revalidate")
-	w.WriteHeader(200)
-	var buf = 1
-	if len(bufferSize) > 0 && bufferSize[0] > 0 {
-		buf = bufferSize[0]
+	var log sse.Logger
+	if debug {
+		log = sseLogger{conf.Internal()}
 	}
-	s = &sse{debug: debug, ch: make(chan *msg, buf), ResponseWriter: w}
-	return
+	return &sseShim{Emitter: sse.NewEmitter(log, w, bufferSize...)}
 }
+
+// Deprecated: use [sse.NewEmitter] then [sse.Emitter.Ping]
 func NewSSEWithPing(w http.ResponseWriter, bufferSize ...int) (s SSE) {
 	s = NewSSE(w, bufferSize...)
 	_ = s.Ping()
 	return
 }
+
+// Deprecated: use [sse.NewEmitter] then [sse.Emitter.Ping]
 func NewSSEDebugWithPing(debug bool, w http.ResponseWriter, bufferSize ...int) (s SSE) {
 	s = NewSSEDebug(debug, w, bufferSize...)
 	_ = s.Ping()
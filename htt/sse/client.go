@@ -0,0 +1,325 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//region decoder
+
+// Decoder read events from a text/event-stream, which follows the parsing rules of the HTML spec:
+// lines end with CRLF, LF or CR, fields without value are allowed, and comments only reported to OnComment.
+type Decoder struct {
+	OnComment func(comment string) //called with each comment line, optional
+	OnRetry   func(mills int)      //called with each valid retry field, optional
+	sc        *bufio.Scanner
+	started   bool   //first line read, which may have a BOM
+	id        string //last event id
+	retry     int    //last reconnection time in milliseconds
+}
+
+// NewDecoder create [Decoder], maxLine is the max size of a line, default 64KB.
+func NewDecoder(r io.Reader, maxLine int) *Decoder {
+	if maxLine < 1 {
+		maxLine = 64 << 10
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, min(4096, maxLine)), maxLine)
+	sc.Split(scanLine)
+	return &Decoder{sc: sc}
+}
+
+// LastEventID the last event ID received, it persists across events until changed by the server.
+func (d *Decoder) LastEventID() string {
+	return d.id
+}
+
+// Retry the last reconnection time received in milliseconds, 0 when none.
+func (d *Decoder) Retry() int {
+	return d.retry
+}
+
+// Decode read next event into e, returns [io.EOF] at the end of stream, an incomplete event at the end is discarded.
+// The ID of event is the last event ID, and the Retry is set when the event carries one.
+func (d *Decoder) Decode(e *Event) error {
+	var data strings.Builder
+	var typ string
+	id := d.id
+	retry := 0
+	for d.sc.Scan() {
+		line := d.sc.Bytes()
+		if !d.started {
+			d.started = true
+			line = bytes.TrimPrefix(line, []byte("\xEF\xBB\xBF"))
+		}
+		if len(line) == 0 { //dispatch
+			d.id = id
+			if data.Len() == 0 {
+				typ, retry = "", 0
+				continue
+			}
+			*e = Event{ID: id, Event: typ, Data: strings.TrimSuffix(data.String(), "\n"), Retry: retry}
+			return nil
+		}
+		if line[0] == ':' {
+			if d.OnComment != nil {
+				d.OnComment(strings.TrimPrefix(string(line[1:]), " "))
+			}
+			continue
+		}
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			if len(value) > 0 && value[0] == ' ' {
+				value = value[1:]
+			}
+		}
+		switch string(field) {
+		case "event":
+			typ = string(value)
+		case "data":
+			data.Write(value)
+			data.WriteByte('\n')
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				id = string(value)
+			}
+		case "retry":
+			if n, err := strconv.Atoi(string(value)); err == nil && n >= 0 && isDigits(value) {
+				d.retry, retry = n, n
+				if d.OnRetry != nil {
+					d.OnRetry(n)
+				}
+			}
+		}
+	}
+	if err := d.sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// scanLine split lines end with CRLF, LF or CR
+func scanLine(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil //CR at the end, wait for a possible LF
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+//endregion decoder
+
+//region client
+
+// ErrUnexpectedResponse the server responds a status or content type can not be streamed
+var ErrUnexpectedResponse = errors.New("unexpected event stream response")
+
+// Client receive events by the decoding of [Notifier] and reconnect automatically, which resends the Last-Event-ID.
+//
+// Reconnection waits the retry time sent by server or [Client.Retry], it grows exponentially with jitter
+// while connections keep failing. Streaming stops when context done, server responds 204 No Content
+// or a response of client error.
+type Client struct {
+	Request  func(ctx context.Context) (*http.Request, error) //create request of each connection
+	HTTP     *http.Client                                     //default http.DefaultClient, should not have a Timeout
+	Retry    time.Duration                                    //initial reconnection time, default 3s
+	MaxRetry time.Duration                                    //max reconnection time of backoff, default 1m
+	Jitter   float64                                          //random factor of reconnection time in [0,1], default 0.2
+	MaxLine  int                                              //max size of a line, default 64KB
+	Log      Logger                                           //optional
+	lock     sync.Mutex
+	lastID   string //sent when connecting, updated by received events
+}
+
+// NewClient create [Client] with request factory, such as
+//
+//	sse.NewClient(func(ctx context.Context) (*http.Request, error) {
+//		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+//	})
+func NewClient(request func(ctx context.Context) (*http.Request, error)) *Client {
+	return &Client{Request: request, Retry: 3 * time.Second, MaxRetry: time.Minute, Jitter: 0.2}
+}
+
+// LastEventID the last event ID received, which is sent when reconnecting.
+func (c *Client) LastEventID() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lastID
+}
+
+// SetLastEventID set the event ID sent when connecting, such as resuming from a persisted ID before [Client.Run].
+func (c *Client) SetLastEventID(id string) *Client {
+	c.lock.Lock()
+	c.lastID = id
+	c.lock.Unlock()
+	return c
+}
+
+// Events streams events to the channel, which closed when streaming stopped.
+// The error channel receives the reason once, which is nil when context done or the server ends the stream.
+func (c *Client) Events(ctx context.Context) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		errs <- c.Run(ctx, func(e Event) error {
+			select {
+			case events <- e:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(errs)
+	}()
+	return events, errs
+}
+
+// Run streams events to fn until context done, fn returns an error or the stream can not be retried.
+// It returns nil when context done or the server responds 204 No Content.
+func (c *Client) Run(ctx context.Context, fn func(e Event) error) error {
+	if ctx == nil {
+		return ErrContextRequired
+	}
+	retry := c.Retry
+	if retry <= 0 {
+		retry = 3 * time.Second
+	}
+	for failures := 0; ; {
+		received, server, err := c.connect(ctx, fn)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var stop stopError
+		if errors.As(err, &stop) {
+			return stop.error
+		}
+		if server > 0 {
+			retry = time.Duration(server) * time.Millisecond
+		}
+		if received {
+			failures = 0
+		} else {
+			failures++
+		}
+		delay := c.backoff(retry, failures)
+		if c.Log != nil {
+			c.Log.Warnf("event stream disconnected: %v, reconnect in %s", err, delay)
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// stopError ends streaming without retry, nil error for normally end.
+type stopError struct{ error }
+
+func (c *Client) connect(ctx context.Context, fn func(e Event) error) (received bool, retry int, err error) {
+	req, err := c.Request(ctx)
+	if err != nil {
+		return false, 0, stopError{err}
+	}
+	req = req.WithContext(ctx)
+	FillRequestHeader(req.Header)
+	lastID := c.LastEventID()
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	cli := c.HTTP
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+	res, err := cli.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNoContent:
+		return false, 0, stopError{}
+	case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout:
+		return false, 0, fmt.Errorf("%w: %s", ErrUnexpectedResponse, res.Status)
+	case res.StatusCode != http.StatusOK:
+		return false, 0, stopError{fmt.Errorf("%w: %s", ErrUnexpectedResponse, res.Status)}
+	case !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream"):
+		return false, 0, stopError{fmt.Errorf("%w: content type %s", ErrUnexpectedResponse, res.Header.Get("Content-Type"))}
+	}
+	n := &notifier{Response: res, maxLine: c.MaxLine, lastID: lastID}
+	n.onRetry = func(mills int) { retry = mills }
+	n.emit = func(e Event) error {
+		received = true
+		c.SetLastEventID(e.ID)
+		if err := fn(e); err != nil {
+			return stopError{err}
+		}
+		return nil
+	}
+	if err = n.Await(ctx); err == nil {
+		err = io.EOF //stream ended by server, reconnect
+	}
+	c.SetLastEventID(n.lastID)
+	return
+}
+
+// backoff doubles the retry time for each failure until MaxRetry, then apply jitter
+func (c *Client) backoff(retry time.Duration, failures int) time.Duration {
+	limit := c.MaxRetry
+	if limit <= 0 {
+		limit = time.Minute
+	}
+	d := retry
+	for i := 1; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = max(limit, retry)
+	}
+	if j := min(max(c.Jitter, 0), 1); j > 0 {
+		d = time.Duration(float64(d) * (1 + j*(rand.Float64()*2-1)))
+	}
+	return d
+}
+
+//endregion client
@@ -0,0 +1,107 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientReconnect(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FillResponseHeader(w.Header())
+		switch conns.Add(1) {
+		case 1:
+			if id := r.Header.Get("Last-Event-ID"); id != "0" {
+				t.Errorf("resumed Last-Event-ID: %q", id)
+			}
+			WriteEvent(w, &Event{Retry: 10})
+			WriteEvent(w, &Event{ID: "1", Data: "a"})
+			WriteEvent(w, &Event{ID: "2", Event: "tick", Data: "b\nc"})
+		case 2:
+			if id := r.Header.Get("Last-Event-ID"); id != "2" {
+				t.Errorf("Last-Event-ID: %q", id)
+			}
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case 3:
+			WriteEvent(w, &Event{ID: "3", Data: "d"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	c := NewClient(func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	})
+	c.SetLastEventID("0").MaxRetry = 50 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, errs := c.Events(ctx)
+	var got []Event
+	for e := range events {
+		_ = c.LastEventID() //read while streaming
+		got = append(got, e)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{{ID: "1", Data: "a"}, {ID: "2", Event: "tick", Data: "b\nc"}, {ID: "3", Data: "d"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event %d: got %+v want %+v", i, got[i], want[i])
+		}
+	}
+	if c.LastEventID() != "3" || conns.Load() != 4 {
+		t.Fatalf("last id %s, connections %d", c.LastEventID(), conns.Load())
+	}
+}
+
+func TestClientStop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	c := NewClient(func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	})
+	if err := c.Run(context.Background(), func(Event) error { return nil }); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	idle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FillResponseHeader(w.Header())
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer idle.Close()
+	c.Request = func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, idle.URL, nil)
+	}
+	if err := c.Run(ctx, func(Event) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder(strings.NewReader(": comment\r\ndata:x\rdata\r\n\r\nid: 7\nretry: 5\n\nevent: e\ndata:  y\n\ndata: partial"), 0)
+	var e Event
+	for _, want := range []Event{{Data: "x\n"}, {ID: "7", Event: "e", Data: " y"}} {
+		if err := d.Decode(&e); err != nil || e != want {
+			t.Fatalf("got %+v %v want %+v", e, err, want)
+		}
+	}
+	if err := d.Decode(&e); err != io.EOF || d.Retry() != 5 {
+		t.Fatal(err, d.Retry())
+	}
+}
@@ -0,0 +1,151 @@
+package sse
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// conformance cases shared by emitter and parsers, events decoded per the HTML spec
+var conformance = []struct {
+	name   string
+	send   []Event
+	wire   string
+	events []Event //dispatched events, Retry is checked by retry
+	lastID string
+	retry  int
+	pings  int //comments, heartbeat of any text
+}{
+	{name: "data", send: []Event{{Data: "a"}}, wire: "data: a\n\n", events: []Event{{Data: "a"}}},
+	{name: "id data", send: []Event{{ID: "1", Data: "a"}}, wire: "id: 1\ndata: a\n\n", events: []Event{{ID: "1", Data: "a"}}, lastID: "1"},
+	{name: "event data", send: []Event{{Event: "e", Data: "a"}}, wire: "event: e\ndata: a\n\n", events: []Event{{Event: "e", Data: "a"}}},
+	{name: "id event data", send: []Event{{ID: "1", Event: "e", Data: "a"}}, wire: "id: 1\nevent: e\ndata: a\n\n", events: []Event{{ID: "1", Event: "e", Data: "a"}}, lastID: "1"},
+	{name: "event without data", send: []Event{{Event: "close"}}, wire: "event: close\ndata\n\n", events: []Event{{Event: "close"}}},
+	{name: "id only", send: []Event{{ID: "9"}}, wire: "id: 9\n\n", lastID: "9"},
+	{name: "retry only", send: []Event{{Retry: 1500}}, wire: "retry: 1500\n\n", retry: 1500},
+	{name: "ping", send: []Event{{Comment: "ping"}}, wire: ": ping\n\n", pings: 1},
+	{name: "heartbeat", send: []Event{{Comment: "keep-alive"}, {Comment: "ping"}}, wire: ": keep-alive\n\n: ping\n\n", pings: 2},
+	{name: "multiline", send: []Event{{Data: "a\r\nb\rc\nd"}}, wire: "data: a\ndata: b\ndata: c\ndata: d\n\n", events: []Event{{Data: "a\nb\nc\nd"}}},
+	{name: "trailing line break", send: []Event{{Data: "a\n"}}, wire: "data: a\ndata: \n\n", events: []Event{{Data: "a\n"}}},
+	{name: "leading space", send: []Event{{Data: " a"}}, wire: "data:  a\n\n", events: []Event{{Data: " a"}}},
+	{name: "line break in id and event", send: []Event{{ID: "1\n2", Event: "e\r\nf", Data: "a"}}, wire: "id: 12\nevent: ef\ndata: a\n\n", events: []Event{{ID: "12", Event: "ef", Data: "a"}}, lastID: "12"},
+	{
+		name: "all fields",
+		send: []Event{{ID: "2", Event: "e", Data: "x", Retry: 10, Comment: "c"}},
+		wire: ": c\nid: 2\nevent: e\nretry: 10\ndata: x\n\n", events: []Event{{ID: "2", Event: "e", Data: "x"}}, lastID: "2", retry: 10, pings: 1,
+	},
+	{
+		name:   "id persists",
+		send:   []Event{{ID: "1", Data: "a"}, {Data: "b"}, {Comment: "ping"}, {Event: "e", Data: "c"}},
+		wire:   "id: 1\ndata: a\n\ndata: b\n\n: ping\n\nevent: e\ndata: c\n\n",
+		events: []Event{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}, {ID: "1", Event: "e", Data: "c"}}, lastID: "1", pings: 1,
+	},
+}
+
+func TestEmitterConformance(t *testing.T) {
+	for _, c := range conformance {
+		rec := httptest.NewRecorder()
+		e := NewEmitter(nil, rec, len(c.send))
+		for _, x := range c.send {
+			if err := e.Send(x); err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+		}
+		_ = e.Close()
+		if err := e.Await(context.Background()); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := rec.Body.String(); got != c.wire {
+			t.Errorf("%s: wire %q want %q", c.name, got, c.wire)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("%s: content type %s", c.name, got)
+		}
+	}
+}
+
+func TestDecoderConformance(t *testing.T) {
+	for _, c := range conformance {
+		for _, eol := range []string{"\n", "\r\n", "\r"} {
+			d := NewDecoder(strings.NewReader(strings.ReplaceAll(c.wire, "\n", eol)), 0)
+			pings := 0
+			d.OnComment = func(string) { pings++ }
+			var got []Event
+			var e Event
+			for d.Decode(&e) == nil {
+				e.Retry = 0
+				got = append(got, e)
+			}
+			if !reflect.DeepEqual(got, c.events) || d.LastEventID() != c.lastID || d.Retry() != c.retry || pings != c.pings {
+				t.Errorf("%s %q: got %+v id %q retry %d pings %d", c.name, eol, got, d.LastEventID(), d.Retry(), pings)
+			}
+		}
+	}
+}
+
+func TestNotifierConformance(t *testing.T) {
+	for _, c := range conformance {
+		n := NewNotifier(nil, &http.Response{Body: io.NopCloser(strings.NewReader(c.wire))}, 1, 0)
+		var got []Event
+		retry, pings := 0, 0
+		_ = n.OnEvent(func(id, evt, data string) { got = append(got, Event{ID: id, Event: evt, Data: data}) })
+		_ = n.OnRetry(func(mills int) { retry = mills })
+		_ = n.OnPing(func() { pings++ })
+		if err := n.Await(context.Background()); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(got, c.events) || retry != c.retry || pings != c.pings {
+			t.Errorf("%s: got %+v retry %d pings %d", c.name, got, retry, pings)
+		}
+	}
+}
+
+func TestEmitterStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := NewEmitter(nil, w, 4)
+		go func() {
+			for _, c := range conformance {
+				for _, x := range c.send {
+					_ = e.Send(x)
+				}
+			}
+			_ = e.Close()
+		}()
+		_ = e.Await(r.Context())
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNotifier(nil, res, 1, 0)
+	var got []Event
+	_ = n.OnEvent(func(id, evt, data string) { got = append(got, Event{ID: id, Event: evt, Data: data}) })
+	if err = n.Await(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var want []Event
+	id := ""
+	for _, c := range conformance {
+		for _, e := range c.events {
+			if e.ID == "" {
+				e.ID = id //id persists from previous cases
+			}
+			want = append(want, e)
+		}
+		if c.lastID != "" {
+			id = c.lastID
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -1,19 +1,24 @@
 package sse
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Emitter use to send SSE events
+// Emitter use to send SSE events, every combination of id, event, data, retry and comment is encoded by [WriteEvent].
+//
+// Events are queued and written by [Emitter.Await], send methods block while the queue is full, returns [ErrClosed] once closed.
+// An Emitter implements [hub.Client], so it can join a [Hub] directly.
 type Emitter interface {
-	//Close manual close emitter
+	//Close manual close emitter, queued events are still written, returns [ErrClosed] if already closed.
 	Close() error
 	//Raw fetch raw writer
 	Raw() http.ResponseWriter
@@ -29,18 +34,25 @@ type Emitter interface {
 	Data(data string) error
 	//Event send event with id type and data, id and type can be empty.
 	Event(id, evt, data string) error
-	//Await block current goroutine until the request disconnected or Emitter been closed.
-	//The context is required and should be relative to request context
+	//Send the event
+	Send(e Event) error
+	//Deliver enqueue the event without blocking, returns false when the queue is full or closed.
+	Deliver(e Event) bool
+	//Keepalive config heartbeat, write deadline and lifetime, should set before Await. Default [DefaultKeepalive].
+	Keepalive(k Keepalive) Emitter
+	//Await block current goroutine until the request disconnected, Emitter been closed or the stream expired, see [Keepalive].
+	//The context is required and should be relative to request context.
+	//Returns nil when closed, expired or context done, or error joined with [ErrConnFailure] when write failed.
 	Await(ctx context.Context) error
 }
 
-// Notifier use to receive
+// Notifier use to receive, which parses stream by [Decoder]
 type Notifier interface {
 	Close() error                                //Close the notifier manually.
 	Raw() *http.Response                         //Raw response
 	Use(r *http.Response) error                  //Use the notifier again after close.
 	OnClose(fn func())                           //OnClose hook, replace exists hook.
-	OnPing(fn func()) error                      //OnPing handle ping, can only config before [Notifier.Await]
+	OnPing(fn func()) error                      //OnPing handle comments, such as heartbeat ping of any text, can only config before [Notifier.Await]
 	OnRetry(fn func(mills int)) error            //OnRetry handle retry, can only config before [Notifier.Await]
 	OnEvent(fn func(id, evt, data string)) error //OnEvent handle event, can only config before [Notifier.Await]
 	Await(ctx context.Context) error             //Await process message until connection closed or context done
@@ -67,31 +79,6 @@ var (
 	ErrRunning = errors.New("is running")
 )
 
-type dto struct {
-	retry int
-	id    string
-	event string
-	data  string
-}
-
-func (d *dto) empty() bool {
-	return d.retry == 0 && d.data == ""
-}
-func (d *dto) retrying() bool {
-	return d.retry > 0
-}
-func (d *dto) ping() bool {
-	return d.retry == -1 && d.data == ""
-}
-func (d *dto) full() bool {
-	return d.data != "" && d.id != "" && d.event != ""
-}
-func (d *dto) simple() bool {
-	return d.data != "" && d.id == "" && d.event == ""
-}
-func (d *dto) typed() bool {
-	return d.data != "" && d.id == "" && d.event != ""
-}
 func FillRequestHeader(w http.Header) {
 	w.Set("Connection", "keep-alive")
 	w.Set("Accept", "text/event-stream")
@@ -105,186 +92,330 @@ func FillResponseHeader(w http.Header) {
 	w.Set("Cache-Control", "no-cache, must-revalidate")
 }
 
+// WriteEvent encode the event in text/event-stream format.
+// Comment and data with multiple lines are split into fields, line breaks in id and event are removed,
+// and an event with type but no data sends an empty data field, so it still dispatches on client.
+func WriteEvent(w io.Writer, e *Event) {
+	var b strings.Builder
+	if e.Comment != "" {
+		for _, line := range splitLines(e.Comment) {
+			b.WriteString(": ")
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	if e.ID != "" {
+		b.WriteString("id: ")
+		b.WriteString(singleLine(e.ID))
+		b.WriteByte('\n')
+	}
+	if e.Event != "" {
+		b.WriteString("event: ")
+		b.WriteString(singleLine(e.Event))
+		b.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: ")
+		b.WriteString(strconv.Itoa(e.Retry))
+		b.WriteByte('\n')
+	}
+	if e.Data != "" {
+		for _, line := range splitLines(e.Data) {
+			b.WriteString("data: ")
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	} else if e.Event != "" {
+		b.WriteString("data\n")
+	}
+	b.WriteByte('\n')
+	_, _ = io.WriteString(w, b.String())
+}
+
+// splitLines split by CRLF, LF or CR
+func splitLines(s string) []string {
+	return strings.Split(strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n"), "\n")
+}
+
+// singleLine remove line breaks, which are not allowed in id and event
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
 //region emitter
 
-type emitter struct {
-	buf     int
-	ch      chan *dto
-	onClose func()
-	log     Logger
-	http.ResponseWriter
+// Keepalive options of streams, see [DefaultKeepalive].
+//
+// The server WriteTimeout is always disabled for streams, dead clients are detected by write errors of heartbeats,
+// the write deadline and the request context.
+type Keepalive struct {
+	Heartbeat time.Duration   //interval of heartbeat comment when no event written, 0 to disable
+	Comment   string          //heartbeat comment, default ping
+	WriteWait time.Duration   //write deadline of each write, a client not reading in time is dead, 0 to disable
+	Lifetime  time.Duration   //max duration of a stream with 10% jitter, then the client is told to reconnect, 0 for unlimited
+	Reconnect int             //retry in milliseconds sent when the stream ends by Lifetime or Drain, 0 keeps the client default
+	Drain     <-chan struct{} //closed when the server shutdown, such as htt.Draining(r.Context()), streams end as Lifetime reached
 }
 
+// DefaultKeepalive sends heartbeat every 15s, and treats clients not reading in 10s as dead.
+var DefaultKeepalive = Keepalive{Heartbeat: 15 * time.Second, Comment: "ping", WriteWait: 10 * time.Second}
+
+type emitter struct {
+	buf       int
+	ch        chan Event
+	done      chan struct{}
+	once      *sync.Once
+	lock      sync.Mutex
+	onClose   func()
+	awaiting  atomic.Bool
+	keepalive Keepalive
+	log       Logger
+	w         http.ResponseWriter
+	prefix    []Event             //written first by Await, such as replayed events
+	skip      map[string]struct{} //ids of events already written in prefix, until a newer event queued
+	expired   bool                //ended by Lifetime or Drain
+}
+
+// NewEmitter create [Emitter] and send response headers. optLog is optional [Logger]; buf is the queue size, default 1.
+// It keeps alive with [DefaultKeepalive].
 func NewEmitter(optLog Logger, w http.ResponseWriter, buf ...int) Emitter {
-	x := &emitter{ResponseWriter: w}
+	x := newEmitter(optLog, w, buf...)
+	x.writeHeader()
+	return x
+}
+
+func newEmitter(optLog Logger, w http.ResponseWriter, buf ...int) *emitter {
+	x := &emitter{buf: 1, log: optLog, keepalive: DefaultKeepalive}
 	if len(buf) > 0 && buf[0] > 1 {
 		x.buf = buf[0]
-	} else {
-		x.buf = 1
 	}
-	x.log = optLog
-	FillResponseHeader(w.Header())
-	w.WriteHeader(200)
+	x.reset(w)
 	return x
 }
 
-func (s *emitter) Close() error {
-	if s.ch != nil {
-		close(s.ch)
-		if s.onClose != nil {
-			s.onClose()
-			s.onClose = nil
-		}
-		//! should send chunked end message?
-		return nil
+func (s *emitter) reset(w http.ResponseWriter) {
+	s.w = w
+	s.ch = make(chan Event, s.buf)
+	s.done = make(chan struct{})
+	s.once = new(sync.Once)
+	s.prefix, s.skip, s.expired = nil, nil, false
+}
+
+func (s *emitter) writeHeader() {
+	FillResponseHeader(s.w.Header())
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *emitter) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
 	}
-	return ErrClosed
+}
+
+func (s *emitter) Close() (err error) {
+	err = ErrClosed
+	s.once.Do(func() {
+		close(s.done)
+		s.lock.Lock()
+		fn := s.onClose
+		s.onClose = nil
+		s.lock.Unlock()
+		if fn != nil {
+			fn()
+		}
+		err = nil
+	})
+	return
 }
 func (s *emitter) Use(w http.ResponseWriter) error {
-	if s.ch != nil {
+	if !s.closed() || s.awaiting.Load() {
 		return ErrRunning
 	}
-	s.ResponseWriter = w
+	s.reset(w)
+	s.writeHeader()
 	return nil
 }
 func (s *emitter) Raw() http.ResponseWriter {
-	return s.ResponseWriter
+	return s.w
 }
 
 func (s *emitter) OnClose(fn func()) {
+	s.lock.Lock()
 	s.onClose = fn
+	s.lock.Unlock()
 }
 
-func (s *emitter) Ping() error {
-	if s.ch == nil {
-		return ErrClosed
+func (s *emitter) Keepalive(k Keepalive) Emitter {
+	if k.Comment == "" {
+		k.Comment = DefaultKeepalive.Comment
 	}
-	s.ch <- &dto{retry: -1}
-	return nil
+	s.keepalive = k
+	return s
+}
+
+func (s *emitter) Ping() error {
+	return s.Send(Event{Comment: "ping"})
 }
 
 func (s *emitter) Retry(mills int) error {
-	if s.ch == nil {
-		return ErrClosed
-	}
-	s.ch <- &dto{retry: mills}
-	return nil
+	return s.Send(Event{Retry: mills})
 }
 
 func (s *emitter) Data(data string) error {
-	if s.ch == nil {
-		return ErrClosed
-	}
-	s.ch <- &dto{data: data}
-	return nil
+	return s.Send(Event{Data: data})
 }
 
 func (s *emitter) Event(id, evt, data string) error {
-	if s.ch == nil {
+	return s.Send(Event{ID: id, Event: evt, Data: data})
+}
+
+func (s *emitter) Send(e Event) error {
+	if s.closed() {
+		return ErrClosed
+	}
+	select {
+	case s.ch <- e:
+		return nil
+	case <-s.done:
 		return ErrClosed
 	}
-	s.ch <- &dto{id: id, event: evt, data: data}
-	return nil
 }
 
-type flush interface {
-	FlushError() error
-	io.Writer
+func (s *emitter) Deliver(e Event) bool {
+	if s.closed() {
+		return false
+	}
+	select {
+	case s.ch <- e:
+		return true
+	default:
+		return false
+	}
 }
 
-func (s *emitter) send(out flush, m string, args ...any) (err error) {
-	_, err = fmt.Fprintf(out, m, args...)
-	if err != nil {
-		if s.log != nil {
-			s.log.Errorf("send failure: %s ", err)
+// encode the event unless it is already written in prefix.
+// Duplicates are queued before any newer event, so skip ends at the first event with an ID not in prefix.
+func (s *emitter) encode(b *strings.Builder, e *Event) {
+	if e.ID != "" && s.skip != nil {
+		if _, ok := s.skip[e.ID]; ok {
+			delete(s.skip, e.ID)
+			return
 		}
-		return errors.Join(ErrConnFailure, err)
+		s.skip = nil
+	}
+	WriteEvent(b, e)
+}
+
+// drain encode all queued events
+func (s *emitter) drain(b *strings.Builder) {
+	for n := len(s.ch); n > 0; n-- {
+		x := <-s.ch
+		s.encode(b, &x)
+	}
+}
+
+// flush write the encoded events within the write deadline
+func (s *emitter) flush(rc *http.ResponseController, b *strings.Builder) (err error) {
+	if b.Len() == 0 {
+		return nil
+	}
+	if s.keepalive.WriteWait > 0 {
+		_ = rc.SetWriteDeadline(time.Now().Add(s.keepalive.WriteWait))
+	}
+	if _, err = io.WriteString(s.w, b.String()); err == nil {
+		err = rc.Flush()
 	}
-	err = out.FlushError()
 	if err != nil {
 		if s.log != nil {
-			s.log.Errorf("flush failure: %s ", err)
+			s.log.Errorf("send failure: %s ", err)
 		}
 		return errors.Join(ErrConnFailure, err)
 	}
 	return nil
 }
-func (s *emitter) make() {
-	if s.ch == nil {
-		s.ch = make(chan *dto, s.buf)
-	}
-}
+
 func (s *emitter) Await(ctx context.Context) (err error) {
-	if ctx != nil {
+	if ctx == nil {
 		return ErrContextRequired
 	}
-	s.make()
-	var d *dto
-	var ok bool
-	var w = s.ResponseWriter.(flush)
+	if !s.awaiting.CompareAndSwap(false, true) {
+		return ErrRunning
+	}
+	defer s.awaiting.Store(false)
+	k := s.keepalive
+	rc := http.NewResponseController(s.w)
+	_ = rc.SetWriteDeadline(time.Time{}) //disable server WriteTimeout for the stream
+	var b strings.Builder
+	for i := range s.prefix {
+		WriteEvent(&b, &s.prefix[i])
+	}
+	if err = s.flush(rc, &b); err == nil && b.Len() == 0 {
+		if err = rc.Flush(); err != nil { //send headers
+			err = errors.Join(ErrConnFailure, err)
+		}
+	}
+	if err != nil {
+		_ = s.Close()
+		return
+	}
+	var beat, expire <-chan time.Time
+	if k.Heartbeat > 0 {
+		t := time.NewTicker(k.Heartbeat)
+		defer t.Stop()
+		beat = t.C
+	}
+	if k.Lifetime > 0 {
+		t := time.NewTimer(time.Duration(float64(k.Lifetime) * (0.9 + rand.Float64()*0.2)))
+		defer t.Stop()
+		expire = t.C
+	}
+	drain := k.Drain
+	wrote := false
 	for {
+		b.Reset()
 		select {
-		case d, ok = <-s.ch:
-			if !ok {
-				if s.log != nil {
-					s.log.Debugf("closing")
-				}
-				goto end
-			}
-			if s.log != nil {
-				s.log.Debugf("process event: %#+v", d)
+		case e := <-s.ch:
+			s.encode(&b, &e)
+			s.drain(&b)
+			wrote = true
+		case <-beat:
+			if !wrote {
+				WriteEvent(&b, &Event{Comment: k.Comment})
 			}
-			switch {
-			case d.ping():
-				err = s.send(w, ": ping\n\n")
-				if err != nil {
-					goto fail
-				}
-			case d.retrying():
-				err = s.send(w, "retry:%d\n\n", d.retry)
-				if err != nil {
-					goto fail
-				}
-			case d.full():
-				err = s.send(w, "id: %s\nevent: %s\ndata: %s\n\n", d.id, d.event, d.data)
-				if err != nil {
-					goto fail
-				}
-			case d.typed():
-				err = s.send(w, "event: %s\ndata: %s\n\n", d.event, d.data)
-				if err != nil {
-					goto fail
-				}
-			case d.simple():
-				err = s.send(w, "data: %s\n\n", d.event, d.data)
-				if err != nil {
-					goto fail
-				}
-			default:
-				if s.log != nil {
-					s.log.Warnf("send nothing for %#+v", d)
-				}
-			}
-			d = nil
+			wrote = false
+		case <-expire:
+			return s.expire(rc, &b)
+		case <-drain:
+			return s.expire(rc, &b)
 		case <-ctx.Done():
 			if s.log != nil {
 				s.log.Debugf("request context close")
 			}
-			close(s.ch)
-			goto end
+			_ = s.Close()
+			return nil
+		case <-s.done:
+			s.drain(&b)
+			return s.flush(rc, &b)
+		}
+		if err = s.flush(rc, &b); err != nil {
+			_ = s.Close()
+			return
 		}
 	}
-fail:
-	close(s.ch)
-	s.ch = nil
-	if s.onClose != nil {
-		s.onClose()
-		s.onClose = nil
+}
+
+// expire close the stream, tells client to reconnect after queued events written
+func (s *emitter) expire(rc *http.ResponseController, b *strings.Builder) error {
+	s.expired = true
+	_ = s.Close()
+	s.drain(b)
+	if s.keepalive.Reconnect > 0 {
+		WriteEvent(b, &Event{Retry: s.keepalive.Reconnect})
 	}
-	return err
-end:
-	s.ch = nil
-	return ErrClosed
+	return s.flush(rc, b)
 }
 
 //endregion emitter
@@ -292,60 +423,55 @@ end:
 //region notifier
 
 type notifier struct {
-	dataBuf uint32
-	buf     int
+	maxLine int
+	lock    sync.Mutex
 	cc      func()
 	onClose func()
 	onRetry func(mills int)
 	onPing  func()
 	onEvent func(id, evt, data string)
+	emit    func(e Event) error //used instead of onEvent by [Client], an error ends Await
+	lastID  string              //last event ID, initial of the decoder and updated when Await returns
 	log     Logger
 	*http.Response
 }
 
-func clamp(a, b, v int) int {
-	if a > b {
-		c := b
-		b = a
-		a = c
-	}
-	if v >= b {
-		return b
-	}
-	if v <= a {
-		return a
-	}
-	return v
-}
-
-// NewNotifier make new notifier. optLog is optional [Logger]; buf is data
-// channel buffer between 1~5; dataBuf is message buffer between 512B~8KB
+// NewNotifier make new notifier. optLog is optional [Logger]; buf is ignored, which is kept for compatibility;
+// dataBuf is max size of a line, at least 512B, default 64KB.
 func NewNotifier(optLog Logger, res *http.Response, buf int, dataBuf int) Notifier {
-	x := &notifier{Response: res, log: optLog}
-	x.buf = clamp(1, 5, buf)
-	x.dataBuf = uint32(clamp(512, 1024*8, dataBuf))
-	return x
+	if dataBuf <= 0 {
+		dataBuf = 64 << 10
+	}
+	return &notifier{Response: res, log: optLog, maxLine: max(512, dataBuf)}
 }
 
 func (s *notifier) Close() error {
-	if s.cc == nil {
+	s.lock.Lock()
+	cc, fn := s.cc, s.onClose
+	s.cc, s.onClose = nil, nil
+	s.lock.Unlock()
+	if cc == nil {
 		return ErrClosed
 	}
-	s.cc()
-	s.cc = nil
-	if s.onClose != nil {
-		s.onClose()
-		s.onClose = nil
+	cc()
+	if fn != nil {
+		fn()
 	}
 	return nil
 }
 
+func (s *notifier) running() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cc != nil
+}
+
 func (s *notifier) Raw() *http.Response {
 	return s.Response
 }
 
 func (s *notifier) Use(r *http.Response) error {
-	if s.cc != nil {
+	if s.running() {
 		return ErrRunning
 	}
 	s.Response = r
@@ -353,11 +479,13 @@ func (s *notifier) Use(r *http.Response) error {
 }
 
 func (s *notifier) OnClose(fn func()) {
+	s.lock.Lock()
 	s.onClose = fn
+	s.lock.Unlock()
 }
 
 func (s *notifier) OnPing(fn func()) error {
-	if s.cc != nil {
+	if s.running() {
 		return ErrRunning
 	}
 	s.onPing = fn
@@ -365,7 +493,7 @@ func (s *notifier) OnPing(fn func()) error {
 }
 
 func (s *notifier) OnRetry(fn func(mills int)) error {
-	if s.cc != nil {
+	if s.running() {
 		return ErrRunning
 	}
 	s.onRetry = fn
@@ -373,238 +501,66 @@ func (s *notifier) OnRetry(fn func(mills int)) error {
 }
 
 func (s *notifier) OnEvent(fn func(id string, evt string, data string)) error {
-	if s.cc != nil {
+	if s.running() {
 		return ErrRunning
 	}
 	s.onEvent = fn
 	return nil
 }
 
-var (
-	ping        = []byte(":ping")
-	headerID    = []byte("id:")
-	headerData  = []byte("data:")
-	headerEvent = []byte("event:")
-	headerRetry = []byte("retry:")
-)
-
-func (s *notifier) Await(ctx context.Context) error {
-	if s.cc != nil {
-		return ErrRunning
-	}
+// Await returns nil when stream ended or context done, [ErrClosed] when closed manually.
+func (s *notifier) Await(ctx context.Context) (err error) {
 	if ctx == nil {
 		return ErrContextRequired
 	}
-	ctx, s.cc = context.WithCancel(ctx)
-	bins, errs := s.asyncRead(ctx)
+	s.lock.Lock()
+	if s.cc != nil {
+		s.lock.Unlock()
+		return ErrRunning
+	}
+	var cc context.CancelFunc
+	ctx, cc = context.WithCancel(ctx)
+	s.cc = cc
+	s.lock.Unlock()
+	stop := context.AfterFunc(ctx, func() { //unblock reading
+		_ = s.Response.Body.Close()
+	})
 	defer func() {
-		close(bins)
-		close(errs)
-		if s.cc != nil {
-			s.cc()
-			s.cc = nil
+		stop()
+		_ = s.Response.Body.Close()
+		if s.Close() == ErrClosed && err == nil { //closed manually
+			err = ErrClosed
 		}
 	}()
-	trimHeader := func(size int, data []byte) []byte {
-		if data == nil || len(data) < size {
-			return data
-		}
-		data = data[size:]
-		if len(data) > 0 && data[0] == 32 {
-			data = data[1:]
-		}
-		if len(data) > 0 && data[len(data)-1] == 10 {
-			data = data[:len(data)-1]
-		}
-		return data
-	}
-	var d = &dto{}
-	var bin []byte
-	var err error
-	var data []byte
+	d := NewDecoder(s.Response.Body, s.maxLine)
+	d.id = s.lastID
+	defer func() { s.lastID = d.LastEventID() }()
+	if s.onPing != nil {
+		d.OnComment = func(string) { s.onPing() }
+	}
+	d.OnRetry = s.onRetry
+	var e Event
 	for {
-		select {
-		case bin = <-bins:
-			if s.cc == nil {
-				goto closed
-			}
-			n := len(bin)
-			if n == 1 {
-				continue
-			}
-			for _, line := range bytes.FieldsFunc(bin, func(r rune) bool { return r == '\n' || r == '\r' }) {
-				switch {
-				case bytes.Equal(line, ping):
-					d.retry = -1
-					goto proc
-				case bytes.HasPrefix(line, headerID):
-					d.id = string(trimHeader(len(headerID), line))
-				case bytes.HasPrefix(line, headerData):
-					data = append(data, append(trimHeader(len(headerData), line), byte('\n'))...)
-				case bytes.Equal(line, bytes.TrimSuffix(headerData, []byte(":"))):
-					data = append(data, byte('\n'))
-				case bytes.HasPrefix(line, headerEvent):
-					d.event = string(trimHeader(len(headerEvent), line))
-				case bytes.HasPrefix(line, headerRetry):
-					res := string(trimHeader(len(headerRetry), line))
-					d.retry, err = strconv.Atoi(res)
-					if err != nil {
-						if s.log != nil {
-							s.log.Warnf("bad retry data: %s", res)
-						}
-						d.retry = 0
-						err = nil
-					}
-				}
-			}
-			d.data = string(data)
-			if cap(data) < 512 { //! max 512 buffer
-				data = data[:0]
-			} else {
-				data = nil
-			}
-		proc:
-			switch {
-			case d.ping():
-				if s.onPing != nil {
-					s.onPing()
-				}
-				if s.log != nil {
-					s.log.Debugf("on ping")
-				}
-			case d.retrying():
-				if s.onRetry != nil {
-					s.onRetry(d.retry)
-				}
-				if s.log != nil {
-					s.log.Debugf("on retry: %d", d.retry)
-				}
-			case d.empty():
-				if s.log != nil {
-					s.log.Warnf("empty message received")
-				}
-			default:
-				if s.onEvent != nil {
-					s.onEvent(d.id, d.event, d.data)
-				}
-				if s.log != nil {
-					s.log.Debugf("message received:#+v", d)
-				}
-			}
-		case err = <-errs:
-			if err == io.EOF {
-				if s.log != nil {
-					s.log.Debugf("read empty chunk")
-				}
-				continue
-			} else if err == context.DeadlineExceeded {
-				if s.log != nil {
-					s.log.Debugf("context closed")
-				}
-				goto end
+		if err = d.Decode(&e); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
 			}
 			if s.log != nil {
 				s.log.Errorf("connection error %s", err)
 			}
-			goto err
+			return
 		}
-	}
-closed:
-	if s.onClose != nil {
-		c := s.onClose
-		s.onClose = nil
-		c()
-	}
-	return ErrClosed
-end:
-	if s.onClose != nil {
-		c := s.onClose
-		s.onClose = nil
-		c()
-	}
-	return nil
-err:
-	if s.onClose != nil {
-		c := s.onClose
-		s.onClose = nil
-		c()
-	}
-	return err
-}
-func chunkSplit(data []byte, atEOF bool) (int, []byte, error) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
-	}
-	minial := func(a, b int) int {
-		if a < 0 {
-			return b
-		}
-		if b < 0 {
-			return a
-		}
-		if a > b {
-			return b
-		}
-		return a
-	}
-	chunkEnded := func(data []byte) (int, int) {
-		irr := bytes.Index(data, []byte("\r\r"))
-		inn := bytes.Index(data, []byte("\n\n"))
-		irnn := bytes.Index(data, []byte("\r\n\n"))
-		inrn := bytes.Index(data, []byte("\n\r\n"))
-		irnrn := bytes.Index(data, []byte("\r\n\r\n"))
-		st := minial(irr, minial(inn, minial(irnn, minial(inrn, irnrn))))
-		n := 2
-		if st == irnrn {
-			n = 4
-		} else if st == irnn || st == inrn {
-			n = 3
+		if s.log != nil {
+			s.log.Debugf("message received: %#+v", e)
 		}
-		return st, n
-	}
-	if i, n := chunkEnded(data); i >= 0 {
-		return i + n, data[0:i], nil
-	}
-	if atEOF {
-		return len(data), data, nil
-	}
-	return 0, nil, nil
-}
-func (s *notifier) asyncRead(ctx context.Context) (out chan []byte, err chan error) {
-	defer s.Response.Body.Close()
-	var sc = bufio.NewScanner(s.Response.Body)
-	sc.Buffer(make([]byte, 1024), int(s.dataBuf))
-	sc.Split(chunkSplit)
-	out = make(chan []byte, s.buf)
-	err = make(chan error)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				if s.log != nil {
-					s.log.Debugf("context done")
-				}
-				err <- context.DeadlineExceeded
+		if s.emit != nil {
+			if err = s.emit(e); err != nil {
 				return
-			default:
-				if sc.Scan() {
-					out <- sc.Bytes()
-				}
-				if er := sc.Err(); err != nil {
-					if er == context.Canceled {
-						if s.log != nil {
-							s.log.Debugf("connect fail")
-						}
-						err <- io.EOF
-					} else {
-						err <- er
-					}
-					return
-				}
 			}
+		} else if s.onEvent != nil {
+			s.onEvent(e.ID, e.Event, e.Data)
 		}
-	}()
-	return
+	}
 }
 
 //endregion notifier
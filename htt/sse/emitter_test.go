@@ -0,0 +1,54 @@
+package sse
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEmitterKeepalive(t *testing.T) {
+	drain := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := Keepalive{Heartbeat: 20 * time.Millisecond, WriteWait: time.Second, Lifetime: 300 * time.Millisecond, Reconnect: 50}
+		if r.URL.Path == "/drain" {
+			k.Drain = drain
+		}
+		e := NewEmitter(nil, w).Keepalive(k)
+		if err := e.Await(r.Context()); err != nil {
+			t.Error(err)
+		}
+	}))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+	stream := func(path string) (time.Duration, int, int) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+		start := time.Now()
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		pings := 0
+		d := NewDecoder(res.Body, 0)
+		d.OnComment = func(string) { pings++ }
+		var e Event
+		if err = d.Decode(&e); err != io.EOF {
+			t.Fatal(err)
+		}
+		return time.Since(start), pings, d.Retry()
+	}
+	//stream outlives the server WriteTimeout with heartbeats, then told to reconnect
+	if took, pings, retry := stream("/"); took < 250*time.Millisecond || pings < 5 || retry != 50 {
+		t.Fatalf("lifetime: took %s pings %d retry %d", took, pings, retry)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { close(drain) })
+	if took, _, retry := stream("/drain"); took > 200*time.Millisecond || retry != 50 {
+		t.Fatalf("drain: took %s retry %d", took, retry)
+	}
+}
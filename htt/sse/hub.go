@@ -0,0 +1,118 @@
+package sse
+
+import (
+	"context"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/htt/hub"
+	"net/http"
+	"sync"
+)
+
+type (
+	// Event a server-sent event
+	Event struct {
+		ID      string
+		Event   string
+		Data    string
+		Retry   int    //reconnection time in milliseconds, ignored when not positive
+		Comment string //comment line, such as heartbeat
+	}
+	// Hub fan out events to SSE streams by topic and user, see [hub.Hub] for publishing and metrics.
+	//
+	// Each stream has a bounded queue, slow streams are evicted when the queue is full.
+	// Close the hub on server shutdown to end all streams, such as in OnBeforeShutdown of htt.Server,
+	// or set [Hub.Drain] to end streams of the draining server only, which clients reconnect to other instances.
+	//
+	// With [Hub.Replay], events published to topics are retained, and reconnecting streams receive the missed events
+	// after the Last-Event-ID before live events.
+	Hub struct {
+		*hub.Hub[Event]
+		Queue     int         //queue size of each stream, default 64
+		Replay    ReplayStore //retain topic events for reconnecting clients, optional
+		Keepalive Keepalive   //keepalive of streams, default [DefaultKeepalive]
+		// Drain resolve the drain channel of a stream from its context, such as htt.Draining, which overrides Keepalive.Drain. optional
+		Drain   func(ctx context.Context) <-chan struct{}
+		publish sync.Mutex //orders assigning ids and fanning out with Replay
+	}
+)
+
+// NewHub create [Hub], queue is the queue size of each stream.
+func NewHub(queue int) *Hub {
+	if queue < 1 {
+		queue = 64
+	}
+	return &Hub{Hub: hub.New[Event](), Queue: queue, Keepalive: DefaultKeepalive}
+}
+
+// Publish event to subscribers of topic, returns count of streams delivered.
+// With [Hub.Replay] the event is retained first, and an ID is assigned when absent.
+// Publishing with Replay is serialized, so streams receive events in the order of their IDs.
+func (h *Hub) Publish(topic string, e Event) int {
+	if h.Replay != nil {
+		h.publish.Lock()
+		defer h.publish.Unlock()
+		var err error
+		if e, err = h.Replay.Append(topic, e); err != nil {
+			conf.Internal().Warnf("sse replay append to %s failed: %s", topic, err)
+		}
+	}
+	return h.Hub.Publish(topic, e)
+}
+
+// Serve stream events of the topics and the user to w, user is optional.
+// It blocks until ctx done, the client disconnected, the stream evicted, expired, drained or the hub closed, returns [ErrClosed] when closed by the hub.
+// Streams are kept alive by [Hub.Keepalive], write failures returns error joined with [ErrConnFailure].
+func (h *Hub) Serve(ctx context.Context, w http.ResponseWriter, user string, topics ...string) error {
+	return h.ServeFrom(ctx, w, "", user, topics...)
+}
+
+// ServeFrom same as [Hub.Serve], but replays events of topics after lastID first, see [LastEventID].
+func (h *Hub) ServeFrom(ctx context.Context, w http.ResponseWriter, lastID, user string, topics ...string) error {
+	if ctx == nil {
+		return ErrContextRequired
+	}
+	s := newEmitter(nil, w, h.Queue)
+	s.keepalive = h.Keepalive
+	if h.Drain != nil {
+		s.keepalive.Drain = h.Drain(ctx)
+	}
+	if err := h.Join(s, user, topics...); err != nil {
+		return err
+	}
+	defer h.Leave(s)
+	if lastID != "" && h.Replay != nil {
+		missed, err := replay(h.Replay, lastID, topics)
+		if err != nil {
+			conf.Internal().Warnf("sse replay after %s failed: %s", lastID, err)
+		}
+		if len(missed) > 0 { //events already replayed may also be queued after joined
+			s.prefix = missed
+			s.skip = make(map[string]struct{}, len(missed))
+			for _, e := range missed {
+				s.skip[e.ID] = struct{}{}
+			}
+		}
+	}
+	s.writeHeader()
+	err := s.Await(ctx)
+	switch {
+	case err != nil:
+		return err
+	case ctx.Err() != nil || s.expired:
+		return nil
+	default:
+		return ErrClosed
+	}
+}
+
+// Handler serve streams, topics and user are extracted from request, user is optional.
+// Missed events are replayed to reconnecting clients when [Hub.Replay] is set.
+func (h *Hub) Handler(topics func(r *http.Request) []string, user func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var u string
+		if user != nil {
+			u = user(r)
+		}
+		_ = h.ServeFrom(r.Context(), w, LastEventID(r), u, topics(r)...)
+	})
+}
@@ -0,0 +1,269 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"github.com/ZenLiuCN/gofra/htt/hub"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hubServer serve h by [Hub.Handler], topics from query topic, user from query user. Results of ServeFrom are sent to the channel.
+func hubServer(t *testing.T, h *Hub) (*httptest.Server, <-chan error) {
+	served := make(chan error, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served <- h.ServeFrom(r.Context(), w, LastEventID(r), r.URL.Query().Get("user"), r.URL.Query()["topic"]...)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, served
+}
+
+type hubStream struct {
+	events chan Event
+	retry  atomic.Int64
+	cancel context.CancelFunc
+}
+
+// subscribe open a stream, waits until the hub has n connections
+func subscribe(t *testing.T, h *Hub, srv *httptest.Server, query, lastID string, n int) *hubStream {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?"+query, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &hubStream{events: make(chan Event, 16), cancel: cancel}
+	go func() {
+		defer close(s.events)
+		defer res.Body.Close()
+		d := NewDecoder(res.Body, 0)
+		for {
+			var e Event
+			if err := d.Decode(&e); err != nil {
+				s.retry.Store(int64(d.Retry()))
+				return
+			}
+			s.events <- e
+		}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); h.Stats().Connections < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("stream %s not joined", query)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return s
+}
+
+// next event of stream, or the zero event when stream ended
+func (s *hubStream) next(t *testing.T) Event {
+	t.Helper()
+	select {
+	case e := <-s.events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not received")
+		return Event{}
+	}
+}
+
+func served(t *testing.T, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve not returned")
+		return nil
+	}
+}
+
+func TestHubServe(t *testing.T) {
+	h := NewHub(8)
+	srv, results := hubServer(t, h)
+	news := subscribe(t, h, srv, "topic=news&user=alice", "", 1)
+	sport := subscribe(t, h, srv, "topic=sport&user=bob", "", 2)
+	if n := h.Publish("news", Event{Event: "headline", Data: "a\nb"}); n != 1 {
+		t.Fatalf("published to %d", n)
+	}
+	if e := news.next(t); e.Event != "headline" || e.Data != "a\nb" {
+		t.Fatalf("news received %+v", e)
+	}
+	h.SendUser("bob", Event{ID: "1", Data: "direct"})
+	if e := sport.next(t); e.ID != "1" || e.Data != "direct" {
+		t.Fatalf("user received %+v", e)
+	}
+	news.cancel()
+	if err := served(t, results); err != nil {
+		t.Fatalf("client gone should return nil, got %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); h.Online("alice"); {
+		if time.Now().After(deadline) {
+			t.Fatal("stream not left after client gone")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-sport.events; ok {
+		t.Fatal("stream should end when hub closed")
+	}
+	if err := served(t, results); !errors.Is(err, ErrClosed) {
+		t.Fatalf("hub closed should return ErrClosed, got %v", err)
+	}
+	if err := h.Serve(context.Background(), httptest.NewRecorder(), ""); !errors.Is(err, hub.ErrClosed) {
+		t.Fatalf("serve after close %v", err)
+	}
+	if err := NewHub(1).Serve(nil, httptest.NewRecorder(), ""); !errors.Is(err, ErrContextRequired) {
+		t.Fatalf("nil context %v", err)
+	}
+}
+
+func TestHubServeFrom(t *testing.T) {
+	h := NewHub(8)
+	h.Replay = NewMemoryReplay(8)
+	srv, _ := hubServer(t, h)
+	var ids []string
+	for _, d := range []string{"a", "b", "c"} {
+		e, _ := h.Replay.Append("news", Event{Data: d})
+		ids = append(ids, e.ID)
+	}
+	s := subscribe(t, h, srv, "topic=news", ids[0], 1)
+	h.Publish("news", Event{ID: ids[2], Data: "c"}) //queued after joined, already replayed
+	h.Publish("news", Event{Data: "d"})
+	h.Publish("news", Event{ID: ids[1], Data: "reused"}) //not skipped after live events
+	for _, want := range []string{"b", "c", "d", "reused"} {
+		if e := s.next(t); e.Data != want {
+			t.Fatalf("received %+v, want %s", e, want)
+		}
+	}
+	select {
+	case e := <-s.events:
+		t.Fatalf("duplicated %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHubDrain(t *testing.T) {
+	drain := make(chan struct{})
+	h := NewHub(8)
+	h.Keepalive.Reconnect = 50
+	h.Drain = func(ctx context.Context) <-chan struct{} {
+		if ctx == nil {
+			t.Error("drain of nil context")
+		}
+		return drain
+	}
+	srv, results := hubServer(t, h)
+	s := subscribe(t, h, srv, "topic=news", "", 1)
+	h.Publish("news", Event{Data: "before"})
+	if e := s.next(t); e.Data != "before" {
+		t.Fatalf("received %+v", e)
+	}
+	close(drain)
+	if err := served(t, results); err != nil {
+		t.Fatalf("drained should return nil, got %v", err)
+	}
+	if _, ok := <-s.events; ok || s.retry.Load() != 50 {
+		t.Fatalf("stream should end with reconnect, retry %d", s.retry.Load())
+	}
+	if h.Stats().Connections != 0 {
+		t.Fatal("drained stream not left")
+	}
+}
+
+// stuckWriter blocks writes until released, like a client not reading
+type stuckWriter struct {
+	header  http.Header
+	release chan struct{}
+	writes  atomic.Int32
+}
+
+func (w *stuckWriter) Header() http.Header { return w.header }
+func (w *stuckWriter) WriteHeader(int)     {}
+func (w *stuckWriter) Flush()              {}
+func (w *stuckWriter) Write(b []byte) (int, error) {
+	if w.writes.Add(1) > 1 {
+		<-w.release
+	}
+	return len(b), nil
+}
+
+func TestHubEvict(t *testing.T) {
+	h := NewHub(1)
+	h.Keepalive = Keepalive{}
+	evicted := make(chan hub.Client[Event], 1)
+	h.OnEvict = func(c hub.Client[Event]) { evicted <- c }
+	w := &stuckWriter{header: http.Header{}, release: make(chan struct{})}
+	result := make(chan error, 1)
+	go func() { result <- h.Serve(context.Background(), w, "slow", "news") }()
+	for h.Count("news") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	h.Publish("news", Event{Data: "1"}) //written, blocks the writer
+	for w.writes.Load() < 2 {
+		time.Sleep(time.Millisecond)
+		h.Publish("news", Event{Data: "x"})
+	}
+	h.Publish("news", Event{Data: "2"}) //queued
+	h.Publish("news", Event{Data: "3"}) //queue full
+	select {
+	case <-evicted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow stream not evicted")
+	}
+	if h.Online("slow") || h.Stats().Evicted != 1 {
+		t.Fatalf("stats %+v", h.Stats())
+	}
+	close(w.release)
+	if err := <-result; !errors.Is(err, ErrClosed) {
+		t.Fatalf("evicted should return ErrClosed, got %v", err)
+	}
+}
+
+// slowReplay delays the first append after its id assigned, like a store with latency
+type slowReplay struct {
+	*MemoryReplay
+	slow atomic.Bool
+}
+
+func (r *slowReplay) Append(topic string, e Event) (Event, error) {
+	e, err := r.MemoryReplay.Append(topic, e)
+	if r.slow.CompareAndSwap(false, true) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return e, err
+}
+
+func TestHubPublishOrder(t *testing.T) {
+	h := NewHub(8)
+	h.Replay = &slowReplay{MemoryReplay: NewMemoryReplay(8)}
+	srv, _ := hubServer(t, h)
+	s := subscribe(t, h, srv, "topic=news", "", 1)
+	var wg sync.WaitGroup
+	for _, d := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(d string) {
+			defer wg.Done()
+			h.Publish("news", Event{Data: d})
+		}(d)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	a, b := s.next(t), s.next(t)
+	x, _ := strconv.ParseUint(a.ID, 10, 64)
+	y, _ := strconv.ParseUint(b.ID, 10, 64)
+	if a.Data != "first" || x >= y {
+		t.Fatalf("received %+v before %+v", a, b)
+	}
+}
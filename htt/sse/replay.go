@@ -0,0 +1,128 @@
+package sse
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// ReplayStore keeps published events for reconnecting clients, implement it for durable stores.
+	ReplayStore interface {
+		// Append store the event of topic, returns the stored event. An ID is assigned when absent, which should be monotonically increasing.
+		Append(topic string, e Event) (Event, error)
+		// Since returns the retained events of topic after the event of id in order.
+		Since(topic, id string) ([]Event, error)
+	}
+	// MemoryReplay bounded in-memory ring of events per topic
+	MemoryReplay struct {
+		Size   int //events retained per topic
+		seq    atomic.Uint64
+		lock   sync.RWMutex
+		topics map[string]*replayRing
+	}
+	replayRing struct {
+		events []Event
+		next   int
+		full   bool
+	}
+)
+
+// NewMemoryReplay create [MemoryReplay] retains size events per topic, default 256.
+// Generated IDs start from current unix microseconds, so they keep increasing after restarts.
+func NewMemoryReplay(size int) *MemoryReplay {
+	if size < 1 {
+		size = 256
+	}
+	m := &MemoryReplay{Size: size, topics: map[string]*replayRing{}}
+	m.seq.Store(uint64(time.Now().UnixMicro()))
+	return m
+}
+
+// NextID generate next ID
+func (m *MemoryReplay) NextID() string {
+	return strconv.FormatUint(m.seq.Add(1), 10)
+}
+
+func (m *MemoryReplay) Append(topic string, e Event) (Event, error) {
+	if e.ID == "" {
+		e.ID = m.NextID()
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r, ok := m.topics[topic]
+	if !ok {
+		r = &replayRing{events: make([]Event, m.Size)}
+		m.topics[topic] = r
+	}
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+	return e, nil
+}
+
+// Since returns events after id. When id is not retained, numeric ids returns events with greater id,
+// otherwise nothing is returned, as the position of id is unknown.
+func (m *MemoryReplay) Since(topic, id string) ([]Event, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	r, ok := m.topics[topic]
+	if !ok {
+		return nil, nil
+	}
+	var events []Event
+	if r.full {
+		events = append(events, r.events[r.next:]...)
+	}
+	events = append(events, r.events[:r.next]...)
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].ID == id {
+			return events[i+1:], nil
+		}
+	}
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+		for i, e := range events {
+			if x, err := strconv.ParseUint(e.ID, 10, 64); err == nil && x > n {
+				return events[i:], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// LastEventID of reconnecting client from header Last-Event-ID, or query lastEventId for polyfills
+func LastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// replay events of topics after id. When topics more than one, events are sorted by numeric id,
+// events of non-numeric ids follow in the order of topics.
+func replay(store ReplayStore, id string, topics []string) (events []Event, err error) {
+	for _, t := range topics {
+		var x []Event
+		if x, err = store.Since(t, id); err != nil {
+			return
+		}
+		events = append(events, x...)
+	}
+	if len(topics) > 1 {
+		sort.SliceStable(events, func(i, j int) bool {
+			a, ea := strconv.ParseUint(events[i].ID, 10, 64)
+			b, eb := strconv.ParseUint(events[j].ID, 10, 64)
+			if ea != nil || eb != nil {
+				return ea == nil && eb != nil
+			}
+			return a < b
+		})
+	}
+	return
+}
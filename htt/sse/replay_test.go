@@ -0,0 +1,72 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func replayData(events []Event) string {
+	var b strings.Builder
+	for _, e := range events {
+		b.WriteString(e.Data)
+	}
+	return b.String()
+}
+
+func TestMemoryReplay(t *testing.T) {
+	m := NewMemoryReplay(3)
+	var ids []string
+	for _, d := range []string{"a", "b", "c", "d"} {
+		e, err := m.Append("news", Event{Data: d})
+		if err != nil || e.ID == "" {
+			t.Fatalf("append %+v %v", e, err)
+		}
+		ids = append(ids, e.ID)
+	}
+	_, _ = m.Append("news", Event{ID: "custom", Data: "e"})
+	for _, c := range []struct{ id, want string }{
+		{ids[2], "de"},     //retained
+		{"custom", ""},     //latest
+		{ids[0], "cde"},    //evicted numeric, events with greater id
+		{"0", "cde"},       //older than all
+		{"unknown", ""},    //position unknown
+		{ids[3] + "0", ""}, //newer than all
+	} {
+		if x, err := m.Since("news", c.id); err != nil || replayData(x) != c.want {
+			t.Fatalf("since %s: %q %v, want %q", c.id, replayData(x), err, c.want)
+		}
+	}
+	if x, err := m.Since("none", ids[0]); err != nil || x != nil {
+		t.Fatalf("unknown topic %v %v", x, err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	m := NewMemoryReplay(8)
+	_, _ = m.Append("a", Event{ID: "1", Data: "1"})
+	_, _ = m.Append("a", Event{ID: "x", Data: "x"})
+	_, _ = m.Append("a", Event{ID: "10", Data: "10"})
+	_, _ = m.Append("b", Event{ID: "y", Data: "y"})
+	_, _ = m.Append("b", Event{ID: "2", Data: "2"})
+	_, _ = m.Append("b", Event{ID: "9", Data: "9"})
+	events, err := replay(m, "0", []string{"a", "b"})
+	if err != nil || replayData(events) != "12910x" {
+		t.Fatalf("replay %q %v", replayData(events), err)
+	}
+	if events, _ = replay(m, "x", []string{"a"}); replayData(events) != "10" {
+		t.Fatalf("replay single topic %q", replayData(events))
+	}
+}
+
+func TestEmitterSkip(t *testing.T) {
+	s := newEmitter(nil, httptest.NewRecorder(), 8)
+	s.skip = map[string]struct{}{"1": {}, "2": {}}
+	var b strings.Builder
+	for _, e := range []Event{{ID: "1", Data: "dup"}, {Data: "direct"}, {ID: "3", Data: "new"}, {ID: "2", Data: "reused"}} {
+		s.encode(&b, &e)
+	}
+	if got := b.String(); strings.Contains(got, "dup") || !strings.Contains(got, "direct") || !strings.Contains(got, "reused") || s.skip != nil {
+		t.Fatalf("encoded %q, skip %v", got, s.skip)
+	}
+}
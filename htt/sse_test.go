@@ -1,10 +1,12 @@
 package htt
 
 import (
+	"context"
 	"errors"
 	"github.com/ZenLiuCN/fn"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -73,3 +75,31 @@ func TestSSEContext(t *testing.T) {
 		t.Logf("done")
 	})))
 }
+
+func TestSSEShim(t *testing.T) {
+	w := httptest.NewRecorder()
+	s := NewSSE(w)
+	_ = s.Send("a")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != io.EOF {
+		t.Fatalf("second close %v", err)
+	}
+	if err := s.Await(context.Background()); err != io.EOF {
+		t.Fatalf("await after close %v", err)
+	}
+	if err := s.Send("b"); err != io.EOF {
+		t.Fatalf("send after close %v", err)
+	}
+
+	s = NewSSE(httptest.NewRecorder())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Await(ctx); err != nil {
+		t.Fatalf("await %v", err)
+	}
+	if err := s.Await(ctx); err != io.EOF {
+		t.Fatalf("second await %v", err)
+	}
+}
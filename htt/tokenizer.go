@@ -1,12 +1,58 @@
 package htt
 
 import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
 	"github.com/ZenLiuCN/gofra/conf"
 	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	method jwt.SigningMethod
+	method    jwt.SigningMethod
+	tokenizer *Tokenizer
+	// ErrTokenMissing the request not contains a token
+	ErrTokenMissing = errors.New("token missing")
+	// ErrJwtNotConfigured the jwt not configured via [ConfigJwt]
+	ErrJwtNotConfigured = errors.New("jwt not configured")
+	// ErrJwtKeyNotFound no key matches the kid of token
+	ErrJwtKeyNotFound = errors.New("jwt key not found")
+	// ErrJwtKeyExpired the key of token is retired
+	ErrJwtKeyExpired = errors.New("jwt key expired")
+)
+
+type (
+	// Tokenizer sign and verify jwt tokens with a set of keys identified by kid.
+	//
+	// New tokens are signed by the newest active key, older keys still verify tokens until they expired,
+	// so keys can be rotated by [Tokenizer.AddKey] without invalidate issued tokens.
+	Tokenizer struct {
+		Issuer   string        //optional issuer to verify
+		Audience []string      //optional accepted audiences, any of them should present in the token
+		Leeway   time.Duration //leeway for time based claims
+		Cookie   string        //optional cookie name to read token from, when Authorization header absent
+		Remote   *JwksClient   //optional remote keys to verify tokens from other services
+		//optional store of revoked tokens and sessions, checked by [Tokenizer.VerifyContext]
+		Revocation RevocationStore
+		lock       sync.RWMutex
+		keys       []*JwtKey
+	}
+	// JwtKey a signing key
+	JwtKey struct {
+		ID        string //the kid
+		Method    jwt.SigningMethod
+		SignKey   any       //the key to sign: []byte for HMAC, otherwise a crypto.Signer. nil for verify only key.
+		VerifyKey any       //the key to verify: []byte for HMAC, otherwise a crypto.PublicKey
+		Activate  time.Time //the time key begin to sign, zero means immediately
+		Expire    time.Time //the time key stop to verify, zero means never. should be later than last token signed by this key expires.
+	}
 )
 
 // JwtInitialize initialize jwt
@@ -14,12 +60,389 @@ func JwtInitialize() {
 	ConfigJwt(conf.GetConfig())
 }
 
-// ConfigJwt manually config jwt token.
+/*
+ConfigJwt manually config jwt token.
+
+When the keys can't be loaded (e.g. only jwt.sign is configured), it falls back to only set the signing method
+used by [Generate] and leaves the default [Tokenizer] unset. Use [MustConfigJwt] to fail on invalid keys.
+
+HOCON sample:
+
+	jwt{
+	 sign: HS256 # signing method
+	 kid: "" # optional key id
+	 secret: "the HMAC secret"
+	 privateKey: "file path or PEM content of RSA/ECDSA/EdDSA private key"
+	 publicKey: "file path or PEM content of public key, optional when privateKey exists"
+	 # multiple keys for rotation, override above single key
+	 keys: [
+	   {kid: "k1", sign: ES256, privateKey: "k1.pem", expire: "2024-08-01T00:00:00Z"}
+	   {kid: "k2", sign: ES256, privateKey: "k2.pem", activate: "2024-07-01T00:00:00Z"}
+	 ]
+	 jwks{ # optional remote JWKS to verify tokens
+	  url: "https://issuer/.well-known/jwks.json"
+	  ttl: 10m
+	 }
+	 issuer: "issuer to verify"
+	 audience: ["audiences to accept"]
+	 leeway: 30s
+	 cookie: "" # cookie name to read token from
+	}
+*/
 func ConfigJwt(conf conf.Config) {
-	method = jwt.GetSigningMethod(conf.GetString("jwt.sign"))
+	t, err := NewTokenizer(conf)
+	if err != nil {
+		method = jwt.GetSigningMethod(conf.GetString("jwt.sign"))
+		tokenizer = nil
+		return
+	}
+	useTokenizer(t)
+}
+
+// MustConfigJwt like [ConfigJwt], but panics when the keys are invalid.
+func MustConfigJwt(conf conf.Config) {
+	t, err := NewTokenizer(conf)
+	if err != nil {
+		panic(err)
+	}
+	useTokenizer(t)
+}
+
+func useTokenizer(t *Tokenizer) {
+	if k := t.Current(); k != nil {
+		method = k.Method
+	}
+	tokenizer = t
+}
+
+// NewTokenizer create [Tokenizer] from config, see [ConfigJwt] for HOCON sample.
+func NewTokenizer(c conf.Config) (t *Tokenizer, err error) {
+	t = new(Tokenizer)
+	if c.HasPath("jwt.keys") {
+		for _, kc := range c.GetObjects("jwt.keys") {
+			var k *JwtKey
+			if k, err = parseJwtKey(kc); err != nil {
+				return nil, err
+			}
+			t.keys = append(t.keys, k)
+		}
+	} else if c.HasPath("jwt.sign") {
+		var k *JwtKey
+		if k, err = parseJwtKey(c.GetObject("jwt")); err != nil {
+			return nil, err
+		}
+		t.keys = append(t.keys, k)
+	}
+	if c.HasPath("jwt.jwks.url") {
+		t.Remote = NewJwksClient(c.GetString("jwt.jwks.url"), c.GetTimeDuration("jwt.jwks.ttl", 10*time.Minute))
+	}
+	if len(t.keys) == 0 && t.Remote == nil {
+		return nil, errors.New("jwt keys or jwks required")
+	}
+	t.Issuer = c.GetString("jwt.issuer")
+	if c.IsArray("jwt.audience") {
+		t.Audience = c.GetStringList("jwt.audience")
+	} else if v := c.GetString("jwt.audience"); v != "" {
+		t.Audience = []string{v}
+	}
+	t.Leeway = c.GetTimeDuration("jwt.leeway", 0)
+	t.Cookie = c.GetString("jwt.cookie")
+	return
+}
+
+func parseJwtKey(c conf.Config) (k *JwtKey, err error) {
+	k = &JwtKey{ID: c.GetString("kid")}
+	if k.Method = jwt.GetSigningMethod(c.GetString("sign")); k.Method == nil {
+		return nil, fmt.Errorf("unknown jwt signing method '%s'", c.GetString("sign"))
+	}
+	if k.SignKey, k.VerifyKey, err = loadJwtKeys(k.Method, c.GetString("secret"), c.GetString("privateKey"), c.GetString("publicKey")); err != nil {
+		return nil, err
+	}
+	if v := c.GetString("activate"); v != "" {
+		if k.Activate, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("jwt key %s activate: %w", k.ID, err)
+		}
+	}
+	if v := c.GetString("expire"); v != "" {
+		if k.Expire, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("jwt key %s expire: %w", k.ID, err)
+		}
+	}
+	return
+}
+
+func readPEM(v string) ([]byte, error) {
+	if v == "" || strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN") {
+		return []byte(v), nil
+	}
+	return os.ReadFile(v)
+}
+
+func loadJwtKeys(m jwt.SigningMethod, secret, private, public string) (sign, verify any, err error) {
+	if _, ok := m.(*jwt.SigningMethodHMAC); ok {
+		if secret == "" {
+			return nil, nil, errors.New("jwt secret required for " + m.Alg())
+		}
+		return []byte(secret), []byte(secret), nil
+	}
+	var pri, pub []byte
+	if pri, err = readPEM(private); err != nil {
+		return
+	}
+	if pub, err = readPEM(public); err != nil {
+		return
+	}
+	if len(pri) > 0 {
+		switch m.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			sign, err = jwt.ParseRSAPrivateKeyFromPEM(pri)
+		case *jwt.SigningMethodECDSA:
+			sign, err = jwt.ParseECPrivateKeyFromPEM(pri)
+		case *jwt.SigningMethodEd25519:
+			sign, err = jwt.ParseEdPrivateKeyFromPEM(pri)
+		default:
+			err = fmt.Errorf("unsupported jwt signing method %s", m.Alg())
+		}
+		if err != nil {
+			return
+		}
+	}
+	if len(pub) > 0 {
+		switch m.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			verify, err = jwt.ParseRSAPublicKeyFromPEM(pub)
+		case *jwt.SigningMethodECDSA:
+			verify, err = jwt.ParseECPublicKeyFromPEM(pub)
+		case *jwt.SigningMethodEd25519:
+			verify, err = jwt.ParseEdPublicKeyFromPEM(pub)
+		}
+		if err != nil {
+			return
+		}
+	} else if s, ok := sign.(crypto.Signer); ok {
+		verify = s.Public()
+	}
+	if verify == nil {
+		err = errors.New("jwt privateKey or publicKey required for " + m.Alg())
+	}
+	return
 }
 
 // Generate generate jwt token from claims
 func Generate(claims jwt.Claims) *jwt.Token {
-	return jwt.NewWithClaims(method, claims)
+	t := jwt.NewWithClaims(method, claims)
+	if tokenizer != nil {
+		if k := tokenizer.Current(); k != nil && k.ID != "" {
+			t.Header["kid"] = k.ID
+		}
+	}
+	return t
+}
+
+// Sign claims to token string with default [Tokenizer]
+func Sign(claims jwt.Claims) (string, error) {
+	if tokenizer == nil {
+		return "", ErrJwtNotConfigured
+	}
+	return tokenizer.Sign(claims)
+}
+
+// Verify token string with default [Tokenizer], claims is optional, default is [jwt.MapClaims]
+func Verify(token string, claims jwt.Claims) (*jwt.Token, error) {
+	if tokenizer == nil {
+		return nil, ErrJwtNotConfigured
+	}
+	return tokenizer.Verify(token, claims)
+}
+
+// AddKey add a key for rotation, the key with latest activate time will sign new tokens once activated.
+// A key with same kid will be replaced. Expired keys are removed.
+func (t *Tokenizer) AddKey(k *JwtKey) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.keys = slices.DeleteFunc(t.keys, func(x *JwtKey) bool {
+		return x.ID == k.ID || (!x.Expire.IsZero() && x.Expire.Before(now))
+	})
+	t.keys = append(t.keys, k)
+}
+
+// Keys snapshot of all keys
+func (t *Tokenizer) Keys() []*JwtKey {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return slices.Clone(t.keys)
+}
+
+// Current the key to sign new tokens: the active key with latest activate time.
+func (t *Tokenizer) Current() (k *JwtKey) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	now := time.Now()
+	for _, x := range t.keys {
+		if x.SignKey == nil || x.Activate.After(now) || (!x.Expire.IsZero() && !x.Expire.After(now)) {
+			continue
+		}
+		if k == nil || !x.Activate.Before(k.Activate) {
+			k = x
+		}
+	}
+	return
+}
+
+func (t *Tokenizer) lookup(kid string) (k *JwtKey, err error) {
+	if kid == "" {
+		k = t.Current()
+	} else {
+		t.lock.RLock()
+		for _, x := range t.keys {
+			if x.ID == kid {
+				k = x
+				break
+			}
+		}
+		t.lock.RUnlock()
+	}
+	if k == nil && t.Remote != nil {
+		return t.Remote.Lookup(kid)
+	}
+	if k == nil {
+		return nil, ErrJwtKeyNotFound
+	}
+	if !k.Expire.IsZero() && !k.Expire.After(time.Now()) {
+		return nil, ErrJwtKeyExpired
+	}
+	return
+}
+
+// Sign claims to token string with current key
+func (t *Tokenizer) Sign(claims jwt.Claims) (string, error) {
+	k := t.Current()
+	if k == nil {
+		return "", errors.New("jwt sign key not configured")
+	}
+	tk := jwt.NewWithClaims(k.Method, claims)
+	if k.ID != "" {
+		tk.Header["kid"] = k.ID
+	}
+	return tk.SignedString(k.SignKey)
+}
+
+// Verify token string with signature, expiration, issuer and audience. claims is optional, default is [jwt.MapClaims]
+func (t *Tokenizer) Verify(token string, claims jwt.Claims) (tk *jwt.Token, err error) {
+	return t.parse(context.Background(), token, claims, true)
+}
+
+// VerifyContext like [Tokenizer.Verify], the context is used to check [Tokenizer.Revocation]
+func (t *Tokenizer) VerifyContext(ctx context.Context, token string, claims jwt.Claims) (tk *jwt.Token, err error) {
+	return t.parse(ctx, token, claims, true)
+}
+
+func (t *Tokenizer) parse(ctx context.Context, token string, claims jwt.Claims, revocation bool) (tk *jwt.Token, err error) {
+	if claims == nil {
+		claims = jwt.MapClaims{}
+	}
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if t.Leeway > 0 {
+		opts = append(opts, jwt.WithLeeway(t.Leeway))
+	}
+	if t.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(t.Issuer))
+	}
+	tk, err = jwt.NewParser(opts...).ParseWithClaims(token, claims, func(tk *jwt.Token) (any, error) {
+		kid, _ := tk.Header["kid"].(string)
+		k, err := t.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if k.Method != nil && k.Method.Alg() != tk.Method.Alg() {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return k.VerifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Audience) > 0 {
+		aud, err := tk.Claims.GetAudience()
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(aud, func(s string) bool { return slices.Contains(t.Audience, s) }) {
+			return nil, jwt.ErrTokenInvalidAudience
+		}
+	}
+	if revocation {
+		if err = t.checkRevoked(ctx, tk); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Extract the token string from Authorization bearer header or configured cookie.
+func (t *Tokenizer) Extract(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	if t.Cookie != "" {
+		if c, err := r.Cookie(t.Cookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+type jwtTokenKey struct{}
+
+// TokenOf fetch the verified token from context, which set by [RouterConfigurer.WithJWT]
+func TokenOf(ctx context.Context) *jwt.Token {
+	t, _ := ctx.Value(jwtTokenKey{}).(*jwt.Token)
+	return t
+}
+
+// ClaimsOf fetch the typed claims of verified token from context, which set by [RouterConfigurer.WithJWT]
+func ClaimsOf[T jwt.Claims](ctx context.Context) (v T, ok bool) {
+	t := TokenOf(ctx)
+	if t == nil {
+		return
+	}
+	v, ok = t.Claims.(T)
+	return
+}
+
+// Middleware verify token of each request, claims create a new claims for each request, nil for [jwt.MapClaims].
+// The verified token is stored in request context, see [TokenOf] and [ClaimsOf].
+func (t *Tokenizer) Middleware(claims func() jwt.Claims) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := t.Extract(r)
+			if s == "" {
+				unauthorized(w, ErrTokenMissing)
+				return
+			}
+			var c jwt.Claims
+			if claims != nil {
+				c = claims()
+			}
+			tk, err := t.VerifyContext(r.Context(), s, c)
+			if err == nil && readTokenIDs(tk.Raw).Typ == TokenTypeRefresh {
+				err = ErrTokenType
+			}
+			if err != nil {
+				unauthorized(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtTokenKey{}, tk)))
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTokenMissing) {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	writeJsonError(w, http.StatusUnauthorized, err.Error())
 }
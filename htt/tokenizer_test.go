@@ -0,0 +1,303 @@
+package htt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func claimsFor(sub string) jwt.Claims {
+	return jwt.RegisteredClaims{Subject: sub, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func TestTokenizerRotation(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rs, _ := rsa.GenerateKey(rand.Reader, 2048)
+	tk := new(Tokenizer)
+	tk.AddKey(&JwtKey{ID: "k1", Method: jwt.SigningMethodES256, SignKey: ec, VerifyKey: &ec.PublicKey})
+	old, err := tk.Sign(claimsFor("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.AddKey(&JwtKey{ID: "k2", Method: jwt.SigningMethodRS256, SignKey: rs, VerifyKey: &rs.PublicKey, Activate: time.Now().Add(-time.Second)})
+	fresh, err := tk.Sign(claimsFor("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := jwt.NewParser().Parse(fresh, nil); v == nil || v.Header["kid"] != "k2" {
+		t.Fatalf("new token should signed by newest key")
+	}
+	for _, s := range []string{old, fresh} {
+		if _, err = tk.Verify(s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retired := *tk.Keys()[0]
+	retired.Expire = time.Now().Add(-time.Second)
+	tk.AddKey(&retired)
+	if _, err = tk.Verify(old, nil); !errors.Is(err, ErrJwtKeyExpired) {
+		t.Fatalf("expect key expired, got %v", err)
+	}
+}
+
+func TestJwksClient(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rs, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	issuer := new(Tokenizer)
+	issuer.AddKey(&JwtKey{ID: "ec", Method: jwt.SigningMethodES384, SignKey: ec, VerifyKey: &ec.PublicKey})
+	issuer.AddKey(&JwtKey{ID: "rs", Method: jwt.SigningMethodRS256, SignKey: rs, VerifyKey: &rs.PublicKey})
+	srv := httptest.NewServer(issuer)
+	defer srv.Close()
+	verifier := &Tokenizer{Remote: NewJwksClient(srv.URL, time.Minute)}
+	tokens := make([]string, 0, 3)
+	for _, k := range issuer.Keys() {
+		tk := jwt.NewWithClaims(k.Method, claimsFor(k.ID))
+		tk.Header["kid"] = k.ID
+		s, err := tk.SignedString(k.SignKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, s)
+	}
+	for _, s := range tokens {
+		if _, err := verifier.Verify(s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//! rotate a new key on issuer, verifier should refetch for unknown kid
+	issuer.AddKey(&JwtKey{ID: "ed", Method: jwt.SigningMethodEdDSA, SignKey: ed, VerifyKey: ed.Public()})
+	verifier.Remote.MinRefresh = 0
+	s, err := issuer.Sign(claimsFor("ed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifier.Verify(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(issuer.JWKS().Keys); n != 3 {
+		t.Fatalf("expect 3 keys, got %d", n)
+	}
+}
+
+func TestJwksClientRefresh(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer := new(Tokenizer)
+	issuer.AddKey(&JwtKey{ID: "ec", Method: jwt.SigningMethodES256, SignKey: ec, VerifyKey: &ec.PublicKey})
+	var fetched atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		<-release
+		issuer.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c := NewJwksClient(srv.URL, time.Minute)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Lookup("ec")
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fetched.Load(); n != 1 {
+		t.Fatalf("concurrent lookups fetched %d times", n)
+	}
+	if _, err := c.Lookup("missing"); !errors.Is(err, ErrJwtKeyNotFound) || fetched.Load() != 1 {
+		t.Fatalf("unknown kid within min refresh: %v %d", err, fetched.Load())
+	}
+	huge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"keys":[],"pad":"` + strings.Repeat("x", 4096) + `"}`))
+	}))
+	defer huge.Close()
+	c = NewJwksClient(huge.URL, time.Minute)
+	c.MaxBytes = 1024
+	if _, err := c.Lookup("ec"); !errors.Is(err, ErrJwtKeyNotFound) || !strings.Contains(err.Error(), "decode jwks") {
+		t.Fatalf("oversized document: %v", err)
+	}
+
+	var down atomic.Bool
+	down.Store(true)
+	fetched.Store(0)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		issuer.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	c = NewJwksClient(flaky.URL, time.Minute)
+	c.MinRefresh = 100 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		if _, err := c.Lookup("ec"); !errors.Is(err, ErrJwtKeyNotFound) || !strings.Contains(err.Error(), "503") {
+			t.Fatalf("endpoint down: %v", err)
+		}
+	}
+	if n := fetched.Load(); n != 1 {
+		t.Fatalf("failed fetch not backed off, fetched %d times", n)
+	}
+	down.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	if _, err := c.Lookup("ec"); err != nil || fetched.Load() != 2 {
+		t.Fatalf("after backoff: %v %d", err, fetched.Load())
+	}
+}
+
+func TestTokenizerVerifyClaims(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	tk := &Tokenizer{Issuer: "gofra", Audience: []string{"api", "web"}, Leeway: 5 * time.Second}
+	tk.AddKey(&JwtKey{Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret})
+	now := time.Now()
+	for name, c := range map[string]struct {
+		claims jwt.RegisteredClaims
+		err    error
+	}{
+		"valid":        {jwt.RegisteredClaims{Issuer: "gofra", Audience: jwt.ClaimStrings{"web"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}, nil},
+		"leeway":       {jwt.RegisteredClaims{Issuer: "gofra", Audience: jwt.ClaimStrings{"api"}, ExpiresAt: jwt.NewNumericDate(now.Add(-2 * time.Second))}, nil},
+		"expired":      {jwt.RegisteredClaims{Issuer: "gofra", Audience: jwt.ClaimStrings{"api"}, ExpiresAt: jwt.NewNumericDate(now.Add(-time.Minute))}, jwt.ErrTokenExpired},
+		"no expire":    {jwt.RegisteredClaims{Issuer: "gofra", Audience: jwt.ClaimStrings{"api"}}, jwt.ErrTokenRequiredClaimMissing},
+		"not before":   {jwt.RegisteredClaims{Issuer: "gofra", Audience: jwt.ClaimStrings{"api"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)), NotBefore: jwt.NewNumericDate(now.Add(time.Minute))}, jwt.ErrTokenNotValidYet},
+		"issuer":       {jwt.RegisteredClaims{Issuer: "other", Audience: jwt.ClaimStrings{"api"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}, jwt.ErrTokenInvalidIssuer},
+		"audience":     {jwt.RegisteredClaims{Issuer: "gofra", Audience: jwt.ClaimStrings{"admin"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}, jwt.ErrTokenInvalidAudience},
+		"no audience":  {jwt.RegisteredClaims{Issuer: "gofra", ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}, jwt.ErrTokenInvalidAudience},
+		"all audience": {jwt.RegisteredClaims{Issuer: "gofra", Audience: jwt.ClaimStrings{"admin", "api"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}, nil},
+	} {
+		s, err := tk.Sign(c.claims)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if _, err = tk.Verify(s, &jwt.RegisteredClaims{}); !errors.Is(err, c.err) || (c.err == nil) != (err == nil) {
+			t.Fatalf("%s: expect %v, got %v", name, c.err, err)
+		}
+	}
+}
+
+func TestTokenizerMiddleware(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tk := &Tokenizer{Cookie: "token"}
+	tk.AddKey(&JwtKey{ID: "hs", Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret})
+	h := tk.Middleware(func() jwt.Claims { return &jwt.RegisteredClaims{} })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := ClaimsOf[*jwt.RegisteredClaims](r.Context())
+		if !ok {
+			t.Error("claims missing in context")
+			return
+		}
+		_, _ = w.Write([]byte(c.Subject))
+	}))
+	sign := func(m jwt.SigningMethod, key any, kid string, c jwt.Claims) string {
+		x := jwt.NewWithClaims(m, c)
+		if kid != "" {
+			x.Header["kid"] = kid
+		}
+		s, err := x.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	valid := sign(jwt.SigningMethodHS256, secret, "hs", claimsFor("alice"))
+	expired := sign(jwt.SigningMethodHS256, secret, "hs", jwt.RegisteredClaims{Subject: "alice", ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))})
+	forged := sign(jwt.SigningMethodHS256, []byte("another secret of the same size!"), "hs", claimsFor("alice"))
+	wrongAlg := sign(jwt.SigningMethodES256, ec, "hs", claimsFor("alice"))
+	hs384 := sign(jwt.SigningMethodHS384, secret, "hs", claimsFor("alice"))
+	unknown := sign(jwt.SigningMethodHS256, secret, "other", claimsFor("alice"))
+	none := sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "hs", claimsFor("alice"))
+	refresh := sign(jwt.SigningMethodHS256, secret, "hs", jwt.MapClaims{"sub": "alice", "typ": TokenTypeRefresh, "exp": time.Now().Add(time.Minute).Unix()})
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	bearer := func(s string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+s)
+		return r
+	}
+	if w := serve(bearer(valid)); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("valid token: %d %s", w.Code, w.Body)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: valid})
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("cookie token: %d %s", w.Code, w.Body)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/", nil)); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("missing token: %d %v", w.Code, w.Header())
+	}
+	for name, s := range map[string]string{
+		"malformed": "not.a.token",
+		"expired":   expired,
+		"forged":    forged,
+		"wrong alg": wrongAlg,
+		"hs384":     hs384,
+		"unknown":   unknown,
+		"none":      none,
+		"refresh":   refresh,
+	} {
+		if w := serve(bearer(s)); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+			t.Fatalf("%s: %d %v", name, w.Code, w.Header())
+		}
+	}
+}
+
+func TestConfigJwt(t *testing.T) {
+	defer func() { method, tokenizer = nil, nil }()
+	ConfigJwt(conf.NewConfig(hocon.ParseString(`jwt{sign: HS256}`)))
+	if method != jwt.SigningMethodHS256 || tokenizer != nil {
+		t.Fatalf("sign only config: %v %v", method, tokenizer)
+	}
+	if _, err := Sign(claimsFor("u")); !errors.Is(err, ErrJwtNotConfigured) {
+		t.Fatal(err)
+	}
+	ConfigJwt(conf.Empty())
+	if method != nil || tokenizer != nil {
+		t.Fatalf("empty config: %v %v", method, tokenizer)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expect panic on invalid keys")
+			}
+		}()
+		MustConfigJwt(conf.NewConfig(hocon.ParseString(`jwt{sign: HS256}`)))
+	}()
+	MustConfigJwt(conf.NewConfig(hocon.ParseString(`jwt{sign: HS256, kid: k1, secret: "0123456789abcdef0123456789abcdef"}`)))
+	s, err := Sign(claimsFor("u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if Generate(claimsFor("u")).Header["kid"] != "k1" {
+		t.Fatal("kid not set by Generate")
+	}
+}
@@ -0,0 +1,303 @@
+package htt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/ZenLiuCN/gofra/conf"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Transport listening options of a server
+	Transport struct {
+		Network  string      //tcp or unix
+		Address  string      //address or socket path
+		TLS      *tls.Config //nil for plain text
+		H2C      bool        //serve HTTP/2 without TLS, for internal traffic only
+		HTTP2    bool        //enable HTTP/2 over TLS
+		Reloader *CertReloader
+	}
+	// CertReloader reload certificate and key when the files changed
+	CertReloader struct {
+		CertFile string
+		KeyFile  string
+		Interval time.Duration //interval to check modification of files
+		lock     sync.RWMutex
+		cert     *tls.Certificate
+		modified time.Time
+		running  sync.Mutex
+		stop     chan struct{}
+		done     chan struct{}
+	}
+)
+
+// NewCertReloader load certificate and key
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (c *CertReloader, err error) {
+	c = &CertReloader{CertFile: certFile, KeyFile: keyFile, Interval: interval}
+	if err = c.Reload(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (c *CertReloader) modTime() (t time.Time, err error) {
+	for _, f := range [...]string{c.CertFile, c.KeyFile} {
+		var s os.FileInfo
+		if s, err = os.Stat(f); err != nil {
+			return
+		}
+		if s.ModTime().After(t) {
+			t = s.ModTime()
+		}
+	}
+	return
+}
+
+// Reload certificate and key from files
+func (c *CertReloader) Reload() error {
+	mod, err := c.modTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.cert = &cert
+	c.modified = mod
+	c.lock.Unlock()
+	return nil
+}
+
+// GetCertificate for [tls.Config.GetCertificate]
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+// Start checking modification of files in background, the old certificate is kept when reload fails.
+// It does nothing when already started, a stopped reloader can be started again.
+func (c *CertReloader) Start() {
+	c.running.Lock()
+	defer c.running.Unlock()
+	if c.Interval <= 0 || c.stop != nil {
+		return
+	}
+	c.stop, c.done = make(chan struct{}), make(chan struct{})
+	go func(stop, done chan struct{}) {
+		tk := time.NewTicker(c.Interval)
+		defer close(done)
+		defer tk.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tk.C:
+				mod, err := c.modTime()
+				c.lock.RLock()
+				changed := err == nil && !mod.Equal(c.modified)
+				c.lock.RUnlock()
+				if !changed {
+					continue
+				}
+				if err = c.Reload(); err != nil {
+					conf.Internal().Errorf("reload certificate %s: %s", c.CertFile, err)
+				} else {
+					conf.Internal().Infof("certificate %s reloaded", c.CertFile)
+				}
+			}
+		}
+	}(c.stop, c.done)
+}
+
+// Stop checking modification of files, it waits for the checking in progress.
+func (c *CertReloader) Stop() {
+	c.running.Lock()
+	defer c.running.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop, c.done = nil, nil
+	}
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	clientAuths = map[string]tls.ClientAuthType{
+		"none":    tls.NoClientCert,
+		"request": tls.RequestClientCert,
+		"any":     tls.RequireAnyClientCert,
+		"verify":  tls.VerifyClientCertIfGiven,
+		"require": tls.RequireAndVerifyClientCert,
+	}
+)
+
+func cipherSuites(names []string) (ids []uint16, err error) {
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	for _, n := range names {
+		id, ok := known[strings.ToUpper(n)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %s", n)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+func parseTLS(c conf.Config) (t *tls.Config, r *CertReloader, err error) {
+	cert, key := c.GetString("cert"), c.GetString("key")
+	if cert == "" || key == "" {
+		return nil, nil, errors.New("tls requires cert and key")
+	}
+	if r, err = NewCertReloader(cert, key, c.GetTimeDuration("reload", 0)); err != nil {
+		return
+	}
+	t = &tls.Config{GetCertificate: r.GetCertificate}
+	ver := c.GetString("minVersion", "1.2")
+	if t.MinVersion = tlsVersions[ver]; t.MinVersion == 0 {
+		return nil, nil, fmt.Errorf("unknown tls version %s", ver)
+	}
+	if t.CipherSuites, err = cipherSuites(c.GetStringList("ciphers")); err != nil {
+		return nil, nil, err
+	}
+	if ca := c.GetString("clientCA"); ca != "" {
+		var b []byte
+		if b, err = os.ReadFile(ca); err != nil {
+			return nil, nil, err
+		}
+		t.ClientCAs = x509.NewCertPool()
+		if !t.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, nil, fmt.Errorf("no certificate found in %s", ca)
+		}
+		t.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if v := c.GetString("clientAuth"); v != "" {
+		var ok bool
+		if t.ClientAuth, ok = clientAuths[v]; !ok {
+			return nil, nil, fmt.Errorf("unknown client auth %s", v)
+		}
+	}
+	return
+}
+
+/*
+NewTransport read listening options from server config.
+
+HOCON sample:
+
+	{
+	address: "0.0.0.0:8443"
+	unix: "/run/app.sock" # listen on unix socket instead of address
+	h2c: false # HTTP/2 without TLS
+	http2: true # HTTP/2 over TLS
+	tls{
+	 cert: "server.crt"
+	 key: "server.key"
+	 reload: 1m # interval to check changes of cert and key, zero to disable
+	 minVersion: "1.2"
+	 ciphers: [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]
+	 clientCA: "ca.crt" # verify client certificates
+	 clientAuth: require # none, request, any, verify or require, default is require when clientCA present
+	}
+	}
+*/
+func NewTransport(c conf.Config) (t *Transport, err error) {
+	if c == nil {
+		c = conf.Empty()
+	}
+	t = &Transport{Network: "tcp", Address: c.GetString("address", "0.0.0.0:8080")}
+	if s := c.GetString("unix"); s != "" {
+		t.Network, t.Address = "unix", s
+	}
+	t.H2C = c.GetBoolean("h2c", false)
+	t.HTTP2 = c.GetBoolean("http2", true)
+	if c.IsObject("tls") {
+		if t.TLS, t.Reloader, err = parseTLS(c.GetObject("tls")); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Configure the server to use the transport, must be called before serving.
+func (t *Transport) Configure(s *http.Server) error {
+	switch {
+	case t.TLS != nil:
+		s.TLSConfig = t.TLS
+		if !t.HTTP2 {
+			s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		} else if err := http2.ConfigureServer(s, nil); err != nil {
+			return err
+		}
+	case t.H2C:
+		h2 := &http2.Server{IdleTimeout: s.IdleTimeout}
+		//! h2c connections are hijacked, registers the graceful shutdown of h2 to send GOAWAY on them
+		if err := http2.ConfigureServer(s, h2); err != nil {
+			return err
+		}
+		s.Handler = h2c.NewHandler(s.Handler, h2)
+	}
+	if t.Network == "tcp" {
+		s.Addr = t.Address
+	}
+	return nil
+}
+
+// Listen create the listener, the stale unix socket file is removed.
+func (t *Transport) Listen() (net.Listener, error) {
+	if t.Network == "unix" {
+		if s, err := os.Stat(t.Address); err == nil && s.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(t.Address)
+		}
+	}
+	return net.Listen(t.Network, t.Address)
+}
+
+// Serve the server on the transport, which blocks until server closed. see [http.Server.Serve]
+func (t *Transport) Serve(s *http.Server) error {
+	l, err := t.Listen()
+	if err != nil {
+		return err
+	}
+	return t.ServeListener(s, l)
+}
+
+// ServeListener serve the server on a listener created by [Transport.Listen], which blocks until server closed.
+func (t *Transport) ServeListener(s *http.Server, l net.Listener) error {
+	if t.Reloader != nil {
+		t.Reloader.Start()
+		defer t.Reloader.Stop()
+	}
+	if t.TLS != nil {
+		return s.ServeTLS(l, "", "")
+	}
+	return s.Serve(l)
+}
+
+// String the listening address
+func (t *Transport) String() string {
+	scheme := "http"
+	if t.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "+" + t.Network + "://" + t.Address
+}
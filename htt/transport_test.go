@@ -0,0 +1,226 @@
+package htt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/ZenLiuCN/gofra/conf"
+	hocon "github.com/go-akka/configuration"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// issueCert create a certificate signed by parent, self-signed when parent is nil, and write PEM files of it when path not empty.
+func issueCert(t *testing.T, name string, serial int64, parent *testCert, path string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signKey := tpl, key
+	if parent == nil {
+		tpl.IsCA, tpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{key: key}
+	if c.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	kb, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb})
+	if c.tls, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if path != "" {
+		if err = os.WriteFile(path+".crt", certPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path+".key", keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func touch(t *testing.T, at time.Time, files ...string) {
+	for _, f := range files {
+		if err := os.Chtimes(f, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func serialOf(t *testing.T, r *CertReloader) int64 {
+	c, _ := r.GetCertificate(nil)
+	x, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x.SerialNumber.Int64()
+}
+
+func waitSerial(t *testing.T, r *CertReloader, serial int64) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if serialOf(t, r) == serial {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expect serial %d, got %d", serial, serialOf(t, r))
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "server")
+	crt, key := p+".crt", p+".key"
+	issueCert(t, "server", 1, nil, p)
+	if _, err := NewCertReloader(crt, filepath.Join(dir, "missing.key"), 0); err == nil {
+		t.Fatal("expect missing key error")
+	}
+	r, err := NewCertReloader(crt, key, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now()
+	r.Start()
+	r.Start() //no second loop
+	issueCert(t, "server", 2, nil, p)
+	touch(t, base.Add(time.Hour), crt, key)
+	waitSerial(t, r, 2)
+
+	if err = os.WriteFile(key, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, base.Add(2*time.Hour), crt, key)
+	time.Sleep(30 * time.Millisecond)
+	if serialOf(t, r) != 2 {
+		t.Fatal("broken files replaced the certificate")
+	}
+
+	r.Stop()
+	r.Stop()
+	issueCert(t, "server", 3, nil, p)
+	touch(t, base.Add(3*time.Hour), crt, key)
+	time.Sleep(30 * time.Millisecond)
+	if serialOf(t, r) != 2 {
+		t.Fatal("reloaded after stop")
+	}
+	r.Start()
+	defer r.Stop()
+	waitSerial(t, r, 3)
+}
+
+func TestTransportMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "ca", 1, nil, filepath.Join(dir, "ca"))
+	issueCert(t, "server", 2, ca, filepath.Join(dir, "server"))
+	client := issueCert(t, "client", 3, ca, "")
+	stranger := issueCert(t, "stranger", 4, nil, "")
+	tr, err := NewTransport(conf.NewConfig(hocon.ParseString(`{
+address: "127.0.0.1:0"
+tls{
+ cert: "` + filepath.Join(dir, "server.crt") + `"
+ key: "` + filepath.Join(dir, "server.key") + `"
+ clientCA: "` + filepath.Join(dir, "ca.crt") + `"
+ minVersion: "1.3"
+}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.TLS.ClientAuth != tls.RequireAndVerifyClientCert || tr.TLS.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("tls config %+v", tr.TLS)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName + " " + r.Proto))
+	})}
+	if err = tr.Configure(s); err != nil {
+		t.Fatal(err)
+	}
+	l, err := tr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = tr.ServeListener(s, l) }()
+	defer s.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs []tls.Certificate, max uint16) (string, error) {
+		cli := &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true, TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs, MaxVersion: max}}}
+		defer cli.CloseIdleConnections()
+		res, err := cli.Get("https://" + l.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		return string(b), err
+	}
+	if v, err := get([]tls.Certificate{client.tls}, 0); err != nil || v != "client HTTP/2.0" {
+		t.Fatalf("client certificate: %q %v", v, err)
+	}
+	for name, certs := range map[string][]tls.Certificate{"none": nil, "stranger": {stranger.tls}} {
+		if _, err := get(certs, 0); err == nil {
+			t.Fatalf("%s: expect handshake failure", name)
+		}
+	}
+	if _, err := get([]tls.Certificate{client.tls}, tls.VersionTLS12); err == nil {
+		t.Fatal("expect tls 1.2 rejected")
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	dir := t.TempDir()
+	issueCert(t, "server", 1, nil, filepath.Join(dir, "server"))
+	files := `cert: "` + filepath.Join(dir, "server.crt") + `", key: "` + filepath.Join(dir, "server.key") + `"`
+	for name, src := range map[string]string{
+		"key":        `tls{cert: "a.crt"}`,
+		"version":    `tls{` + files + `, minVersion: "1.4"}`,
+		"cipher":     `tls{` + files + `, ciphers: [TLS_RSA_WITH_RC4_128_SHA]}`,
+		"clientAuth": `tls{` + files + `, clientAuth: maybe}`,
+		"clientCA":   `tls{` + files + `, clientCA: "` + filepath.Join(dir, "server.key") + `"}`,
+	} {
+		if _, err := NewTransport(conf.NewConfig(hocon.ParseString(src))); err == nil {
+			t.Fatalf("%s: expect error", name)
+		}
+	}
+	tr, err := NewTransport(conf.NewConfig(hocon.ParseString(`tls{` + files + `, clientAuth: request, ciphers: [tls_ecdhe_ecdsa_with_aes_128_gcm_sha256]}`)))
+	if err != nil || tr.TLS.ClientAuth != tls.RequestClientCert || len(tr.TLS.CipherSuites) != 1 || tr.String() != "https+tcp://0.0.0.0:8080" {
+		t.Fatalf("%+v %v", tr, err)
+	}
+	tr, _ = NewTransport(conf.NewConfig(hocon.ParseString(`unix: "/run/app.sock", h2c: true`)))
+	if tr.String() != "http+unix:///run/app.sock" || !tr.H2C {
+		t.Fatal(tr)
+	}
+}
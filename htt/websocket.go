@@ -0,0 +1,355 @@
+package htt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/htt/hub"
+	"github.com/gorilla/websocket"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// WS component to communicate with websocket client, which mirrors [SSE].
+	//
+	// Messages are queued and written by [WS.Await], which should only call inside [http.Handler].
+	// It keeps alive by ping/pong, and blocks until the connection closed, the context done or the server draining.
+	// All send methods return [io.EOF] if the WS already closed.
+	WS interface {
+		Close() error                            //close normally, returns [io.EOF] if already closed.
+		CloseWith(code int, reason string) error //close with code, see [websocket.CloseNormalClosure].
+		Send(data string) error                  //send text message.
+		SendBinary(data []byte) error            //send binary message.
+		SendJSON(v any) error                    //send v as json text message.
+		Deliver(m WSMessage) bool                //enqueue without blocking, returns false when queue is full or closed. see [WSHub]
+		OnMessage(fn func(m WSMessage)) WS       //set handler of received messages, should set before Await.
+		WithOnClose(fn func()) WS                //set on close hook to called once when closed.
+		Raw() *websocket.Conn                    //the internal connection.
+		Await(ctx context.Context) error         //Await inside [http.Handler], returns [io.EOF] if the client closed connection.
+	}
+	// WSMessage message of websocket
+	WSMessage struct {
+		Binary bool
+		Data   []byte
+	}
+	// WSConfig options of websocket, see [NewWSConfig]
+	WSConfig struct {
+		ReadLimit    int64         //max size of received message
+		Queue        int           //size of send queue
+		PingInterval time.Duration //interval of ping, zero disables ping
+		PongWait     time.Duration //max wait for pong or any message, should greater than PingInterval, zero disables read deadline
+		WriteWait    time.Duration //write deadline of each message, default 10s
+		Upgrader     websocket.Upgrader
+	}
+	// WSHub fan out messages to websocket connections by topic and user, see [hub.Hub]
+	WSHub = hub.Hub[WSMessage]
+	ws    struct {
+		cfg       *WSConfig
+		conn      *websocket.Conn
+		ch        chan WSMessage
+		done      chan struct{}
+		once      sync.Once
+		code      int
+		reason    string
+		onClose   func()
+		onMessage func(m WSMessage)
+		awaiting  atomic.Bool
+	}
+)
+
+// ErrWSAwaiting the WS is already awaiting
+var ErrWSAwaiting = errors.New("websocket is awaiting")
+
+// NewWSHub create [WSHub]
+func NewWSHub() *WSHub {
+	return hub.New[WSMessage]()
+}
+
+// TextMessage create text message
+func TextMessage(s string) WSMessage {
+	return WSMessage{Data: []byte(s)}
+}
+
+// JSONMessage create json text message of v
+func JSONMessage(v any) (m WSMessage, err error) {
+	m.Data, err = json.Marshal(v)
+	return
+}
+
+// JSON decode the message into v
+func (m WSMessage) JSON(v any) error {
+	return json.Unmarshal(m.Data, v)
+}
+
+/*
+NewWSConfig create [WSConfig] from config, cfg is optional.
+
+HOCON sample:
+
+	{
+	 readLimit: 64k
+	 queue: 64
+	 pingInterval: 30s
+	 pongWait: 60s
+	 writeWait: 10s
+	 compression: false
+	 origins: ["https://example.com"] # allowed origins, * for any, default only same host
+	 subprotocols: []
+	}
+*/
+func NewWSConfig(cfg conf.Config) *WSConfig {
+	if cfg == nil {
+		cfg = conf.Empty()
+	}
+	c := &WSConfig{
+		ReadLimit:    cfg.GetByteSizeOr("readLimit", big.NewInt(64<<10)).Int64(),
+		Queue:        int(cfg.GetInt32("queue", 64)),
+		PingInterval: cfg.GetTimeDuration("pingInterval", 30*time.Second),
+		PongWait:     cfg.GetTimeDuration("pongWait", 60*time.Second),
+		WriteWait:    cfg.GetTimeDuration("writeWait", 10*time.Second),
+	}
+	c.normalize()
+	c.Upgrader.EnableCompression = cfg.GetBoolean("compression", false)
+	c.Upgrader.Subprotocols = cfg.GetStringList("subprotocols")
+	if origins := cfg.GetStringList("origins"); len(origins) > 0 {
+		c.Upgrader.CheckOrigin = func(r *http.Request) bool {
+			o := r.Header.Get("Origin")
+			for _, x := range origins {
+				if x == "*" || x == o {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	c.Upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		WriteProblem(w, r, NewProblem(status, reason.Error()))
+	}
+	return c
+}
+
+// normalize clamp invalid values: queue is at least 1, write wait defaults 10s, pong wait is longer than ping interval when ping enabled.
+func (c *WSConfig) normalize() {
+	if c.Queue < 1 {
+		c.Queue = 1
+	}
+	if c.WriteWait <= 0 {
+		c.WriteWait = 10 * time.Second
+	}
+	if c.PingInterval < 0 {
+		c.PingInterval = 0
+	}
+	if c.PingInterval > 0 && c.PongWait <= c.PingInterval {
+		c.PongWait = c.PingInterval * 2
+	}
+	if c.PongWait < 0 {
+		c.PongWait = 0
+	}
+}
+
+// NewWS upgrade the request to websocket, cfg is optional, invalid values of cfg are clamped on a copy. The upgrade failure is already responded.
+func NewWS(w http.ResponseWriter, r *http.Request, cfg *WSConfig) (WS, error) {
+	if cfg == nil {
+		cfg = NewWSConfig(nil)
+	}
+	conn, err := cfg.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := *cfg
+	c.normalize()
+	return &ws{cfg: &c, conn: conn, ch: make(chan WSMessage, c.Queue), done: make(chan struct{})}, nil
+}
+
+// Handler upgrade requests and call fn to configure the WS, then await it.
+func (c *WSConfig) Handler(fn func(ws WS, r *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewWS(w, r, c)
+		if err != nil {
+			return
+		}
+		fn(s, r)
+		if err = s.Await(r.Context()); err != nil && !errors.Is(err, io.EOF) {
+			conf.Internal().Warnf("websocket %s closed: %s", r.URL.Path, err)
+		}
+	})
+}
+
+// WithWebSocket serve websocket at path, cfg is the websocket config, see [NewWSConfig].
+// fn configures handlers or joins the [WSHub], the connection closes with going away when the [Server] drains.
+func (c RouterConfigurer) WithWebSocket(path string, cfg conf.Config, fn func(ws WS, r *http.Request)) RouterConfigurer {
+	c.Handle(path, NewWSConfig(cfg).Handler(fn)).Methods(http.MethodGet).Name("websocket:" + path)
+	return c
+}
+
+func (s *ws) Raw() *websocket.Conn {
+	return s.conn
+}
+func (s *ws) OnMessage(fn func(m WSMessage)) WS {
+	s.onMessage = fn
+	return s
+}
+func (s *ws) WithOnClose(fn func()) WS {
+	s.onClose = fn
+	return s
+}
+func (s *ws) Close() error {
+	return s.CloseWith(websocket.CloseNormalClosure, "")
+}
+func (s *ws) CloseWith(code int, reason string) (err error) {
+	err = io.EOF
+	s.once.Do(func() {
+		s.code, s.reason = code, reason
+		close(s.done)
+		if s.onClose != nil {
+			s.onClose()
+		}
+		err = nil
+	})
+	return
+}
+
+func (s *ws) Send(data string) error {
+	return s.send(WSMessage{Data: []byte(data)})
+}
+func (s *ws) SendBinary(data []byte) error {
+	return s.send(WSMessage{Binary: true, Data: data})
+}
+func (s *ws) SendJSON(v any) error {
+	m, err := JSONMessage(v)
+	if err != nil {
+		return err
+	}
+	return s.send(m)
+}
+func (s *ws) send(m WSMessage) error {
+	select {
+	case <-s.done:
+		return io.EOF
+	default:
+	}
+	select {
+	case s.ch <- m:
+		return nil
+	case <-s.done:
+		return io.EOF
+	}
+}
+func (s *ws) Deliver(m WSMessage) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.ch <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *ws) write(m WSMessage) error {
+	kind := websocket.TextMessage
+	if m.Binary {
+		kind = websocket.BinaryMessage
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteWait))
+	return s.conn.WriteMessage(kind, m.Data)
+}
+
+func (s *ws) read(errs chan<- error) {
+	for {
+		kind, data, err := s.conn.ReadMessage()
+		if err != nil {
+			errs <- err
+			return
+		}
+		s.extend()
+		if s.onMessage != nil {
+			s.onMessage(WSMessage{Binary: kind == websocket.BinaryMessage, Data: data})
+		}
+	}
+}
+
+// extend the read deadline by pong wait
+func (s *ws) extend() error {
+	if s.cfg.PongWait <= 0 {
+		return nil
+	}
+	return s.conn.SetReadDeadline(time.Now().Add(s.cfg.PongWait))
+}
+
+func (s *ws) Await(ctx context.Context) (err error) {
+	if !s.awaiting.CompareAndSwap(false, true) {
+		return ErrWSAwaiting
+	}
+	defer s.conn.Close()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c := s.conn
+	c.SetReadLimit(s.cfg.ReadLimit)
+	_ = s.extend()
+	c.SetPongHandler(func(string) error {
+		return s.extend()
+	})
+	errs := make(chan error, 1)
+	go s.read(errs)
+	var ping <-chan time.Time //nil when ping disabled
+	if s.cfg.PingInterval > 0 {
+		tick := time.NewTicker(s.cfg.PingInterval)
+		defer tick.Stop()
+		ping = tick.C
+	}
+	done, draining := ctx.Done(), Draining(ctx)
+	for {
+		select {
+		case m := <-s.ch:
+			if err = s.write(m); err != nil {
+				_ = s.CloseWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping:
+			if err = c.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.cfg.WriteWait)); err != nil {
+				_ = s.CloseWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case err = <-errs:
+			_ = s.CloseWith(websocket.CloseAbnormalClosure, "")
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) || errors.Is(err, net.ErrClosed) {
+				return io.EOF
+			}
+			return
+		case <-done:
+			done = nil
+			_ = s.CloseWith(websocket.CloseGoingAway, "")
+		case <-draining:
+			draining = nil
+			_ = s.CloseWith(websocket.CloseGoingAway, "server shutdown")
+		case <-s.done:
+			for len(s.ch) > 0 {
+				if err = s.write(<-s.ch); err != nil {
+					return
+				}
+			}
+			msg := websocket.FormatCloseMessage(s.code, s.reason)
+			if err = c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(s.cfg.WriteWait)); err != nil {
+				return
+			}
+			select { //wait for close reply
+			case <-errs:
+			case <-time.After(s.cfg.WriteWait):
+			}
+			return nil
+		}
+	}
+}
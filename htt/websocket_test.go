@@ -0,0 +1,309 @@
+package htt
+
+import (
+	"context"
+	"errors"
+	"github.com/ZenLiuCN/gofra/conf"
+	"github.com/ZenLiuCN/gofra/htt/hub"
+	hocon "github.com/go-akka/configuration"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// wsServe serve fn by [NewWS] with cfg, the result of Await is sent to the returned channel
+func wsServe(t *testing.T, cfg *WSConfig, fn func(s WS, r *http.Request) context.Context) (string, <-chan error) {
+	awaited := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewWS(w, r, cfg)
+		if err != nil {
+			awaited <- err
+			return
+		}
+		ctx := r.Context()
+		if fn != nil {
+			if x := fn(s, r); x != nil {
+				ctx = x
+			}
+		}
+		awaited <- s.Await(ctx)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), awaited
+}
+
+func wsDial(t *testing.T, url string) *websocket.Conn {
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func wsAwaited(t *testing.T, awaited <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-awaited:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("await not returned")
+		return nil
+	}
+}
+
+// wsCloseCode read until the close frame, returns its code
+func wsCloseCode(c *websocket.Conn) (int, string) {
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) {
+				return ce.Code, ce.Text
+			}
+			return -1, err.Error()
+		}
+	}
+}
+
+func TestNewWSConfig(t *testing.T) {
+	c := NewWSConfig(conf.NewConfig(hocon.ParseString(`pingInterval: 0s, pongWait: 0s, writeWait: 0s, queue: 0, origins: ["https://a.com"]`)))
+	if c.PingInterval != 0 || c.PongWait != 0 || c.WriteWait != 10*time.Second || c.Queue != 1 {
+		t.Fatalf("zero values not clamped %+v", c)
+	}
+	c = NewWSConfig(conf.NewConfig(hocon.ParseString(`pingInterval: 10s, pongWait: 5s`)))
+	if c.PongWait != 20*time.Second {
+		t.Fatalf("pong wait should be longer than ping interval %s", c.PongWait)
+	}
+	c = NewWSConfig(conf.NewConfig(hocon.ParseString(`origins: ["https://a.com"]`)))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://b.com")
+	if c.Upgrader.CheckOrigin(r) {
+		t.Fatal("origin should be rejected")
+	}
+	r.Header.Set("Origin", "https://a.com")
+	if !c.Upgrader.CheckOrigin(r) {
+		t.Fatal("origin should be allowed")
+	}
+}
+
+func TestWSSend(t *testing.T) {
+	var closes atomic.Int32
+	var after error
+	url, awaited := wsServe(t, nil, func(s WS, r *http.Request) context.Context {
+		s.WithOnClose(func() { closes.Add(1) })
+		_ = s.Send("text")
+		_ = s.SendBinary([]byte{1, 2})
+		_ = s.SendJSON(map[string]int{"n": 1})
+		if !s.Deliver(TextMessage("delivered")) {
+			t.Error("deliver failed")
+		}
+		_ = s.CloseWith(websocket.ClosePolicyViolation, "bye")
+		if s.Close() != io.EOF {
+			t.Error("second close should be io.EOF")
+		}
+		after = s.Send("after")
+		return nil
+	})
+	c := wsDial(t, url)
+	for _, want := range []struct {
+		kind int
+		data string
+	}{{websocket.TextMessage, "text"}, {websocket.BinaryMessage, "\x01\x02"}, {websocket.TextMessage, `{"n":1}`}, {websocket.TextMessage, "delivered"}} {
+		kind, data, err := c.ReadMessage()
+		if err != nil || kind != want.kind || string(data) != want.data {
+			t.Fatalf("read %d %q %v, want %q", kind, data, err, want.data)
+		}
+	}
+	if code, text := wsCloseCode(c); code != websocket.ClosePolicyViolation || text != "bye" {
+		t.Fatalf("close %d %q", code, text)
+	}
+	if err := wsAwaited(t, awaited); err != nil {
+		t.Fatalf("await %v", err)
+	}
+	if !errors.Is(after, io.EOF) || closes.Load() != 1 {
+		t.Fatalf("send after close %v, on close called %d", after, closes.Load())
+	}
+}
+
+func TestWSMessage(t *testing.T) {
+	url, awaited := wsServe(t, nil, func(s WS, r *http.Request) context.Context {
+		s.OnMessage(func(m WSMessage) {
+			var v struct{ Echo string }
+			if m.JSON(&v) == nil && v.Echo != "" {
+				_ = s.Send(v.Echo)
+			}
+		})
+		return nil
+	})
+	c := wsDial(t, url)
+	_ = c.WriteMessage(websocket.TextMessage, []byte(`{"Echo":"hi"}`))
+	if _, data, err := c.ReadMessage(); err != nil || string(data) != "hi" {
+		t.Fatalf("echo %q %v", data, err)
+	}
+	_ = c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err := wsAwaited(t, awaited); !errors.Is(err, io.EOF) {
+		t.Fatalf("client close should be io.EOF, got %v", err)
+	}
+}
+
+func TestWSPing(t *testing.T) {
+	cfg := &WSConfig{PingInterval: 20 * time.Millisecond, PongWait: 100 * time.Millisecond}
+	url, awaited := wsServe(t, cfg, nil)
+	alive := wsDial(t, url)
+	var pings atomic.Int32
+	alive.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return alive.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go wsCloseCode(alive) //read to handle pings
+	time.Sleep(300 * time.Millisecond)
+	if pings.Load() < 3 {
+		t.Fatalf("pings %d", pings.Load())
+	}
+	select {
+	case err := <-awaited:
+		t.Fatalf("connection with pong replies should alive: %v", err)
+	default:
+	}
+
+	url, awaited = wsServe(t, cfg, nil)
+	silent := wsDial(t, url)
+	silent.SetPingHandler(func(string) error { return nil }) //no pong
+	go wsCloseCode(silent)
+	if err := wsAwaited(t, awaited); err == nil || errors.Is(err, io.EOF) {
+		t.Fatalf("missing pong should time out, got %v", err)
+	}
+}
+
+func TestWSZeroConfig(t *testing.T) {
+	url, awaited := wsServe(t, &WSConfig{}, func(s WS, r *http.Request) context.Context {
+		_ = s.Send("zero")
+		go func() {
+			time.Sleep(100 * time.Millisecond) //no read deadline without pong wait
+			_ = s.Close()
+		}()
+		return nil
+	})
+	c := wsDial(t, url)
+	if _, data, err := c.ReadMessage(); err != nil || string(data) != "zero" {
+		t.Fatalf("read %q %v", data, err)
+	}
+	if code, _ := wsCloseCode(c); code != websocket.CloseNormalClosure {
+		t.Fatalf("close %d", code)
+	}
+	if err := wsAwaited(t, awaited); err != nil {
+		t.Fatalf("await %v", err)
+	}
+}
+
+func TestWSContext(t *testing.T) {
+	url, awaited := wsServe(t, nil, func(s WS, r *http.Request) context.Context {
+		ctx, cancel := context.WithCancel(r.Context())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		return ctx
+	})
+	c := wsDial(t, url)
+	if code, _ := wsCloseCode(c); code != websocket.CloseGoingAway {
+		t.Fatalf("close %d", code)
+	}
+	if err := wsAwaited(t, awaited); err != nil {
+		t.Fatalf("await %v", err)
+	}
+}
+
+func TestWSDrain(t *testing.T) {
+	awaited := make(chan error, 1)
+	h := NewWSConfig(nil)
+	s := localServer(t, "ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x, err := NewWS(w, r, h)
+		if err == nil {
+			err = x.Await(r.Context())
+		}
+		awaited <- err
+	}), "2s")
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	c := wsDial(t, "ws://"+s.Addr().String()+"/")
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	if code, text := wsCloseCode(c); code != websocket.CloseGoingAway || text != "server shutdown" {
+		t.Fatalf("close %d %q", code, text)
+	}
+	_ = c.Close()
+	if err := wsAwaited(t, awaited); err != nil {
+		t.Fatalf("await %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown %v", err)
+	}
+}
+
+func TestWSHub(t *testing.T) {
+	hb := NewWSHub()
+	joined := make(chan WS, 2)
+	url, _ := wsServe(t, &WSConfig{Queue: 1, WriteWait: time.Second}, func(s WS, r *http.Request) context.Context {
+		if err := hb.Join(s, r.URL.Query().Get("user"), "news"); err != nil {
+			t.Error(err)
+		}
+		s.WithOnClose(func() { hb.Leave(s) })
+		joined <- s
+		return nil
+	})
+	fast := wsDial(t, url+"?user=fast")
+	<-joined
+	slow := wsDial(t, url+"?user=slow")
+	stuck := <-joined
+	var evicted atomic.Value
+	hb.OnEvict = func(c hub.Client[WSMessage]) { evicted.Store(c) }
+
+	received := make(chan string, 16)
+	go func() {
+		for {
+			_, data, err := fast.ReadMessage()
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- string(data)
+		}
+	}()
+	if n := hb.Publish("news", TextMessage("first")); n != 2 {
+		t.Fatalf("published to %d", n)
+	}
+	if got := <-received; got != "first" {
+		t.Fatalf("fast received %q", got)
+	}
+	//slow client does not read, its queue and socket buffers fill up until evicted
+	deadline := time.Now().Add(5 * time.Second)
+	big := TextMessage(strings.Repeat("x", 1<<20))
+	for evicted.Load() == nil && time.Now().Before(deadline) {
+		hb.SendUser("slow", big)
+		time.Sleep(time.Millisecond)
+	}
+	if evicted.Load() != stuck || hb.Online("slow") || !hb.Online("fast") || hb.Stats().Evicted != 1 {
+		t.Fatalf("slow client not evicted: %+v", hb.Stats())
+	}
+	if stuck.Send("x") != io.EOF {
+		t.Fatal("evicted client should be closed")
+	}
+	_ = slow.Close()
+	if n := hb.Publish("news", TextMessage("second")); n != 1 {
+		t.Fatalf("published to %d after eviction", n)
+	}
+	if got := <-received; got != "second" {
+		t.Fatalf("fast received %q", got)
+	}
+	if err := hb.Close(); err != nil || hb.Join(stuck, "") == nil {
+		t.Fatalf("closed hub should reject joins: %v", err)
+	}
+	if _, ok := <-received; ok {
+		t.Fatal("fast client should be closed with hub")
+	}
+}
@@ -311,6 +311,7 @@ func (s SqlxExecutor) QueryOne(ctx context.Context, out any, sql string, args ma
 	if err != nil {
 		return err
 	}
+	defer r.Close()
 	if r.Next() {
 		err = r.StructScan(out)
 	}
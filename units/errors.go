@@ -30,64 +30,72 @@ func (i ResponseError) Error() string {
 }
 
 type JsonError struct {
-	Timestamp int64  `json:"timestamp"`
-	Code      int    `json:"code"`
-	Message   string `json:"message,omitempty"`
+	Timestamp int64        `json:"timestamp"`
+	Code      int          `json:"code"`
+	Message   string       `json:"message,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// FieldError error of a request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// recovered the status and message of a panic, codes of [ResponseError] between 400 and 599 are exposed, others are 500.
+func recovered(e any) (code int, message string) {
+	code = http.StatusInternalServerError
+	if err, ok := e.(error); ok {
+		var ex ResponseError
+		if errors.As(err, &ex) {
+			message = ex.Message
+			if ex.Code >= 400 && ex.Code <= 599 {
+				code = ex.Code
+			}
+		}
+	}
+	return
 }
 
 // JsonSafeHandleFunc recover and returns json error object.
 //
-// 400/404/401/500 will expose as http status
+// Codes of [ResponseError] between 400 and 599 are exposed as http status, other panics are responded as 500.
 func JsonSafeHandleFunc(h http.HandlerFunc, logger func(format string, args ...any)) (s http.HandlerFunc) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			switch e := recover().(type) {
-			case error:
-				var ex ResponseError
-				if errors.As(e, &ex) {
-					switch ex.Code {
-					case http.StatusNotFound, http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError:
-						w.WriteHeader(ex.Code)
-					}
-					_ = json.NewEncoder(w).Encode(JsonError{
-						Timestamp: time.Now().Unix(),
-						Code:      ex.Code,
-						Message:   ex.Message,
-					})
-				}
-				logger("handle %s", r.RequestURI, e)
-			case nil:
-			default:
-				w.WriteHeader(http.StatusInternalServerError)
-				_ = json.NewEncoder(w).Encode(JsonError{
-					Timestamp: time.Now().Unix(),
-					Code:      500,
-					Message:   "",
-				})
-				logger("handle %s", r.RequestURI, e)
+			e := recover()
+			if e == nil {
+				return
 			}
+			code, message := recovered(e)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(code)
+			_ = json.NewEncoder(w).Encode(JsonError{
+				Timestamp: time.Now().Unix(),
+				Code:      code,
+				Message:   message,
+			})
+			logger("handle %s: %+v", r.RequestURI, e)
 		}()
 		h(w, r)
 	}
 }
 
-// TextSafeHandleFunc recover and returns http status code with text error message. Note all error code are treat as http status code
+// TextSafeHandleFunc recover and returns http status code with text error message. Note all error code are treat as http status code,
+// codes out of 400 to 599 are responded as 500.
 func TextSafeHandleFunc(h http.HandlerFunc, logger func(format string, args ...any)) (s http.HandlerFunc) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			switch e := recover().(type) {
-			case error:
-				var ex ResponseError
-				if errors.As(e, &ex) {
-					w.WriteHeader(ex.Code)
-					_, _ = w.Write([]byte(ex.Message))
-				}
-				logger("handle %s %#+v", r.RequestURI, e)
-			case nil:
-			default:
-				w.WriteHeader(http.StatusInternalServerError)
-				logger("handle %s %#+v", r.RequestURI, e)
+			e := recover()
+			if e == nil {
+				return
 			}
+			code, message := recovered(e)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(message))
+			logger("handle %s: %+v", r.RequestURI, e)
 		}()
 		h(w, r)
 	}
@@ -0,0 +1,42 @@
+package units
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSafeHandleFunc(t *testing.T) {
+	var logged int
+	logger := func(format string, args ...any) { logged++ }
+	for name, c := range map[string]struct {
+		panic  any
+		status int
+		body   string
+	}{
+		"response": {ResponseError{Code: http.StatusForbidden, Message: "denied"}, http.StatusForbidden, "denied"},
+		"bad code": {ResponseError{Code: 1, Message: "odd"}, http.StatusInternalServerError, "odd"},
+		"error":    {errors.New("boom"), http.StatusInternalServerError, ""},
+		"value":    {"boom", http.StatusInternalServerError, ""},
+	} {
+		w := httptest.NewRecorder()
+		TextSafeHandleFunc(func(w http.ResponseWriter, r *http.Request) { panic(c.panic) }, logger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != c.status || w.Body.String() != c.body {
+			t.Fatalf("text %s: %d %q", name, w.Code, w.Body.String())
+		}
+		w = httptest.NewRecorder()
+		JsonSafeHandleFunc(func(w http.ResponseWriter, r *http.Request) { panic(c.panic) }, logger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != c.status || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Fatalf("json %s: %d", name, w.Code)
+		}
+	}
+	if logged != 8 {
+		t.Fatalf("logged %d", logged)
+	}
+	w := httptest.NewRecorder()
+	TextSafeHandleFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }, logger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNoContent || logged != 8 {
+		t.Fatalf("no panic %d %d", w.Code, logged)
+	}
+}
@@ -162,6 +162,7 @@ func (g Gabs) Integers(p string, def ...int) ([]int, bool) {
 	return GabsIntegers(g.Container, p, def...)
 }
 
+// ReadGabs read the JSON body, panics on invalid JSON. htt.BindGabs reads with validation and without panic.
 func ReadGabs(r *http.Request) (g Gabs) {
 	fn.IgnoreClose(r.Body)
 	buf := fn.GetBuffer()
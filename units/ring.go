@@ -419,6 +419,11 @@ func (s *Ring[ID, T, V]) Inspect() (reg map[string]string, wheel map[string]stri
 	return m, w
 }
 
+// Backlog the queued register and remove events not yet processed, and the capacity of the queue.
+func (s *Ring[ID, T, V]) Backlog() (queued, capacity int) {
+	return len(s.event), cap(s.event)
+}
+
 // Reset Stop current execution and clean all data. Then restart again.
 func (s *Ring[ID, T, V]) Reset(ctx context.Context, ticker <-chan time.Time) {
 	s.cc()